@@ -20,11 +20,19 @@ func main() {
 		log.Fatal("dialing:", err)
 	}
 
+	// Writes carry request IDs, so a retried write is never applied twice
+	clientID := fmt.Sprintf("client-%d", os.Getpid())
+	requests := 0
+
 	// Helper to put
 	put := func(key, val string) {
-		args := &common.PutArgs{Key: key, Value: val}
+		requests++
+		args := &common.PutArgs{Key: key, Value: val, ClientID: clientID, RequestID: fmt.Sprint(requests)}
 		reply := &common.PutReply{}
 		err = client.Call("KV.Put", args, reply)
+		if common.IsStaleRouting(err) {
+			log.Fatalf("put error: %v (the master at %s has been superseded; connect to the current master)", err, masterAddr)
+		}
 		if err != nil {
 			log.Fatal("put error:", err)
 		}
@@ -36,6 +44,9 @@ func main() {
 		args := &common.GetArgs{Key: key}
 		reply := &common.GetReply{}
 		err = client.Call("KV.Get", args, reply)
+		if common.IsStaleRouting(err) {
+			log.Fatalf("get error: %v (the master at %s has been superseded; connect to the current master)", err, masterAddr)
+		}
 		if err != nil {
 			log.Fatal("get error:", err)
 		}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Bounded-load placement (consistent hashing with bounded loads): each key's
+// replicas are still found by walking the ring clockwise from the key's hash,
+// but a node that already holds ceil((1+ε)×average) key replicas is skipped,
+// so no node ends up with more than that. Because the outcome depends on the
+// load at the time a key is placed, placements are remembered; a key keeps its
+// nodes until the replication factor asks for more or fewer of them, or it is
+// gone. Only writes place a key: a lookup of a key never written returns the
+// nodes it would be placed on without counting it against them. A placement is
+// released when the key's TTL runs out, or when a rebalance finds the key gone.
+
+// PlacingPartitioner is a Partitioner that places keys as they are written.
+type PlacingPartitioner interface {
+	Partitioner
+	// Place records a write of key to its n nodes. With ttl > 0 the
+	// placement is released once ttl has run out, unless written again.
+	Place(key string, n int, ttl time.Duration)
+	// Forget releases key's placement.
+	Forget(key string)
+}
+
+// placementSweep is how often expired placements are swept up.
+const placementSweep = time.Second
+
+// SetBalance turns on bounded-load placement with balance factor epsilon
+// (0 turns it off).
+func (c *ConsistentHash) SetBalance(epsilon float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.balance = epsilon
+	if c.placed == nil {
+		c.placed = make(map[string][]string)
+		c.expires = make(map[string]time.Time)
+		c.load = make(map[string]int)
+	}
+}
+
+func (c *ConsistentHash) bounded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.balance > 0
+}
+
+// capacityLocked is the most key replicas node may hold once one more is
+// placed: its weighted share of them, times 1+ε. Caller holds c.mu.
+func (c *ConsistentHash) capacityLocked(node string) int {
+	sum := 0.0
+	for n := range c.vnodes {
+		sum += c.weightLocked(n)
+	}
+	if sum == 0 {
+		return 0
+	}
+	return int(math.Ceil((1 + c.balance) * float64(c.total+1) * c.weightLocked(node) / sum))
+}
+
+// getBounded returns key's placement. With record it places as many replicas
+// as the key still lacks (or releases the surplus if n shrank); without, the
+// missing ones are only looked up.
+func (c *ConsistentHash) getBounded(key string, n int, record bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) == 0 {
+		return nil
+	}
+	if exp, ok := c.expires[key]; ok && time.Now().After(exp) {
+		c.forgetLocked(key)
+	}
+	nodes := c.placed[key]
+	hash := int(c.hash([]byte(key)))
+	if !record {
+		out := make([]string, min(len(nodes), n), n)
+		copy(out, nodes)
+		for len(out) < n && len(out) < len(c.vnodes) {
+			out = append(out, c.nextUnderLocked(hash, out))
+		}
+		return out
+	}
+	if len(nodes) > n {
+		for _, node := range nodes[n:] {
+			c.load[node]--
+			c.total--
+		}
+		nodes = nodes[:n:n]
+	}
+	for len(nodes) < n && len(nodes) < len(c.vnodes) {
+		node := c.nextUnderLocked(hash, nodes)
+		nodes = append(nodes, node)
+		c.load[node]++
+		c.total++
+	}
+	c.placed[key] = nodes
+
+	out := make([]string, len(nodes))
+	copy(out, nodes)
+	return out
+}
+
+// Place records a write of key, placing it if it is not placed yet.
+func (c *ConsistentHash) Place(key string, n int, ttl time.Duration) {
+	if !c.bounded() {
+		return
+	}
+	c.getBounded(key, n, true)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if ttl > 0 {
+		c.expires[key] = now.Add(ttl)
+	} else {
+		delete(c.expires, key)
+	}
+	if now.Sub(c.pruned) > placementSweep {
+		c.pruned = now
+		for k, exp := range c.expires {
+			if now.After(exp) {
+				c.forgetLocked(k)
+			}
+		}
+	}
+}
+
+// Forget releases key's placement.
+func (c *ConsistentHash) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgetLocked(key)
+}
+
+func (c *ConsistentHash) forgetLocked(key string) {
+	for _, node := range c.placed[key] {
+		c.load[node]--
+		c.total--
+	}
+	delete(c.placed, key)
+	delete(c.expires, key)
+}
+
+// placeKey records a write of key with the partitioner, if it places keys as
+// they are written.
+func (m *Master) placeKey(key string, ttl time.Duration) {
+	nsRF := 0
+	if ns := m.namespaces.forStorageKey(key); ns != nil {
+		nsRF = ns.ReplicationFactor
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if p, ok := m.ring.(PlacingPartitioner); ok {
+		p.Place(key, m.replicationFactorLocked(nsRF), ttl)
+	}
+}
+
+// forgetKey releases the placement of a key that is gone.
+func (m *Master) forgetKey(key string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if p, ok := m.ring.(PlacingPartitioner); ok {
+		p.Forget(key)
+	}
+}
+
+// nextUnderLocked walks the ring clockwise from hash to the first node not
+// already chosen that is under capacity, preferring one in a zone none of
+// chosen is in. Caller holds c.mu.
+func (c *ConsistentHash) nextUnderLocked(hash int, chosen []string) string {
+	start := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	used := make(map[string]bool, len(chosen))
+	for _, node := range chosen {
+		used[c.zoneLocked(node)] = true
+	}
+	under, fallback := "", ""
+	for i := 0; i < len(c.keys); i++ {
+		node := c.hashMap[c.keys[(start+i)%len(c.keys)]]
+		if contains(chosen, node) {
+			continue
+		}
+		if c.load[node] < c.capacityLocked(node) {
+			if !used[c.zoneLocked(node)] {
+				return node
+			}
+			if under == "" {
+				under = node
+			}
+		}
+		if fallback == "" {
+			fallback = node
+		}
+	}
+	if under != "" {
+		return under
+	}
+	return fallback // Unreachable while ε > 0: some node is always under capacity
+}
+
+// Restore records that key is already stored on nodes, e.g. as found on the
+// workers when the master starts. nodes are put in ring order.
+func (c *ConsistentHash) Restore(key string, nodes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) == 0 {
+		return
+	}
+	hash := int(c.hash([]byte(key)))
+	start := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= hash })
+	for _, node := range c.placed[key] {
+		c.load[node]--
+		c.total--
+	}
+	var ordered []string
+	for i := 0; i < len(c.keys) && len(ordered) < len(nodes); i++ {
+		node := c.hashMap[c.keys[(start+i)%len(c.keys)]]
+		if contains(nodes, node) && !contains(ordered, node) {
+			ordered = append(ordered, node)
+			c.load[node]++
+			c.total++
+		}
+	}
+	c.placed[key] = ordered
+}
+
+// NodePlacement is the JSON view of one node's place on the ring.
+type NodePlacement struct {
+	Address  string  `json:"address"`
+	Share    float64 `json:"share"`              // Fraction of the hash space it owns
+	Weight   float64 `json:"weight"`             // Relative capacity (1 = the default share)
+	Placed   int     `json:"placed"`             // Key replicas placed on it (bounded-load only)
+	Capacity int     `json:"capacity,omitempty"` // Current cap on Placed (bounded-load only)
+	Zone     string  `json:"zone,omitempty"`
+}
+
+// PlacementStat reports how keys are spread across the ring.
+type PlacementStat struct {
+	Balance float64         `json:"balance"` // ε (0 = plain consistent hashing)
+	Nodes   []NodePlacement `json:"nodes"`
+}
+
+// Distribution reports each node's share of the hash space and, with bounded
+// loads, how many key replicas it was given.
+func (c *ConsistentHash) Distribution() PlacementStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := PlacementStat{Balance: c.balance, Nodes: []NodePlacement{}}
+	share := make(map[string]float64)
+	for i, h := range c.keys {
+		prev := c.keys[(i+len(c.keys)-1)%len(c.keys)]
+		arc := float64(h - prev)
+		if i == 0 {
+			arc += float64(math.MaxUint32) + 1 // Wraps around from the last vnode
+		}
+		share[c.hashMap[h]] += arc / (float64(math.MaxUint32) + 1)
+	}
+	for node, s := range share {
+		np := NodePlacement{Address: node, Share: s, Weight: c.weightLocked(node), Placed: c.load[node], Zone: c.zones[node]}
+		if c.balance > 0 {
+			np.Capacity = c.capacityLocked(node)
+		}
+		st.Nodes = append(st.Nodes, np)
+	}
+	sort.Slice(st.Nodes, func(i, j int) bool { return st.Nodes[i].Address < st.Nodes[j].Address })
+	return st
+}
+
+// restorePlacement rebuilds the bounded-load placements from the keys the
+// workers already hold, so a restarted master finds them where they are.
+func (m *Master) restorePlacement() {
+	ring, ok := m.ring.(*ConsistentHash)
+	if !ok {
+		return
+	}
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	holders := make(map[string][]string)
+	for _, w := range workers {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			log.Printf("[Placement] Could not list keys on %s: %v", w, err)
+			continue
+		}
+		for _, k := range s.Keys {
+			holders[k] = append(holders[k], w)
+		}
+	}
+	for key, nodes := range holders {
+		ring.Restore(key, nodes)
+	}
+	if len(holders) > 0 {
+		log.Printf("[Placement] Restored placements of %d keys", len(holders))
+	}
+}
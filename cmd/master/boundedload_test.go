@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConsistentHash_BoundedLoad(t *testing.T) {
+	nodes := []string{"A", "B", "C", "D", "E"}
+	plain := NewConsistentHash(20)
+	plain.Add(nodes...)
+	bounded := NewConsistentHash(20)
+	bounded.Add(nodes...)
+	bounded.SetBalance(0.1)
+
+	const keys, rf = 2000, 2
+	plainLoad := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		for _, n := range plain.GetN(key, rf) {
+			plainLoad[n]++
+		}
+		bounded.Place(key, rf, 0)
+		got := bounded.GetN(key, rf)
+		if len(got) != rf || got[0] == got[1] {
+			t.Fatalf("GetN(%s) = %v, want %d distinct nodes", key, got, rf)
+		}
+	}
+
+	limit := int(math.Ceil(1.1 * keys * rf / float64(len(nodes))))
+	st := bounded.Distribution()
+	plainMax := 0
+	for _, n := range st.Nodes {
+		if n.Placed > limit {
+			t.Errorf("Node %s holds %d key replicas, above the cap of %d", n.Address, n.Placed, limit)
+		}
+		if plainLoad[n.Address] > plainMax {
+			plainMax = plainLoad[n.Address]
+		}
+	}
+	t.Logf("Max per node: plain %d, bounded cap %d", plainMax, limit)
+
+	// Placements stick, and shrinking RF releases the surplus.
+	first := bounded.GetN("key7", rf)
+	bounded.Place("key7", rf, 0)
+	if again := bounded.GetN("key7", rf); again[0] != first[0] || again[1] != first[1] {
+		t.Errorf("Expected key7 to keep its placement %v, got %v", first, again)
+	}
+	bounded.Place("key7", 1, 0)
+	if bounded.total != keys*rf-1 {
+		t.Errorf("Expected shrinking key7 to release one replica, total is %d", bounded.total)
+	}
+}
+
+func TestConsistentHash_RestoreAndShare(t *testing.T) {
+	ring := NewConsistentHash(20)
+	ring.Add("A", "B", "C")
+	ring.SetBalance(0.25)
+	want := ring.GetN("k", 3) // Ring order of all three nodes
+	ring.Restore("k", []string{want[2], want[0]})
+	if got := ring.GetN("k", 2); got[0] != want[0] || got[1] != want[2] {
+		t.Errorf("Expected restored placement in ring order %v, got %v", []string{want[0], want[2]}, got)
+	}
+
+	total := 0.0
+	for _, n := range ring.Distribution().Nodes {
+		total += n.Share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("Expected ring shares to sum to 1, got %v", total)
+	}
+}
+
+func TestConsistentHash_OnlyWritesPlace(t *testing.T) {
+	ring := NewConsistentHash(20)
+	ring.Add("A", "B", "C")
+	ring.SetBalance(0.25)
+
+	// Reads of missing keys count against no node.
+	for i := 0; i < 100; i++ {
+		ring.GetN("missing"+strconv.Itoa(i), 2)
+	}
+	if ring.total != 0 {
+		t.Fatalf("Expected lookups to place nothing, %d replicas placed", ring.total)
+	}
+
+	want := ring.GetN("k", 2)
+	ring.Place("k", 2, 0)
+	if got := ring.GetN("k", 2); got[0] != want[0] || got[1] != want[1] || ring.total != 2 {
+		t.Fatalf("Expected the write to place k where the lookup said, got %v (%d placed)", got, ring.total)
+	}
+	ring.Forget("k")
+	if ring.total != 0 {
+		t.Errorf("Expected forgetting k to release its replicas, %d placed", ring.total)
+	}
+
+	// A placement is released once its TTL runs out.
+	ring.Place("brief", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	ring.GetN("brief", 2)
+	if ring.total != 0 {
+		t.Errorf("Expected the expired key's replicas to be released, %d placed", ring.total)
+	}
+}
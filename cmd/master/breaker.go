@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errBreakerOpen is returned without dialing when a worker's breaker is open.
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // Requests flow normally
+	breakerOpen                         // Requests are shed until the cooldown expires
+	breakerHalfOpen                     // A single probe request is let through
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker tracks consecutive failures for one worker address.
+type circuitBreaker struct {
+	state    breakerState
+	failures int       // Consecutive failures while closed
+	openedAt time.Time // When the breaker last tripped
+	probing  bool      // A half-open probe is in flight
+	shed     int       // Requests rejected since the breaker last opened
+}
+
+// breakerSet holds one circuit breaker per worker address.
+type breakerSet struct {
+	mu        sync.Mutex
+	threshold int           // Consecutive failures that trip a breaker
+	cooldown  time.Duration // How long a breaker stays open before probing
+	breakers  map[string]*circuitBreaker
+}
+
+func newBreakerSet(threshold int, cooldown time.Duration) *breakerSet {
+	return &breakerSet{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *breakerSet) get(addr string) *circuitBreaker {
+	cb, ok := b.breakers[addr]
+	if !ok {
+		cb = &circuitBreaker{}
+		b.breakers[addr] = cb
+	}
+	return cb
+}
+
+// allow reports whether a request to addr may proceed. Once the cooldown has
+// elapsed an open breaker goes half-open and lets exactly one probe through;
+// probe is true for that request, which must pass it back to record.
+func (b *breakerSet) allow(addr string) (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb := b.get(addr)
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < b.cooldown {
+			cb.shed++
+			return false, fmt.Errorf("%s: %w", addr, errBreakerOpen)
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		return true, nil
+	case breakerHalfOpen:
+		if cb.probing {
+			cb.shed++
+			return false, fmt.Errorf("%s: %w", addr, errBreakerOpen)
+		}
+		cb.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record feeds the outcome of a request to addr back into its breaker. Only
+// the probe frees the probe slot: a request let through before the breaker
+// opened may finish while the probe is still in flight.
+func (b *breakerSet) record(addr string, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb := b.get(addr)
+	if probe {
+		cb.probing = false
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// We cancelled it ourselves (e.g. quorum already decided): says nothing about the worker.
+	case !countsAsFailure(err):
+		cb.state = breakerClosed
+		cb.failures = 0
+	default:
+		cb.failures++
+		if cb.state == breakerHalfOpen || cb.failures >= b.threshold {
+			if cb.state != breakerOpen {
+				cb.shed = 0
+			}
+			cb.state = breakerOpen
+			cb.openedAt = time.Now()
+		}
+	}
+}
+
+// countsAsFailure reports whether err says something is wrong with the worker
+// itself. Application errors (e.g. "node full") travel back as rpc.ServerError
+// and prove the worker is alive; cancellations are our own doing.
+func countsAsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var serverErr rpc.ServerError
+	return !errors.As(err, &serverErr)
+}
+
+// neverSent reports whether err proves a call never reached the worker: it
+// was shed by the breaker, or the worker could not be dialled. Any other
+// transport failure may have come after the worker acted on the call.
+func neverSent(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, errBreakerOpen) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// BreakerStat is the JSON view of one worker's breaker.
+type BreakerStat struct {
+	Address  string    `json:"address"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	Shed     int       `json:"shed"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+}
+
+func (b *breakerSet) snapshot() []BreakerStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]BreakerStat, 0, len(b.breakers))
+	for addr, cb := range b.breakers {
+		st := BreakerStat{
+			Address:  addr,
+			State:    cb.state.String(),
+			Failures: cb.failures,
+			Shed:     cb.shed,
+		}
+		if cb.state != breakerClosed {
+			st.OpenedAt = cb.openedAt
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// RetryPolicy controls retries of idempotent calls.
+type RetryPolicy struct {
+	Attempts int           // Total attempts, including the first
+	Base     time.Duration // Backoff before the first retry; doubles each time
+	Max      time.Duration // Cap on a single backoff
+}
+
+// backoff returns a fully jittered delay for the given retry (1-based).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Base << uint(retry-1)
+	if d <= 0 || d > p.Max {
+		d = p.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// callIdempotent is callWorker with jittered-backoff retries. Only use it for
+// operations that are safe to repeat (reads, stats). It gives up early when the
+// breaker sheds the request, the worker returned an application error, or ctx
+// is done. Each retry starts from reply as the caller passed it, not from
+// whatever a failed attempt decoded into it.
+func (m *Master) callIdempotent(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	given := reflect.ValueOf(reply).Elem()
+	initial := reflect.New(given.Type()).Elem()
+	initial.Set(given)
+	var err error
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			given.Set(initial)
+		}
+		err = m.callWorker(ctx, addr, method, args, reply)
+		if !countsAsFailure(err) || errors.Is(err, errBreakerOpen) || attempt >= m.retry.Attempts {
+			return err
+		}
+		select {
+		case <-time.After(m.retry.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestBreaker_TripsAndRecovers(t *testing.T) {
+	b := newBreakerSet(2, 50*time.Millisecond)
+	dialErr := errors.New("connection refused")
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.allow("w1"); err != nil {
+			t.Fatalf("Expected closed breaker to allow request %d, got %v", i, err)
+		}
+		b.record("w1", false, dialErr)
+	}
+
+	if _, err := b.allow("w1"); !errors.Is(err, errBreakerOpen) {
+		t.Fatalf("Expected open breaker to shed, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// After the cooldown exactly one probe is let through.
+	probe, err := b.allow("w1")
+	if err != nil || !probe {
+		t.Fatalf("Expected half-open probe to be allowed, got %v (probe %v)", err, probe)
+	}
+	if _, err := b.allow("w1"); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("Expected a second concurrent probe to be shed, got %v", err)
+	}
+	// A request let through before the breaker opened finishes meanwhile.
+	b.record("w1", false, context.Canceled)
+	if _, err := b.allow("w1"); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("Expected the probe slot held until the probe finishes, got %v", err)
+	}
+
+	b.record("w1", true, nil)
+	if st := b.snapshot()[0].State; st != "closed" {
+		t.Errorf("Expected breaker to close after a successful probe, got %s", st)
+	}
+}
+
+func TestBreaker_IgnoresApplicationErrors(t *testing.T) {
+	b := newBreakerSet(1, time.Minute)
+	b.record("w1", false, rpc.ServerError("node full: max keys 10 reached"))
+
+	if _, err := b.allow("w1"); err != nil {
+		t.Errorf("Application errors should not trip the breaker, got %v", err)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, Base: 10 * time.Millisecond, Max: 40 * time.Millisecond}
+	for retry := 1; retry <= 10; retry++ {
+		if d := p.backoff(retry); d < 0 || d > p.Max {
+			t.Errorf("backoff(%d) = %v, expected within [0, %v]", retry, d, p.Max)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chainProbeInterval is how often spliced-out workers are checked for recovery.
+const chainProbeInterval = 2 * time.Second
+
+// chainManager tracks which workers have been spliced out of the replication
+// chains. A key's chain is its replicas in ring order minus those workers.
+//
+// A failed worker is spliced out as soon as a chain write reports it; once it
+// answers again it is joining (it receives new writes but is not yet part of
+// any chain) until it has been re-synced from the chains it belongs to.
+//
+// Every membership change starts a new generation. Chain IDs include it, so
+// workers sequence writes through a changed chain as a fresh stream.
+type chainManager struct {
+	mu         sync.Mutex
+	failed     map[string]time.Time // Spliced-out worker -> when
+	joining    map[string]bool      // Recovered workers being re-synced
+	generation int64
+	splices    int
+	rejoins    int
+}
+
+func newChainManager() *chainManager {
+	return &chainManager{
+		failed:  make(map[string]time.Time),
+		joining: make(map[string]bool),
+		// Start from the clock so a restarted master never reuses a chain ID.
+		generation: time.Now().UnixNano(),
+	}
+}
+
+// id names the current generation of chain.
+func (c *chainManager) id(chain []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return common.ChainID(c.generation, chain)
+}
+
+// markFailed splices addr out; it reports false if addr was already out.
+func (c *chainManager) markFailed(addr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.joining, addr)
+	if _, out := c.failed[addr]; out {
+		return false
+	}
+	c.failed[addr] = time.Now()
+	c.generation++
+	c.splices++
+	return true
+}
+
+func (c *chainManager) startJoin(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.failed, addr)
+	c.joining[addr] = true
+}
+
+func (c *chainManager) finishJoin(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.joining[addr] {
+		delete(c.joining, addr)
+		c.generation++
+		c.rejoins++
+	}
+}
+
+// live filters replicas down to the workers currently in the chain.
+func (c *chainManager) live(replicas []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for _, r := range replicas {
+		if _, spliced := c.failed[r]; !spliced && !c.joining[r] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// reachable filters replicas down to those not spliced out (live or joining).
+func (c *chainManager) reachable(replicas []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []string
+	for _, r := range replicas {
+		if _, spliced := c.failed[r]; !spliced {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (c *chainManager) failedWorkers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, 0, len(c.failed))
+	for addr := range c.failed {
+		out = append(out, addr)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ChainStat is the JSON view of the chain manager.
+type ChainStat struct {
+	Failed  []string `json:"failed"`  // Spliced out of every chain
+	Joining []string `json:"joining"` // Being re-synced before rejoining
+	Splices int      `json:"splices"`
+	Rejoins int      `json:"rejoins"`
+}
+
+func (c *chainManager) stat() ChainStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := ChainStat{Failed: []string{}, Joining: []string{}, Splices: c.splices, Rejoins: c.rejoins}
+	for addr := range c.failed {
+		s.Failed = append(s.Failed, addr)
+	}
+	for addr := range c.joining {
+		s.Joining = append(s.Joining, addr)
+	}
+	sort.Strings(s.Failed)
+	sort.Strings(s.Joining)
+	return s
+}
+
+// chainFor returns the live chain for key, head first.
+func (m *Master) chainFor(key string) []string {
+	return m.chains.live(m.getReplicas(key))
+}
+
+// putChain: Write to Head, Head forwards to next... and the write counts only
+// once the Tail's acknowledgement has come back up the chain. When a link is
+// reported down it is spliced out and the write is re-driven through the
+// repaired chain; every write carries its version, so replaying it on nodes
+// that already applied it is harmless. Each re-drive must splice out a link
+// of the chain it went through, and there are at most as many as the key has
+// replicas, so the error is returned when a failure names a worker outside
+// the chain or the re-drives run out.
+func (m *Master) putChain(ctx context.Context, args *common.PutArgs) error {
+	redrives := len(m.getReplicas(args.Key))
+	for attempt := 0; ; attempt++ {
+		chain := m.chainFor(args.Key)
+		if len(chain) == 0 {
+			return fmt.Errorf("no live chain for %s: every replica has been spliced out", args.Key)
+		}
+		err := m.putThroughChain(ctx, chain, args)
+		if err == nil {
+			m.copyToRejoined(args, chain)
+			return nil
+		}
+		failed, ok := failedLink(chain[0], err)
+		if !ok || ctx.Err() != nil || !contains(chain, failed) || attempt >= redrives {
+			return err
+		}
+		m.splice(failed, err)
+		if args.Incr {
+			// The head may have applied it already: sending it again could count it twice.
+			return err
+		}
+	}
+}
+
+func (m *Master) putThroughChain(ctx context.Context, chain []string, args *common.PutArgs) error {
+	// Construct the chain string: "w2,w3"
+	args.ForwardTo = strings.Join(chain[1:], ",")
+	args.ChainID = ""
+	if len(chain) > 1 {
+		args.ChainID = m.chains.id(chain)
+	}
+	reply := &common.PutReply{}
+	if err := m.callPut(ctx, chain[0], args, reply); err != nil {
+		return err
+	}
+	if !reply.Committed {
+		return fmt.Errorf("chain write of %s was not acknowledged by the tail", args.Key)
+	}
+	if args.Incr {
+		settleIncr(args, reply) // Rejoined replicas get the result
+	}
+	return nil
+}
+
+// failedLink works out which worker broke a chain write: the one named in a
+// chain link error, or the head itself if it could not be reached.
+func failedLink(head string, err error) (string, bool) {
+	if addr, ok := common.FailedChainLink(err); ok {
+		return addr, true
+	}
+	if countsAsFailure(err) {
+		return head, true
+	}
+	return "", false
+}
+
+// splice removes addr from every chain and lets the remaining workers settle
+// the writes that were stuck behind it: predecessors that are now the tail
+// commit them, and the rest are re-driven through the repaired chains.
+func (m *Master) splice(addr string, cause error) {
+	if !m.chains.markFailed(addr) {
+		return // Someone else got there first
+	}
+	log.Printf("[Chain] Spliced out %s: %v", addr, cause)
+
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	for _, w := range m.chains.live(workers) {
+		var reply common.SpliceReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callWorker(ctx, w, "KV.SuccessorFailed", &common.SpliceArgs{Failed: addr}, &reply)
+		cancel()
+		if err != nil {
+			log.Printf("[Chain] %s could not settle writes behind %s: %v", w, addr, err)
+			continue
+		}
+		for i := range reply.Pending {
+			go m.redrive(&reply.Pending[i])
+		}
+	}
+}
+
+// redrive pushes a write that was stranded mid-chain through the repaired chain.
+func (m *Master) redrive(args *common.PutArgs) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+	defer cancel()
+	args.Deadline = common.DeadlineOf(ctx)
+	if err := m.putChain(ctx, args); err != nil {
+		log.Printf("[Chain] Re-driving %s v%d failed: %v", args.Key, args.Version, err)
+	}
+}
+
+// copyToRejoined sends a committed chain write to the key's replicas that were
+// not in the chain it went through but are no longer spliced out: workers being
+// re-synced, or re-synced while the write was in flight.
+func (m *Master) copyToRejoined(args *common.PutArgs, chain []string) {
+	copyArgs := *args
+	copyArgs.ForwardTo = ""
+	copyArgs.ChainID = ""
+	copyArgs.Deadline = time.Time{}
+	for _, addr := range m.chains.reachable(m.getReplicas(args.Key)) {
+		if contains(chain, addr) {
+			continue
+		}
+		go func(workerAddr string) {
+			// Straight to the worker: its breaker is likely still open from the failure.
+			ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+			defer cancel()
+			common.Call(ctx, workerAddr, "KV.Put", m.stamp(&copyArgs), &common.PutReply{})
+		}(addr)
+	}
+}
+
+// superviseChains periodically probes spliced-out workers and brings back the
+// ones that answer again.
+func (m *Master) superviseChains() {
+	ticker := time.NewTicker(chainProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, addr := range m.chains.failedWorkers() {
+			ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+			err := common.Call(ctx, addr, "KV.GetStats", &common.StatsArgs{}, &common.StatsReply{})
+			cancel()
+			if err == nil {
+				m.rejoin(addr)
+			}
+		}
+	}
+}
+
+// rejoin re-syncs a recovered worker from the chains it belongs to and puts it
+// back in them. New writes are copied to it while the re-sync runs; versions
+// make the order in which the two arrive irrelevant.
+func (m *Master) rejoin(addr string) {
+	m.chains.startJoin(addr)
+	log.Printf("[Chain] %s is back; re-syncing", addr)
+
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	synced, err := m.resync(addr, m.chains.live(workers), m.readFromTail)
+	if err != nil {
+		m.chains.markFailed(addr)
+		log.Printf("[Chain] Re-sync of %s failed, staying spliced out: %v", addr, err)
+		return
+	}
+	m.chains.finishJoin(addr)
+	log.Printf("[Chain] %s rejoined after re-syncing %d keys", addr, synced)
+}
+
+// readFunc reads the authoritative value of a key for a re-sync.
+type readFunc func(ctx context.Context, key string) (*common.GetReply, error)
+
+// resync copies every key addr is a replica of onto it, as read by read. The
+// keys are listed from sources, the workers trusted to be current.
+func (m *Master) resync(addr string, sources []string, read readFunc) (int, error) {
+	keys := make(map[string]bool)
+	for _, w := range sources {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("listing keys on %s: %v", w, err)
+		}
+		for _, k := range s.Keys {
+			keys[k] = true
+		}
+	}
+
+	synced := 0
+	for key := range keys {
+		if !contains(m.getReplicas(key), addr) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Get)
+		value, err := read(ctx, key)
+		cancel()
+		if err != nil {
+			return synced, fmt.Errorf("reading %s: %v", key, err)
+		}
+		if value == nil || !value.Found {
+			continue
+		}
+		// Straight to the worker: its breaker is likely still open from the failure.
+		ctx, cancel = context.WithTimeout(context.Background(), m.timeouts.Put)
+		err = common.Call(ctx, addr, "KV.Put", m.stamp(&common.PutArgs{Key: key, Value: value.Value, Version: value.Version, TTL: value.TTL, CRDT: value.CRDT, Deadline: common.DeadlineOf(ctx)}), &common.PutReply{})
+		cancel()
+		if common.IsStaleVersion(err) {
+			continue // It already holds a newer write
+		}
+		if err != nil {
+			return synced, fmt.Errorf("copying %s: %v", key, err)
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+// readFromTail reads the committed value of key from the tail of its live chain.
+func (m *Master) readFromTail(ctx context.Context, key string) (*common.GetReply, error) {
+	chain := m.chainFor(key)
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	value := &common.GetReply{}
+	if err := m.callIdempotent(ctx, chain[len(chain)-1], "KV.Get", &common.GetArgs{Key: key, Deadline: common.DeadlineOf(ctx)}, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"customise-db/common"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestFailedLink(t *testing.T) {
+	linkErr := common.ChainLinkError("w3", errors.New("connection refused"))
+	if addr, ok := failedLink("w1", linkErr); !ok || addr != "w3" {
+		t.Errorf("Expected the named link w3, got %q (%v)", addr, ok)
+	}
+	if addr, ok := failedLink("w1", errors.New("dial tcp: connection refused")); !ok || addr != "w1" {
+		t.Errorf("Expected an unreachable head to be blamed, got %q (%v)", addr, ok)
+	}
+	if _, ok := failedLink("w1", errBreakerOpen); !ok {
+		t.Errorf("Expected an open breaker on the head to count as a failed link")
+	}
+}
+
+func TestPutChain_SplicesFailedLink(t *testing.T) {
+	a, wa := startWorker(t)
+	b, wb := startWorker(t)
+	dead := deadAddr(t)
+
+	m := newTestMaster([]string{a, b, dead}, 3)
+	m.mode = "chain"
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if stat := m.chains.stat(); len(stat.Failed) != 1 || stat.Failed[0] != dead {
+		t.Errorf("Expected %s to be spliced out, got %+v", dead, stat)
+	}
+	if !stored(t, wa, "k").Found || !stored(t, wb, "k").Found {
+		t.Errorf("Expected both live workers to hold the write")
+	}
+
+	reply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k"}, reply); err != nil || reply.Value != "v" {
+		t.Errorf("Expected Get through the repaired chain to return v, got %q (%v)", reply.Value, err)
+	}
+}
+
+func TestRejoin_ResyncsWorker(t *testing.T) {
+	a, _ := startWorker(t)
+	b, _ := startWorker(t)
+	c, wc := startWorker(t)
+
+	m := newTestMaster([]string{a, b, c}, 3)
+	m.mode = "chain"
+	m.chains.markFailed(c)
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if stored(t, wc, "k").Found {
+		t.Fatalf("Expected the spliced-out worker to miss the write")
+	}
+
+	m.rejoin(c)
+	if !stored(t, wc, "k").Found {
+		t.Errorf("Expected the rejoined worker to be re-synced")
+	}
+	if chain := m.chainFor("k"); len(chain) != 3 {
+		t.Errorf("Expected the worker back in the chain, got %v", chain)
+	}
+	if stat := m.chains.stat(); stat.Rejoins != 1 || len(stat.Failed) != 0 {
+		t.Errorf("Unexpected chain stat after rejoin: %+v", stat)
+	}
+}
+
+// blamingWorker fails every write, blaming a link that is not in the chain.
+type blamingWorker struct{}
+
+func (blamingWorker) Put(args *common.PutArgs, reply *common.PutReply) error {
+	return common.ChainLinkError("elsewhere:1", errors.New("connection refused"))
+}
+
+func TestPutChain_StopsWhenTheFailedLinkIsNotInTheChain(t *testing.T) {
+	srv := rpc.NewServer()
+	srv.RegisterName("KV", blamingWorker{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.Accept(l)
+
+	m := newTestMaster([]string{l.Addr().String()}, 1)
+	m.mode = "chain"
+	start := time.Now()
+	err = m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{})
+	if addr, ok := common.FailedChainLink(err); !ok || addr != "elsewhere:1" {
+		t.Errorf("Expected the link error returned, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > m.timeouts.Put/2 {
+		t.Errorf("Expected the write to give up at once, took %v", elapsed)
+	}
+}
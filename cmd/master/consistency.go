@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+)
+
+// levelForMode maps a replication mode to the consistency level its requests
+// get when they don't ask for one.
+func levelForMode(mode string) string {
+	switch mode {
+	case "async":
+		return common.ConsistencyOne
+	case "chain":
+		return common.ConsistencyTailRead
+	case "quorum":
+		return common.ConsistencyQuorum
+	case "primary":
+		return common.ConsistencyPrimary
+	default:
+		return common.ConsistencyAll
+	}
+}
+
+// resolveLevel returns the level a request will actually run at: the one it
+// asked for, else the default for mode, else the default for the cluster mode.
+func (m *Master) resolveLevel(requested, mode string) (string, error) {
+	if !common.ValidConsistency(requested) {
+		return "", fmt.Errorf("unknown consistency level %q", requested)
+	}
+	if requested != "" {
+		return requested, nil
+	}
+	if mode == "" {
+		mode = m.currentMode()
+	}
+	return levelForMode(mode), nil
+}
+
+func (m *Master) currentMode() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mode
+}
+
+// putAny: Write to whichever replica answers first, trying them in ring order;
+// the others are brought up to date in the background.
+func (m *Master) putAny(ctx context.Context, args *common.PutArgs) error {
+	replicas := m.getReplicas(args.Key)
+	if err := m.replication.full(replicas); err != nil {
+		return err
+	}
+	var lastErr error
+	for i, addr := range replicas {
+		if lastErr = m.callPut(ctx, addr, args, &common.PutReply{}); lastErr != nil {
+			if ctx.Err() != nil || common.IsStaleVersion(lastErr) {
+				break // A stale version: put restamps the write and retries
+			}
+			continue
+		}
+		var rest []string
+		rest = append(rest, replicas[:i]...)
+		rest = append(rest, replicas[i+1:]...)
+		return m.replicateInBackground(args, rest)
+	}
+	return fmt.Errorf("no replica accepted the write: %v", lastErr)
+}
+
+// replicateInBackground queues args for each of replicas on their durable
+// replication queues, which deliver it in order and retry until it lands.
+// It fails the write if any queue could not take it, since that replica
+// would otherwise never get it.
+func (m *Master) replicateInBackground(args *common.PutArgs, replicas []string) error {
+	var firstErr error
+	for _, addr := range replicas {
+		if err := m.replication.enqueue(addr, args); err != nil {
+			log.Printf("[Replication] Could not queue %s for %s: %v", args.Key, addr, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("write applied, but could not be queued for %s: %v", addr, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// getAll: Read every replica and return the newest version.
+func (m *Master) getAll(ctx context.Context, args *common.GetArgs, reply *common.GetReply) error {
+	all := *args
+	all.R = len(m.getReplicas(args.Key))
+	return m.getQuorum(ctx, &all, reply, readRing)
+}
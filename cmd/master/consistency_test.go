@@ -0,0 +1,55 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+)
+
+func TestResolveLevel(t *testing.T) {
+	m := newTestMaster(nil, 3)
+	m.mode = "chain"
+
+	if level, err := m.resolveLevel("", ""); err != nil || level != common.ConsistencyTailRead {
+		t.Errorf("Expected chain mode to default to tail-read, got %q (%v)", level, err)
+	}
+	if level, err := m.resolveLevel(common.ConsistencyOne, ""); err != nil || level != common.ConsistencyOne {
+		t.Errorf("Expected request level to override the mode, got %q (%v)", level, err)
+	}
+	if _, err := m.resolveLevel("linearizable", ""); err == nil {
+		t.Errorf("Expected unknown level to be rejected")
+	}
+}
+
+func TestPut_ConsistencyOverridesMode(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "async"
+
+	reply := &common.PutReply{}
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v", Consistency: common.ConsistencyAll}, reply); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if reply.Consistency != common.ConsistencyAll {
+		t.Errorf("Expected reply to echo level 'all', got %q", reply.Consistency)
+	}
+	// 'all' waits for every replica, so they must all hold the key already.
+	for addr, f := range fakes {
+		if !f.has("k") {
+			t.Errorf("Replica %s is missing the key after an 'all' write", addr)
+		}
+	}
+
+	getReply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k", Consistency: common.ConsistencyQuorum}, getReply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if getReply.Consistency != common.ConsistencyQuorum || getReply.Value != "v" {
+		t.Errorf("Expected 'v' at level 'quorum', got %q at %q", getReply.Value, getReply.Consistency)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"customise-db/crdt"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CRDTs (see common/crdt.go and the crdt package): an update is applied at
+// the first of the key's replicas that takes it, so it stays available while
+// any replica is up, and the resulting state is copied to the rest like any
+// write. Every copy (queued, rebalanced or repaired) is merged into the
+// replica's state rather than replacing it, so updates made concurrently at
+// different replicas all survive. Reads merge the states of the replicas they
+// reach. Anti-entropy periodically merges each CRDT key's replicas with one
+// another, so an update whose copy was lost still spreads.
+
+// CRDTUpdate is the RPC entry point for applying an operation to a CRDT.
+func (m *Master) CRDTUpdate(args *common.CRDTArgs, reply *common.CRDTReply) error {
+	return m.crdtUpdate(context.Background(), args, reply)
+}
+
+func (m *Master) crdtUpdate(ctx context.Context, args *common.CRDTArgs, reply *common.CRDTReply) error {
+	if !crdt.Valid(args.Type) {
+		return fmt.Errorf("unknown CRDT type %q", args.Type)
+	}
+	op := args.Op
+	put := &common.PutArgs{
+		Key: args.Key, Namespace: args.Namespace, Consistency: args.Consistency, TTL: args.TTL,
+		ClientID: args.ClientID, RequestID: args.RequestID,
+		CRDT: args.Type, Op: &op,
+	}
+	putReply := &common.PutReply{}
+	if err := m.put(ctx, put, putReply); err != nil {
+		return err
+	}
+	reply.Type, reply.State, reply.Found, reply.Version = args.Type, put.Value, true, put.Version
+	reply.Consistency = putReply.Consistency
+	return nil
+}
+
+// putCRDT applies a CRDT operation at the first of the key's replicas that
+// takes it, then copies the resulting state to the others as level requires.
+// Chain and primary levels wait for every replica: merges need no ordering.
+// The operation moves on to the next replica only if it never reached the
+// first one. Once sent, it may have been applied there, and applied again
+// elsewhere it would count twice; it carries the write's request ID, so a
+// retry to the same replica, by the master or by a client reusing the ID,
+// applies it once.
+func (m *Master) putCRDT(ctx context.Context, level string, args *common.PutArgs) error {
+	replicas := m.getReplicas(args.Key)
+	if level == common.ConsistencyOne || level == common.ConsistencyAny {
+		if err := m.replication.full(replicas); err != nil {
+			return err
+		}
+	}
+	var lastErr error
+	for i, addr := range replicas {
+		reply := &common.PutReply{}
+		err := m.callWorker(ctx, addr, "KV.Put", args, reply)
+		if countsAsFailure(err) && !neverSent(err) {
+			// Only this replica can tell whether it applied the update: it
+			// answers a repeat of the request ID without applying it again.
+			if err = m.callPut(ctx, addr, args, reply); countsAsFailure(err) {
+				return fmt.Errorf("update of %s may have been applied at %s: %w", args.Key, addr, err)
+			}
+		}
+		if err == nil {
+			args.Op = nil
+			args.Value, args.Version = reply.Value, reply.Version
+			others := append(append([]string(nil), replicas[:i]...), replicas[i+1:]...)
+			return m.copyResolved(ctx, level, args, replicas, others)
+		}
+		if !countsAsFailure(err) || ctx.Err() != nil {
+			return err // Refused (a plain value, say): the others would refuse it too
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("no replica accepted the update of %s: %v", args.Key, lastErr)
+}
+
+// CRDTGet is the RPC entry point for reading a CRDT.
+func (m *Master) CRDTGet(args *common.GetArgs, reply *common.CRDTReply) error {
+	return m.crdtGet(context.Background(), args, reply)
+}
+
+// crdtGet merges the states of the key's replicas. Levels one and any need
+// one replica to answer, quorum R of them, and the others every replica.
+func (m *Master) crdtGet(ctx context.Context, args *common.GetArgs, reply *common.CRDTReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
+	}
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	level, err := m.resolveLevel(args.Consistency, ns.Mode)
+	if err != nil {
+		return err
+	}
+	reply.Consistency = level
+	ctx, cancel := common.WithDeadline(ctx, args.Deadline, m.timeouts.Get)
+	defer cancel()
+
+	key := ns.storageKey(args.Key)
+	replicas := m.getReplicas(key)
+	required := len(replicas)
+	switch level {
+	case common.ConsistencyOne, common.ConsistencyAny:
+		required = 1
+	case common.ConsistencyQuorum:
+		if required, err = m.quorum.size(args.R, m.quorum.R, len(replicas)); err != nil {
+			return err
+		}
+	}
+	states, answered, err := m.readStates(ctx, key, replicas)
+	if answered < required {
+		return fmt.Errorf("crdt read of %s: %d/%d replicas answered: %v", args.Key, answered, required, err)
+	}
+	merged, err := mergeStates(states)
+	if err != nil {
+		return fmt.Errorf("crdt read of %s: %v", args.Key, err)
+	}
+	reply.Type, reply.State, reply.Found, reply.Version = merged.CRDT, merged.Value, merged.Found, merged.Version
+	return nil
+}
+
+// readStates reads key from each of replicas in parallel. states holds the
+// answer of each replica that gave one, by address.
+func (m *Master) readStates(ctx context.Context, key string, replicas []string) (states map[string]*common.GetReply, answered int, firstErr error) {
+	type result struct {
+		addr  string
+		reply *common.GetReply
+		err   error
+	}
+	results := make(chan result, len(replicas))
+	for _, addr := range replicas {
+		go func(workerAddr string) {
+			r := &common.GetReply{}
+			err := m.callIdempotent(ctx, workerAddr, "KV.Get", &common.GetArgs{Key: key, Deadline: common.DeadlineOf(ctx)}, r)
+			results <- result{workerAddr, r, err}
+		}(addr)
+	}
+	states = make(map[string]*common.GetReply, len(replicas))
+	for range replicas {
+		res := <-results
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		states[res.addr] = res.reply
+		answered++
+	}
+	return states, answered, firstErr
+}
+
+// mergeStates merges the CRDT states among replies. The result carries the
+// newest version and its TTL; it is not Found if no replica holds the key.
+func mergeStates(replies map[string]*common.GetReply) (common.GetReply, error) {
+	var merged common.GetReply
+	for _, r := range replies {
+		if !r.Found {
+			continue
+		}
+		if r.CRDT == "" {
+			return merged, fmt.Errorf("holds a plain value, not a CRDT")
+		}
+		if !merged.Found {
+			merged = *r
+			continue
+		}
+		if r.CRDT != merged.CRDT {
+			return merged, fmt.Errorf("replicas hold a %s and a %s", merged.CRDT, r.CRDT)
+		}
+		state, err := crdt.Merge(merged.CRDT, merged.Value, r.Value)
+		if err != nil {
+			return merged, err
+		}
+		merged.Value = state
+		if r.Version > merged.Version {
+			merged.Version, merged.TTL = r.Version, r.TTL
+		}
+	}
+	return merged, nil
+}
+
+// antiEntropyStat counts the work of the background CRDT repair.
+type antiEntropyStat struct {
+	mu   sync.Mutex
+	last AntiEntropyStat
+}
+
+// AntiEntropyStat is the JSON view of anti-entropy.
+type AntiEntropyStat struct {
+	Passes   int       `json:"passes"`
+	Keys     int       `json:"keys"`     // CRDT keys found in the last pass
+	Repaired int       `json:"repaired"` // Replicas brought up to date, over every pass
+	Errors   int       `json:"errors"`
+	LastPass time.Time `json:"last_pass"`
+}
+
+func (a *antiEntropyStat) status() AntiEntropyStat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// superviseCRDTs runs an anti-entropy pass every interval (never if 0).
+func (m *Master) superviseCRDTs(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.antiEntropyPass()
+	}
+}
+
+// antiEntropyPass finds every CRDT key the workers hold and reconciles it.
+func (m *Master) antiEntropyPass() {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	errors := 0
+	keys := make(map[string]bool)
+	for _, w := range workers {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			errors++
+			continue
+		}
+		for _, k := range s.CRDTKeys {
+			keys[k] = true
+		}
+	}
+	repaired := 0
+	for key := range keys {
+		n, err := m.reconcileCRDT(key)
+		repaired += n
+		if err != nil {
+			log.Printf("[AntiEntropy] Key %s: %v", key, err)
+			errors++
+		}
+	}
+
+	m.entropy.mu.Lock()
+	m.entropy.last.Passes++
+	m.entropy.last.Keys = len(keys)
+	m.entropy.last.Repaired += repaired
+	m.entropy.last.Errors += errors
+	m.entropy.last.LastPass = time.Now()
+	m.entropy.mu.Unlock()
+}
+
+// reconcileCRDT merges the states of key's replicas and writes the result to
+// each replica that lacks part of it.
+func (m *Master) reconcileCRDT(key string) (repaired int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+	defer cancel()
+	replicas := m.getReplicas(key)
+	states, _, err := m.readStates(ctx, key, replicas)
+	merged, mergeErr := mergeStates(states)
+	if mergeErr != nil || !merged.Found {
+		return 0, mergeErr
+	}
+	for addr, state := range states {
+		if state.Found && state.Value == merged.Value {
+			continue
+		}
+		repair := &common.PutArgs{Key: key, Value: merged.Value, Version: merged.Version, TTL: merged.TTL, CRDT: merged.CRDT, Deadline: common.DeadlineOf(ctx)}
+		if putErr := m.callWorker(ctx, addr, "KV.Put", repair, &common.PutReply{}); putErr != nil {
+			if err == nil {
+				err = putErr
+			}
+			continue
+		}
+		repaired++
+	}
+	return repaired, err
+}
+
+// handleCRDT serves /crdt. With op it applies an operation:
+// /crdt?key=...&type=pn-counter&op=incr&by=-2, /crdt?key=...&type=or-set&op=add&value=x.
+// Without op it reads the key. Either way it answers the merged value as JSON.
+func (m *Master) handleCRDT(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		http.Error(w, "missing params", 400)
+		return
+	}
+	if !common.ValidConsistency(q.Get("consistency")) {
+		http.Error(w, fmt.Sprintf("unknown consistency level %q", q.Get("consistency")), 400)
+		return
+	}
+
+	reply := &common.CRDTReply{}
+	if q.Get("op") == "" {
+		rq, err := intParam(r, "r")
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		args := &common.GetArgs{Key: key, R: rq, Consistency: q.Get("consistency"), Namespace: q.Get("ns")}
+		if err := m.crdtGet(r.Context(), args, reply); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		if !reply.Found {
+			http.Error(w, "Not Found", 404)
+			return
+		}
+	} else {
+		args := &common.CRDTArgs{Key: key, Type: q.Get("type"), Op: crdt.Op{Kind: q.Get("op"), Value: q.Get("value"), By: 1},
+			Namespace: q.Get("ns"), Consistency: q.Get("consistency"), ClientID: q.Get("client"), RequestID: q.Get("request_id")}
+		if err := checkRequestID(args.ClientID, args.RequestID); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if by := q.Get("by"); by != "" {
+			n, err := strconv.ParseInt(by, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid by: %q", by), 400)
+				return
+			}
+			args.Op.By = n
+		}
+		var err error
+		if args.TTL, err = durationParam(r, "ttl"); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := m.crdtUpdate(r.Context(), args, reply); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	state, err := crdt.Decode(reply.Type, reply.State)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consistency-Level", reply.Consistency)
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": reply.Type, "value": state.Value(), "version": reply.Version})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"customise-db/common"
+	"customise-db/crdt"
+	"customise-db/worker"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCRDT_ConcurrentAsyncUpdatesConverge(t *testing.T) {
+	var addrs []string
+	workers := make(map[string]*worker.KVWorker)
+	for i := 0; i < 3; i++ {
+		addr, w := startWorker(t)
+		addrs = append(addrs, addr)
+		workers[addr] = w
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "async"
+
+	update := &common.CRDTArgs{Key: "likes", Type: crdt.TypePNCounter, Op: crdt.Op{Kind: crdt.OpIncr, By: 5}}
+	if err := m.CRDTUpdate(update, &common.CRDTReply{}); err != nil {
+		t.Fatalf("CRDTUpdate failed: %v", err)
+	}
+	// Meanwhile another replica takes an update of its own, as it would if the
+	// first had been unreachable.
+	other := workers[m.getReplicas("likes")[1]]
+	op := crdt.Op{Kind: crdt.OpIncr, By: -2}
+	if err := other.Put(&common.PutArgs{Key: "likes", CRDT: crdt.TypePNCounter, Op: &op}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	read := &common.CRDTReply{}
+	if err := m.CRDTGet(&common.GetArgs{Key: "likes", Consistency: common.ConsistencyAll}, read); err != nil {
+		t.Fatalf("CRDTGet failed: %v", err)
+	}
+	if state, _ := crdt.Decode(read.Type, read.State); state.Value() != int64(3) {
+		t.Errorf("Expected the read to merge both updates into 3, got %v", state.Value())
+	}
+
+	m.antiEntropyPass()
+	for addr, w := range workers {
+		got := stored(t, w, "likes")
+		state, err := crdt.Decode(got.CRDT, got.Value)
+		if err != nil || state.Value() != int64(3) {
+			t.Errorf("Expected %s to hold 3 after anti-entropy, got %v (%v)", addr, state, err)
+		}
+	}
+	if s := m.entropy.status(); s.Keys != 1 || s.Repaired == 0 {
+		t.Errorf("Expected anti-entropy to find one key and repair replicas, got %+v", s)
+	}
+}
+
+func TestCRDT_ORSetKeepsAddsAcrossReplicas(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, _ := startWorker(t)
+		addrs = append(addrs, addr)
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "quorum"
+	for _, op := range []crdt.Op{{Kind: crdt.OpAdd, Value: "a"}, {Kind: crdt.OpAdd, Value: "b"}, {Kind: crdt.OpRemove, Value: "a"}} {
+		if err := m.CRDTUpdate(&common.CRDTArgs{Key: "tags", Type: crdt.TypeORSet, Op: op}, &common.CRDTReply{}); err != nil {
+			t.Fatalf("CRDTUpdate %+v failed: %v", op, err)
+		}
+	}
+	read := &common.CRDTReply{}
+	if err := m.CRDTGet(&common.GetArgs{Key: "tags"}, read); err != nil || !read.Found {
+		t.Fatalf("CRDTGet failed: %+v (%v)", read, err)
+	}
+	if state, _ := crdt.Decode(read.Type, read.State); !reflect.DeepEqual(state.Value(), []string{"b"}) {
+		t.Errorf("Expected [b], got %v", state.Value())
+	}
+	if err := m.CRDTUpdate(&common.CRDTArgs{Key: "tags", Type: crdt.TypeGCounter, Op: crdt.Op{Kind: crdt.OpIncr, By: 1}}, &common.CRDTReply{}); err == nil {
+		t.Errorf("Expected an update of the wrong type to fail")
+	}
+}
+
+func TestReplicationQueue_MergesQueuedCRDTStates(t *testing.T) {
+	replica := deadAddr(t)
+	m := newTestMaster([]string{replica}, 1)
+	m.replication.enqueue(replica, &common.PutArgs{Key: "x", Value: "1", Version: 1}) // Stuck in flight
+
+	for i, node := range []string{"a", "b"} {
+		c, _ := crdt.New(crdt.TypeGCounter)
+		c.Apply(crdt.Op{Kind: crdt.OpIncr, By: 2}, node, 0)
+		state, _ := crdt.Encode(c)
+		if err := m.replication.enqueue(replica, &common.PutArgs{Key: "c", Value: state, Version: int64(i + 2), CRDT: crdt.TypeGCounter}); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	q, _ := m.replication.queue(replica)
+	q.mu.Lock()
+	queued := *q.byKey["c"]
+	q.mu.Unlock()
+	if state, err := crdt.Decode(queued.CRDT, queued.Value); err != nil || state.Value() != uint64(4) {
+		t.Errorf("Expected the queued states merged into 4, got %q (%v)", queued.Value, err)
+	}
+}
+
+func TestPutCRDT_MovesOnOnlyIfTheUpdateNeverArrived(t *testing.T) {
+	live, w := startWorker(t)
+	down := deadAddr(t)
+	m := newTestMaster([]string{live, down}, 2)
+	m.mode = "async"
+	key := keyWithFirstReplica(t, m, down)
+
+	update := &common.CRDTArgs{Key: key, Type: crdt.TypeGCounter, Op: crdt.Op{Kind: crdt.OpIncr, By: 1}}
+	if err := m.CRDTUpdate(update, &common.CRDTReply{}); err != nil {
+		t.Fatalf("Expected the update applied at the next replica, got %v", err)
+	}
+	state, err := crdt.Decode(crdt.TypeGCounter, stored(t, w, key).Value)
+	if err != nil || state.Value() != uint64(1) {
+		t.Errorf("Expected the counter at 1, got %v (%v)", state, err)
+	}
+
+	// A replica that takes the update but never answers may have applied it.
+	hung := hungAddr(t)
+	live, w = startWorker(t)
+	m = newTestMaster([]string{live, hung}, 2)
+	m.mode = "async"
+	m.timeouts.Put = 100 * time.Millisecond
+	key = keyWithFirstReplica(t, m, hung)
+	update.Key = key
+	if err := m.CRDTUpdate(update, &common.CRDTReply{}); err == nil {
+		t.Errorf("Expected the update to fail rather than move on")
+	}
+	if stored(t, w, key).Found {
+		t.Errorf("Expected the update not sent to another replica")
+	}
+}
+
+// keyWithFirstReplica finds a key whose first replica is addr.
+func keyWithFirstReplica(t *testing.T, m *Master, addr string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprintf("k%d", i); m.getReplicas(key)[0] == addr {
+			return key
+		}
+	}
+	t.Fatalf("No key has %s as its first replica", addr)
+	return ""
+}
+
+// hungAddr returns a local address that accepts connections but never answers.
+func hungAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var held []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				for _, c := range held {
+					c.Close()
+				}
+				return
+			}
+			held = append(held, conn)
+		}
+	}()
+	return l.Addr().String()
+}
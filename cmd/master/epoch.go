@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Epoch fencing (see worker/epoch.go): every change to the ring or its
+// members bumps the cluster epoch, and every call to a worker carries it.
+// Workers refuse calls routed at an older epoch than they have seen. When a
+// worker refuses this master's epoch because a newer master has moved the
+// cluster on, this master is fenced: it refuses clients with a stale-routing
+// error until it is restarted, when it takes over at a newer epoch again.
+
+// epochState is the master's cluster epoch.
+type epochState struct {
+	mu      sync.Mutex
+	current uint64
+	fenced  uint64 // Newer epoch a worker reported; this master is stale (0 = not fenced)
+	reason  string // Why the epoch last changed
+	changed time.Time
+}
+
+func (e *epochState) get() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.current
+}
+
+// check returns a stale-routing error if this master has been fenced.
+func (e *epochState) check() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fenced > e.current {
+		return common.StaleRoutingError(e.current, e.fenced)
+	}
+	return nil
+}
+
+// bumpEpoch moves to a new epoch for a ring or membership change and tells
+// every worker, so masters still routing by the old ring are refused.
+func (m *Master) bumpEpoch(reason string) {
+	m.epoch.mu.Lock()
+	m.epoch.current++
+	epoch := m.epoch.current
+	m.epoch.reason, m.epoch.changed = reason, time.Now()
+	m.epoch.mu.Unlock()
+	log.Printf("[Epoch] Now %d (%s)", epoch, reason)
+	go m.broadcastEpoch()
+}
+
+// broadcastEpoch sends the current epoch to every worker. Workers that miss
+// it learn it from the next call they get.
+func (m *Master) broadcastEpoch() {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+	for _, w := range workers {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callWorker(ctx, w, "KV.Fence", &common.FenceArgs{Epoch: m.epoch.get()}, &common.FenceReply{})
+		cancel()
+		if err != nil && !common.IsStaleRouting(err) {
+			log.Printf("[Epoch] Could not fence %s: %v", w, err)
+		}
+	}
+}
+
+// takeOverEpoch starts this master one epoch past the newest any worker has
+// seen, which fences whichever master routed at that epoch. Its version clock
+// likewise starts past the newest version any worker stores, so a restarted
+// master (or one whose clock is behind) does not stamp writes the replicas
+// would refuse as stale.
+func (m *Master) takeOverEpoch() {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+	var newest uint64
+	for _, w := range workers {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if s.Epoch > newest {
+			newest = s.Epoch
+		}
+		m.clock.observe(s.MaxVersion)
+	}
+	m.epoch.mu.Lock()
+	if newest > m.epoch.current {
+		m.epoch.current = newest
+	}
+	m.epoch.mu.Unlock()
+	m.bumpEpoch("master started")
+}
+
+// stamp returns args carrying the current epoch. Args are copied, not
+// changed in place, since one args value is often sent to several workers at
+// once and may be resent after the epoch moves on.
+func (m *Master) stamp(args interface{}) interface{} {
+	epoch := m.epoch.get()
+	switch a := args.(type) {
+	case *common.PutArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.GetArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.DeleteArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.LeaseArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.StatsArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.PrepareArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.DecideArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.SpliceArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	case *common.CollectArgs:
+		c := *a
+		c.Epoch = epoch
+		return &c
+	}
+	return args
+}
+
+// staleRetry handles a worker refusing a call as stale. If the worker is at
+// an epoch this master moved to after stamping the call, it should simply be
+// sent again. If the worker is past this master's epoch, another master has
+// taken over: this one is fenced and the call fails.
+func (m *Master) staleRetry(addr string, err error) bool {
+	seen, ok := common.StaleEpoch(err)
+	if !ok {
+		return false
+	}
+	m.epoch.mu.Lock()
+	defer m.epoch.mu.Unlock()
+	if seen <= m.epoch.current {
+		return true
+	}
+	if seen > m.epoch.fenced {
+		log.Printf("[Epoch] Fenced: %s is at epoch %d, this master at %d; restart it to take over", addr, seen, m.epoch.current)
+		m.epoch.fenced = seen
+	}
+	return false
+}
+
+// EpochStat is the JSON view of the cluster epoch.
+type EpochStat struct {
+	Epoch   uint64    `json:"epoch"`
+	Fenced  uint64    `json:"fenced,omitempty"` // Newer epoch seen on a worker: this master is stale
+	Reason  string    `json:"reason"`
+	Changed time.Time `json:"changed"`
+}
+
+func (e *epochState) stat() EpochStat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EpochStat{Epoch: e.current, Fenced: e.fenced, Reason: e.reason, Changed: e.changed}
+}
+
+// errorStatus is the HTTP status for a failed read or write: 409 if the
+// routing was stale, so the caller knows to refresh rather than retry as is,
+// or if a transaction lost a conflict and may be retried from the start.
+func errorStatus(err error) int {
+	if common.IsStaleRouting(err) || common.IsTxnConflict(err) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestEpoch_StampedAndBumped(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	m := newTestMaster([]string{addr}, 1)
+	m.bumpEpoch("test")
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	f.mu.Lock()
+	epoch := f.epoch
+	f.mu.Unlock()
+	if epoch != 1 {
+		t.Errorf("Expected the worker to have seen epoch 1, got %d", epoch)
+	}
+}
+
+func TestEpoch_StaleMasterIsFenced(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	m := newTestMaster([]string{addr}, 1)
+	// Another master has taken over at epoch 5.
+	f.fence(5)
+
+	err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{})
+	if !common.IsStaleRouting(err) {
+		t.Fatalf("Expected a stale-routing error, got %v", err)
+	}
+	if f.has("k") {
+		t.Errorf("Expected the stale write not to be applied")
+	}
+	if st := m.epoch.stat(); st.Fenced != 5 {
+		t.Errorf("Expected the master to know it is fenced by epoch 5, got %+v", st)
+	}
+	// Fenced masters refuse clients outright.
+	f.mu.Lock()
+	gets := f.gets
+	f.mu.Unlock()
+	if err := m.Get(&common.GetArgs{Key: "k"}, &common.GetReply{}); !common.IsStaleRouting(err) {
+		t.Errorf("Expected a fenced master to refuse reads, got %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.gets != gets {
+		t.Errorf("Expected a fenced master not to ask the worker")
+	}
+}
+
+func TestEpoch_TakeOver(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	f.fence(7)
+	m := newTestMaster([]string{addr}, 1)
+	m.takeOverEpoch()
+	if got := m.epoch.get(); got != 8 {
+		t.Fatalf("Expected the new master to start at epoch 8, got %d", got)
+	}
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Errorf("Expected the new master's writes to succeed, got %v", err)
+	}
+}
+
+func TestEpoch_TakeOverSeedsTheVersionClock(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	ahead := time.Now().Add(time.Hour).UnixNano() // Written by a master whose clock ran fast
+	f.mu.Lock()
+	f.storeLocked("k", "v", ahead)
+	f.mu.Unlock()
+
+	m := newTestMaster([]string{addr}, 1)
+	m.takeOverEpoch()
+	if v := m.clock.next(); v <= ahead {
+		t.Fatalf("Expected the clock to start past v%d, got v%d", ahead, v)
+	}
+}
+
+func TestPut_RestampsAStaleWrite(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	ahead := time.Now().Add(time.Hour).UnixNano()
+	f.mu.Lock()
+	f.storeLocked("k", "old", ahead)
+	f.mu.Unlock()
+
+	// This master never saw the newer version, so it first stamps the write below it.
+	m := newTestMaster([]string{addr}, 1)
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "new"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.data["k"] != "new" || f.versions["k"] <= ahead {
+		t.Errorf("Expected the write to be restamped past v%d and applied, got %q v%d", ahead, f.data["k"], f.versions["k"])
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"customise-db/worker"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWorker is a minimal in-memory KV service for exercising master logic.
+// Chains, transactions and snapshot reads depend on what a worker does with
+// a write, so their tests run against real workers (see startWorker).
+type fakeWorker struct {
+	mu          sync.Mutex
+	data        map[string]string
+	versions    map[string]int64
+	primaryPuts int           // Writes received as the key's primary
+	getDelay    time.Duration // How long Get takes to answer
+	gets        int
+	epoch       uint64 // Highest cluster epoch seen
+}
+
+// storeLocked applies a versioned write.
+func (f *fakeWorker) storeLocked(key, value string, version int64) {
+	if version < f.versions[key] {
+		return
+	}
+	f.data[key] = value
+	f.versions[key] = version
+}
+
+func (f *fakeWorker) Collect(args *common.CollectArgs, reply *common.CollectReply) error {
+	return nil
+}
+
+// fence refuses calls routed at an older epoch, like a real worker.
+func (f *fakeWorker) fence(epoch uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if epoch < f.epoch {
+		return common.StaleRoutingError(epoch, f.epoch)
+	}
+	f.epoch = epoch
+	return nil
+}
+
+func (f *fakeWorker) Fence(args *common.FenceArgs, reply *common.FenceReply) error {
+	return f.fence(args.Epoch)
+}
+
+func (f *fakeWorker) Put(args *common.PutArgs, reply *common.PutReply) error {
+	if err := f.fence(args.Epoch); err != nil {
+		return err
+	}
+	if args.ForwardTo != "" || args.ChainID != "" {
+		return fmt.Errorf("put %s: the fake worker does not do chain writes", args.Key)
+	}
+	if args.Primary {
+		f.mu.Lock()
+		f.primaryPuts++
+		f.mu.Unlock()
+		backup := *args
+		backup.Primary, backup.Backups = false, nil
+		for _, addr := range args.Backups {
+			err := common.Call(context.Background(), addr, "KV.Put", &backup, &common.PutReply{})
+			if _, remote := err.(rpc.ServerError); err != nil && !remote {
+				return common.ChainLinkError(addr, err)
+			}
+		}
+	}
+
+	f.mu.Lock()
+	if stored := f.versions[args.Key]; args.Version != 0 && args.Version < stored {
+		f.mu.Unlock()
+		return common.StaleVersionError(args.Key, args.Version, stored)
+	}
+	f.storeLocked(args.Key, args.Value, args.Version)
+	f.mu.Unlock()
+	reply.Committed = true
+	return nil
+}
+
+func (f *fakeWorker) GrantLease(args *common.LeaseArgs, reply *common.LeaseReply) error {
+	return nil
+}
+
+func (f *fakeWorker) SuccessorFailed(args *common.SpliceArgs, reply *common.SpliceReply) error {
+	return nil
+}
+
+func (f *fakeWorker) Get(args *common.GetArgs, reply *common.GetReply) error {
+	if err := f.fence(args.Epoch); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	delay := f.getDelay
+	f.gets++
+	f.mu.Unlock()
+	time.Sleep(delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply.Value, reply.Found = f.data[args.Key]
+	reply.Version = f.versions[args.Key]
+	return nil
+}
+
+func (f *fakeWorker) Delete(args *common.DeleteArgs, reply *common.DeleteReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if stored := f.versions[args.Key]; args.Version != 0 && stored > args.Version {
+		return fmt.Errorf("delete %s: %w: v%d is older than the stored v%d", args.Key, common.ErrStaleVersion, args.Version, stored)
+	}
+	_, reply.Found = f.data[args.Key]
+	delete(f.data, args.Key)
+	return nil
+}
+
+func (f *fakeWorker) GetStats(args *common.StatsArgs, reply *common.StatsReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply.KeyCount = len(f.data)
+	reply.Epoch = f.epoch
+	for k := range f.data {
+		reply.Keys = append(reply.Keys, k)
+		reply.MaxVersion = max(reply.MaxVersion, f.versions[k])
+	}
+	return nil
+}
+
+func (f *fakeWorker) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.data[key]
+	return ok
+}
+
+// startFakeWorker serves a fakeWorker on a random local port until the test ends.
+func startFakeWorker(t *testing.T) (string, *fakeWorker) {
+	t.Helper()
+	return startFakeWorkerAt(t, "127.0.0.1:0")
+}
+
+// startFakeWorkerAt serves a fakeWorker on addr until the test ends.
+func startFakeWorkerAt(t *testing.T, addr string) (string, *fakeWorker) {
+	t.Helper()
+	f := &fakeWorker{data: make(map[string]string), versions: make(map[string]int64)}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("KV", f); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeConn(conn)
+		}
+	}()
+	return l.Addr().String(), f
+}
+
+// startWorker runs a real worker in-process, for tests that depend on what
+// the worker does with a write rather than on storing it as sent: increments
+// and CRDT operations, chains, transactions and snapshot reads.
+func startWorker(t *testing.T) (string, *worker.KVWorker) {
+	t.Helper()
+	return startWorkerAt(t, "127.0.0.1:0")
+}
+
+// startWorkerAt runs a real worker on addr until the test ends.
+func startWorkerAt(t *testing.T, addr string) (string, *worker.KVWorker) {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	w := worker.NewKVWorker(port, 0, 0, "")
+	go w.Serve(l)
+	return l.Addr().String(), w
+}
+
+// stored reads key straight from a worker started by startWorker.
+func stored(t *testing.T, w *worker.KVWorker, key string) *common.GetReply {
+	t.Helper()
+	reply := &common.GetReply{}
+	if err := w.Get(&common.GetArgs{Key: key}, reply); err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	return reply
+}
+
+// newTestMaster builds a master over the given workers with test-friendly timeouts.
+func newTestMaster(workers []string, rf int) *Master {
+	ring := NewConsistentHash(20)
+	ring.Add(workers...)
+	m := &Master{
+		workers:    workers,
+		ring:       ring,
+		mode:       "sync",
+		timeouts:   Timeouts{Put: time.Second, Get: time.Second, Stats: time.Second},
+		retry:      RetryPolicy{Attempts: 1},
+		breakers:   newBreakerSet(5, time.Second),
+		rf:         rf,
+		namespaces: newNamespaceRegistry(),
+		chains:     newChainManager(),
+		leases:     newLeaseManager(time.Second),
+		hedge:      &hedger{spec: "off"},
+		selector:   newReplicaSelector(nil),
+		weights:    newNodeWeights(false),
+		zones:      newWorkerZones(),
+	}
+	m.replication = newReplicationQueues(m, "", 0)
+	coordinatorLog, _ := openTxnLog("")
+	m.txns = newTxnManager(coordinatorLog, time.Minute, &m.clock)
+	return m
+}
+
+// deadAddr returns a local address that refuses connections.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hedgeSamples is how many recent read latencies a percentile hedge delay is
+// computed from; hedgeWarmup is how many it needs before it hedges at all.
+const (
+	hedgeSamples = 512
+	hedgeWarmup  = 20
+)
+
+// hedger decides when a read that has not been answered yet is also sent to
+// the next replica, and counts how often that happened and paid off.
+type hedger struct {
+	mu         sync.Mutex
+	spec       string        // As configured: "off", "p95", "20ms", ...
+	fixed      time.Duration // Fixed hedge delay, if the spec is a duration
+	percentile float64       // Hedge at this latency percentile, if the spec is "pNN"
+
+	latencies [hedgeSamples]time.Duration // Ring of recent successful read latencies
+	samples   int
+	next      int
+	cached    time.Duration // Percentile of latencies, recomputed every so often
+	stale     int           // Samples since cached was computed
+
+	reads  int
+	hedged int // Reads that sent at least one hedge
+	hedges int // Hedge requests sent
+	wins   int // Reads answered first by a hedge
+}
+
+// parseHedge reads a hedge spec: "off", a percentile such as "p95", or a
+// fixed delay such as "20ms".
+func parseHedge(spec string) (fixed time.Duration, percentile float64, err error) {
+	switch {
+	case spec == "" || spec == "off":
+		return 0, 0, nil
+	case strings.HasPrefix(spec, "p"):
+		p, err := strconv.ParseFloat(spec[1:], 64)
+		if err != nil || p <= 0 || p >= 100 {
+			return 0, 0, fmt.Errorf("invalid hedge percentile %q: want p1..p99", spec)
+		}
+		return 0, p, nil
+	default:
+		d, err := time.ParseDuration(spec)
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid hedge delay %q: want off, pNN or a duration", spec)
+		}
+		return d, 0, nil
+	}
+}
+
+func newHedger(spec string) (*hedger, error) {
+	h := &hedger{}
+	return h, h.configure(spec)
+}
+
+// configure switches to a new hedge spec, keeping the latency history and counts.
+func (h *hedger) configure(spec string) error {
+	fixed, percentile, err := parseHedge(spec)
+	if err != nil {
+		return err
+	}
+	if spec == "" {
+		spec = "off"
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.spec, h.fixed, h.percentile = spec, fixed, percentile
+	h.stale = hedgeSamples // Recompute the percentile on next use
+	return nil
+}
+
+// delay returns how long to wait for a replica before hedging, and false if
+// reads are not hedged (hedging is off or too few latencies are known yet).
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delayLocked()
+}
+
+func (h *hedger) delayLocked() (time.Duration, bool) {
+	if h.fixed > 0 {
+		return h.fixed, true
+	}
+	if h.percentile == 0 || h.samples < hedgeWarmup {
+		return 0, false
+	}
+	if h.stale >= hedgeSamples/8 {
+		sorted := make([]time.Duration, h.samples)
+		copy(sorted, h.latencies[:h.samples])
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		h.cached = sorted[int(float64(len(sorted)-1)*h.percentile/100)]
+		h.stale = 0
+	}
+	return h.cached, true
+}
+
+// observe records the latency of a replica's successful answer.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+	if h.samples < hedgeSamples {
+		h.samples++
+	}
+	h.stale++
+}
+
+// record counts one finished read.
+func (h *hedger) record(hedges int, won bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reads++
+	h.hedges += hedges
+	if hedges > 0 {
+		h.hedged++
+	}
+	if won {
+		h.wins++
+	}
+}
+
+// HedgeStat is the JSON view of read hedging.
+type HedgeStat struct {
+	Policy string `json:"policy"`
+	Delay  string `json:"delay,omitempty"` // Current hedge delay, if hedging
+	Reads  int    `json:"reads"`
+	Hedged int    `json:"hedged"` // Reads that sent a hedge
+	Hedges int    `json:"hedges"` // Hedge requests sent
+	Wins   int    `json:"wins"`   // Reads a hedge answered first
+}
+
+func (h *hedger) stat() HedgeStat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HedgeStat{Policy: h.spec, Reads: h.reads, Hedged: h.hedged, Hedges: h.hedges, Wins: h.wins}
+	if d, ok := h.delayLocked(); ok {
+		s.Delay = d.String()
+	}
+	return s
+}
+
+// getHedged: Like getFailover, but a replica that has not answered within the
+// hedge delay does not hold the read up: the next replica is asked as well,
+// and whichever answers first wins. A replica that fails is failed over at
+// once. The losers are cancelled.
+func (m *Master) getHedged(ctx context.Context, args *common.GetArgs, reply *common.GetReply, replicas []string, delay time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply *common.GetReply
+		err   error
+		hedge bool // Sent because the replicas before it were slow, not failed
+	}
+	resChan := make(chan result, len(replicas))
+	next, inFlight, hedges := 0, 0, 0
+	send := func(hedge bool) {
+		addr := replicas[next]
+		next++
+		inFlight++
+		go func() {
+			start := time.Now()
+			r := &common.GetReply{}
+			err := m.callWorker(ctx, addr, "KV.Get", args, r)
+			if err == nil {
+				m.hedge.observe(time.Since(start))
+			}
+			resChan <- result{reply: r, err: err, hedge: hedge}
+		}()
+	}
+
+	send(false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var lastErr error
+	for inFlight > 0 {
+		select {
+		case res := <-resChan:
+			inFlight--
+			if res.err == nil {
+				m.hedge.record(hedges, res.hedge)
+				*reply = *res.reply
+				return nil
+			}
+			lastErr = res.err
+			if next < len(replicas) && ctx.Err() == nil {
+				send(false)
+			}
+		case <-timer.C:
+			if next < len(replicas) {
+				send(true)
+				hedges++
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			m.hedge.record(hedges, false)
+			return fmt.Errorf("all replicas failed: %v", ctx.Err())
+		}
+	}
+	m.hedge.record(hedges, false)
+	return fmt.Errorf("all replicas failed: %v", lastErr)
+}
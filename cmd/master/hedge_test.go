@@ -0,0 +1,80 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestParseHedge(t *testing.T) {
+	tests := []struct {
+		spec       string
+		fixed      time.Duration
+		percentile float64
+		wantErr    bool
+	}{
+		{"off", 0, 0, false},
+		{"", 0, 0, false},
+		{"p95", 0, 95, false},
+		{"p99.9", 0, 99.9, false},
+		{"20ms", 20 * time.Millisecond, 0, false},
+		{"p100", 0, 0, true},
+		{"0s", 0, 0, true},
+		{"soon", 0, 0, true},
+	}
+	for _, tt := range tests {
+		fixed, percentile, err := parseHedge(tt.spec)
+		if (err != nil) != tt.wantErr || fixed != tt.fixed || percentile != tt.percentile {
+			t.Errorf("parseHedge(%q) = %v, %v, %v", tt.spec, fixed, percentile, err)
+		}
+	}
+}
+
+func TestHedger_PercentileDelay(t *testing.T) {
+	h, _ := newHedger("p95")
+	for i := 1; i < hedgeWarmup; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := h.delay(); ok {
+		t.Errorf("Expected no hedging before %d latencies are known", hedgeWarmup)
+	}
+	for i := hedgeWarmup; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := h.delay(); !ok || d != 95*time.Millisecond {
+		t.Errorf("Expected a p95 delay of 95ms, got %v (hedging: %v)", d, ok)
+	}
+}
+
+func TestGetFailover_HedgesSlowReplica(t *testing.T) {
+	fakes := make(map[string]*fakeWorker)
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 2)
+	m.mode = "async"
+	m.hedge, _ = newHedger("20ms")
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) // Let the background copy land
+	slow := m.getReplicas("k")[0]
+	fakes[slow].mu.Lock()
+	fakes[slow].getDelay = 500 * time.Millisecond
+	fakes[slow].mu.Unlock()
+
+	start := time.Now()
+	reply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k"}, reply); err != nil || reply.Value != "v" {
+		t.Fatalf("Expected v, got %q (%v)", reply.Value, err)
+	}
+	if took := time.Since(start); took > 300*time.Millisecond {
+		t.Errorf("Expected the hedge to answer before the slow replica, took %v", took)
+	}
+	if s := m.hedge.stat(); s.Reads != 1 || s.Hedges != 1 || s.Wins != 1 {
+		t.Errorf("Expected 1 read won by 1 hedge, got %+v", s)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"customise-db/common"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Idempotent writes (see worker/dedup.go): every write reaches the
+// workers with a client ID and request ID, so a worker applies it at most
+// once however often it is sent. Clients may choose their own IDs and reuse
+// them when they retry; writes without one get an ID from the master. Either
+// way the master can then retry a write whose reply was lost.
+
+// requestIDs hands out request IDs under a client ID unique to this master.
+type requestIDs struct {
+	once   sync.Once
+	client string
+	next   atomic.Uint64
+}
+
+// checkRequestID rejects a request ID given without the client ID that
+// scopes it: workers deduplicate by the pair, so two clients' bare request
+// IDs would collide.
+func checkRequestID(clientID, requestID string) error {
+	if requestID != "" && clientID == "" {
+		return fmt.Errorf("request ID %q needs a client ID", requestID)
+	}
+	return nil
+}
+
+// assign gives args a request ID if the client did not.
+func (r *requestIDs) assign(args *common.PutArgs) error {
+	if args.RequestID != "" {
+		return checkRequestID(args.ClientID, args.RequestID)
+	}
+	r.once.Do(func() {
+		b := make([]byte, 8)
+		rand.Read(b)
+		r.client = "master-" + hex.EncodeToString(b)
+	})
+	args.ClientID = r.client
+	args.RequestID = strconv.FormatUint(r.next.Add(1), 10)
+	return nil
+}
+
+// callPut sends a write to a worker. A write with a request ID is retried
+// after transport failures like a read, since the worker will not apply it
+// twice.
+func (m *Master) callPut(ctx context.Context, addr string, args *common.PutArgs, reply *common.PutReply) error {
+	if args.RequestID == "" {
+		return m.callWorker(ctx, addr, "KV.Put", args, reply)
+	}
+	return m.callIdempotent(ctx, addr, "KV.Put", args, reply)
+}
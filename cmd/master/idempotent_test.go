@@ -0,0 +1,72 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestRequestIDs_Assign(t *testing.T) {
+	var r requestIDs
+	a, b := &common.PutArgs{}, &common.PutArgs{}
+	r.assign(a)
+	r.assign(b)
+	if a.ClientID == "" || a.ClientID != b.ClientID || a.RequestID == b.RequestID {
+		t.Errorf("Expected distinct request IDs under one client ID, got %+v and %+v", a, b)
+	}
+	mine := &common.PutArgs{ClientID: "app", RequestID: "42"}
+	r.assign(mine)
+	if mine.ClientID != "app" || mine.RequestID != "42" {
+		t.Errorf("Expected the client's own IDs kept, got %s/%s", mine.ClientID, mine.RequestID)
+	}
+}
+
+func TestPut_RejectsRequestIDWithoutClient(t *testing.T) {
+	addr, f := startFakeWorker(t)
+	m := newTestMaster([]string{addr}, 1)
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v", RequestID: "42"}, &common.PutReply{}); err == nil {
+		t.Fatalf("Expected a request ID without a client ID to be refused")
+	}
+	if f.has("k") {
+		t.Errorf("Expected the refused write not to reach the worker")
+	}
+	if err := m.Incr(&common.IncrArgs{Key: "n", By: 1, RequestID: "42"}, &common.IncrReply{}); err == nil {
+		t.Errorf("Expected an increment with a bare request ID to be refused")
+	}
+}
+
+func TestCallPut_RetriesOnlyWithRequestID(t *testing.T) {
+	addr := deadAddr(t)
+	m := newTestMaster([]string{addr}, 1)
+	m.retry = RetryPolicy{Attempts: 10, Base: 50 * time.Millisecond, Max: 200 * time.Millisecond}
+
+	if err := m.callPut(t.Context(), addr, &common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err == nil {
+		t.Fatalf("Expected a write without a request ID to fail at once")
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.callPut(t.Context(), addr, &common.PutArgs{Key: "k", Value: "v", ClientID: "c", RequestID: "1"}, &common.PutReply{})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	startFakeWorkerAt(t, addr)
+	if err := <-errc; err != nil {
+		t.Errorf("Expected a write with a request ID to be retried until the worker is up, got %v", err)
+	}
+}
+
+func TestPut_RestampReachesReplicasThatAppliedTheFirstAttempt(t *testing.T) {
+	a, wa := startWorker(t)
+	b, wb := startWorker(t)
+	m := newTestMaster([]string{a, b}, 2)
+	// a already holds a newer version than the master's clock will give.
+	ahead := time.Now().Add(time.Hour).UnixNano()
+	wa.Put(&common.PutArgs{Key: "k", Value: "other", Version: ahead}, &common.PutReply{})
+
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v", ClientID: "app", RequestID: "1"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	ga, gb := stored(t, wa, "k"), stored(t, wb, "k")
+	if ga.Value != "v" || gb.Value != "v" || ga.Version != gb.Version || ga.Version <= ahead {
+		t.Errorf("Expected both replicas at the restamped version above v%d, got %+v and %+v", ahead, ga, gb)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Incr is the RPC entry point for adding to a counter.
+func (m *Master) Incr(args *common.IncrArgs, reply *common.IncrReply) error {
+	return m.incr(context.Background(), args, reply)
+}
+
+// Decr is the RPC entry point for subtracting from a counter.
+func (m *Master) Decr(args *common.IncrArgs, reply *common.IncrReply) error {
+	if args.By == math.MinInt64 {
+		return fmt.Errorf("decr %s: %d cannot be negated", args.Key, args.By)
+	}
+	neg := *args
+	neg.By = -args.By
+	return m.incr(context.Background(), &neg, reply)
+}
+
+// incr sends an increment down the write path (see common/counter.go). Each
+// strategy leaves args holding the result it stored.
+func (m *Master) incr(ctx context.Context, args *common.IncrArgs, reply *common.IncrReply) error {
+	put := &common.PutArgs{
+		Key: args.Key, Namespace: args.Namespace, Consistency: args.Consistency, TTL: args.TTL,
+		ClientID: args.ClientID, RequestID: args.RequestID,
+		Incr: true, By: args.By,
+	}
+	putReply := &common.PutReply{}
+	if err := m.put(ctx, put, putReply); err != nil {
+		return err
+	}
+	value, err := strconv.ParseInt(put.Value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("incr %s: worker returned %q", args.Key, put.Value)
+	}
+	reply.Value, reply.Version, reply.Consistency = value, put.Version, putReply.Consistency
+	return nil
+}
+
+// settleIncr records in args the write an increment was resolved into, so
+// that copies made after it carry the result rather than adding again.
+func settleIncr(args *common.PutArgs, reply *common.PutReply) {
+	args.Incr = false
+	args.Value, args.Version = reply.Value, reply.Version
+}
+
+// putIncr applies an increment in the modes without a replica that orders
+// writes: the key's first replica resolves it, and the result is then copied
+// to the others as level requires. Quorum mode first brings that replica up
+// to the newest value a read quorum holds, which it may have missed.
+func (m *Master) putIncr(ctx context.Context, level string, args *common.PutArgs) error {
+	replicas := m.getReplicas(args.Key)
+	origin, others := replicas[0], replicas[1:]
+	if level == common.ConsistencyOne || level == common.ConsistencyAny {
+		if err := m.replication.full(others); err != nil {
+			return err
+		}
+	}
+	if level == common.ConsistencyQuorum {
+		if err := m.repairOrigin(ctx, origin, args); err != nil {
+			return err
+		}
+	}
+
+	reply := &common.PutReply{}
+	if err := m.callPut(ctx, origin, args, reply); err != nil {
+		return fmt.Errorf("increment at %s failed: %v", origin, err)
+	}
+	settleIncr(args, reply)
+	return m.copyResolved(ctx, level, args, replicas, others)
+}
+
+// copyResolved copies a write one of replicas has resolved to the others: in
+// the background at levels one and any, otherwise waiting until as many
+// replicas as level requires hold it.
+func (m *Master) copyResolved(ctx context.Context, level string, args *common.PutArgs, replicas, others []string) error {
+	if level == common.ConsistencyOne || level == common.ConsistencyAny {
+		return m.replicateInBackground(args, others)
+	}
+	required := len(replicas)
+	if level == common.ConsistencyQuorum {
+		n, err := m.quorum.size(args.W, m.quorum.W, len(replicas))
+		if err != nil {
+			return err
+		}
+		required = n
+	}
+	errs := make(chan error, len(others))
+	for _, addr := range others {
+		go func(workerAddr string) {
+			errs <- m.callPut(ctx, workerAddr, args, &common.PutReply{})
+		}(addr)
+	}
+	acks := len(replicas) - len(others) // The replica that resolved it
+	var firstErr error
+	for range others {
+		// A stale version means the replica already resolved a newer write.
+		if err := <-errs; err != nil && !common.IsStaleVersion(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		acks++
+	}
+	if acks < required {
+		return fmt.Errorf("write of %s reached %d/%d replicas: %v", args.Key, acks, required, firstErr)
+	}
+	return nil
+}
+
+// repairOrigin writes the newest value a read quorum holds for args.Key to
+// origin. An older copy than origin's own is refused there like any stale
+// write, which leaves origin as new as the quorum already.
+func (m *Master) repairOrigin(ctx context.Context, origin string, args *common.PutArgs) error {
+	base := &common.GetReply{}
+	if err := m.getQuorum(ctx, &common.GetArgs{Key: args.Key, Deadline: args.Deadline}, base, readRing); err != nil {
+		return fmt.Errorf("incr %s: %v", args.Key, err)
+	}
+	if !base.Found {
+		return nil
+	}
+	repair := &common.PutArgs{Key: args.Key, Value: base.Value, Version: base.Version, TTL: base.TTL, Deadline: args.Deadline}
+	if err := m.callWorker(ctx, origin, "KV.Put", repair, &common.PutReply{}); err != nil && !common.IsStaleVersion(err) {
+		return err
+	}
+	return nil
+}
+
+// handleIncr serves /incr?key=...&by=...: it adds by (default 1, negative to
+// decrement) to the counter at key and prints the new value.
+func (m *Master) handleIncr(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	q := r.URL.Query()
+	args := &common.IncrArgs{Key: q.Get("key"), By: 1, Namespace: q.Get("ns"), Consistency: q.Get("consistency"),
+		ClientID: q.Get("client"), RequestID: q.Get("request_id")}
+	if args.Key == "" {
+		http.Error(w, "missing params", 400)
+		return
+	}
+	if err := checkRequestID(args.ClientID, args.RequestID); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if by := q.Get("by"); by != "" {
+		n, err := strconv.ParseInt(by, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid by: %q", by), 400)
+			return
+		}
+		args.By = n
+	}
+	if !common.ValidConsistency(args.Consistency) {
+		http.Error(w, fmt.Sprintf("unknown consistency level %q", args.Consistency), 400)
+		return
+	}
+	var err error
+	if args.TTL, err = durationParam(r, "ttl"); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	reply := &common.IncrReply{}
+	if err := m.incr(r.Context(), args, reply); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("X-Consistency-Level", reply.Consistency)
+	w.Header().Set("X-Version", strconv.FormatInt(reply.Version, 10))
+	fmt.Fprintf(w, "%d\n", reply.Value)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"customise-db/common"
+	"customise-db/worker"
+	"testing"
+)
+
+func TestIncr_EveryReplicaStoresTheResultInEveryMode(t *testing.T) {
+	for _, mode := range []string{"sync", "quorum", "chain", "primary"} {
+		t.Run(mode, func(t *testing.T) {
+			var addrs []string
+			workers := make(map[string]*worker.KVWorker)
+			for i := 0; i < 3; i++ {
+				addr, w := startWorker(t)
+				addrs = append(addrs, addr)
+				workers[addr] = w
+			}
+			m := newTestMaster(addrs, 3)
+			m.mode = mode
+
+			for i := 0; i < 3; i++ {
+				if err := m.Incr(&common.IncrArgs{Key: "hits", By: 5}, &common.IncrReply{}); err != nil {
+					t.Fatalf("Incr failed: %v", err)
+				}
+			}
+			reply := &common.IncrReply{}
+			if err := m.Decr(&common.IncrArgs{Key: "hits", By: 2}, reply); err != nil || reply.Value != 13 {
+				t.Fatalf("Expected Decr to return 13, got %+v (%v)", reply, err)
+			}
+			for addr, w := range workers {
+				if got := stored(t, w, "hits"); got.Value != "13" || got.Version != reply.Version {
+					t.Errorf("Expected %s to hold 13 at v%d, got %q at v%d", addr, reply.Version, got.Value, got.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestIncr_QuorumBuildsOnTheNewestValue(t *testing.T) {
+	var addrs []string
+	workers := make(map[string]*worker.KVWorker)
+	for i := 0; i < 3; i++ {
+		addr, w := startWorker(t)
+		addrs = append(addrs, addr)
+		workers[addr] = w
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "quorum"
+	m.Put(&common.PutArgs{Key: "n", Value: "10"}, &common.PutReply{})
+	// The replica that resolves increments missed the write.
+	workers[m.getReplicas("n")[0]].Delete(&common.DeleteArgs{Key: "n"}, &common.DeleteReply{})
+
+	reply := &common.IncrReply{}
+	if err := m.Incr(&common.IncrArgs{Key: "n", By: 1}, reply); err != nil || reply.Value != 11 {
+		t.Errorf("Expected the increment applied to 10, got %+v (%v)", reply, err)
+	}
+}
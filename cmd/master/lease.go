@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lease states of a worker in primary mode.
+const (
+	leaseActive     = "active"     // Holds (or is due) a lease; may be a primary
+	leaseDeposed    = "deposed"    // Failed; its lease is left to run out
+	leaseRecovering = "recovering" // Back, and a backup again, but re-syncing before it may lead
+)
+
+// workerLease is the master's view of one worker's primary lease.
+type workerLease struct {
+	state   string
+	expires time.Time // When the worker's lease ends at the latest
+}
+
+// leaseManager grants the primary leases of primary mode. A key's primary is
+// the first of its replicas (in ConsistentHash.GetN order) holding a valid
+// lease. When a primary fails it is deposed and the next replica takes over,
+// but only once the deposed worker's lease has run out, so two workers never
+// act as primary for the same key at once.
+type leaseManager struct {
+	mu       sync.Mutex
+	duration time.Duration
+	workers  map[string]*workerLease
+	deposals int
+}
+
+func newLeaseManager(duration time.Duration) *leaseManager {
+	return &leaseManager{duration: duration, workers: make(map[string]*workerLease)}
+}
+
+// lease returns addr's lease record. Caller holds l.mu.
+func (l *leaseManager) lease(addr string) *workerLease {
+	wl, ok := l.workers[addr]
+	if !ok {
+		wl = &workerLease{state: leaseActive}
+		l.workers[addr] = wl
+	}
+	return wl
+}
+
+// granted records a lease addr accepted before acked: the worker timed it
+// from when the grant arrived, which was no later than that.
+func (l *leaseManager) granted(addr string, acked time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wl := l.lease(addr)
+	if wl.state == leaseDeposed {
+		return // Deposed while the grant was in flight; let it run out
+	}
+	wl.expires = acked.Add(l.duration)
+}
+
+// depose takes addr out of the running for primary; it reports false if it
+// already was.
+func (l *leaseManager) depose(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wl := l.lease(addr)
+	if wl.state == leaseDeposed {
+		return false
+	}
+	wl.state = leaseDeposed
+	l.deposals++
+	return true
+}
+
+func (l *leaseManager) setState(addr, state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lease(addr).state = state
+}
+
+func (l *leaseManager) state(addr string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lease(addr).state
+}
+
+// route picks the primary and backups among a key's replicas. If the replica
+// in line to be primary was deposed but its lease may still be running, wait
+// says how long until it certainly is not. needsGrant names an active replica
+// in line that holds no lease yet.
+func (l *leaseManager) route(replicas []string) (primary string, backups []string, wait time.Duration, needsGrant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, r := range replicas {
+		wl := l.lease(r)
+		if primary == "" {
+			switch {
+			case wl.state == leaseActive && now.Before(wl.expires):
+				primary = r
+				continue
+			case wl.state == leaseActive:
+				return "", nil, 0, r
+			case wl.state == leaseDeposed && now.Before(wl.expires):
+				return "", nil, wl.expires.Sub(now), ""
+			}
+		}
+		// Recovering replicas passed over on the way to the primary are backups too.
+		if wl.state != leaseDeposed {
+			backups = append(backups, r)
+		}
+	}
+	if primary == "" {
+		return "", nil, 0, ""
+	}
+	return primary, backups, 0, ""
+}
+
+// LeaseStat is the JSON view of one worker's lease.
+type LeaseStat struct {
+	Address string    `json:"address"`
+	State   string    `json:"state"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseStatus summarises primary leases for /status.
+type LeaseStatus struct {
+	Duration string      `json:"duration"`
+	Deposals int         `json:"deposals"`
+	Workers  []LeaseStat `json:"workers"`
+}
+
+func (l *leaseManager) status() LeaseStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := LeaseStatus{Duration: l.duration.String(), Deposals: l.deposals, Workers: []LeaseStat{}}
+	for addr, wl := range l.workers {
+		s.Workers = append(s.Workers, LeaseStat{Address: addr, State: wl.state, Expires: wl.expires})
+	}
+	sort.Slice(s.Workers, func(i, j int) bool { return s.Workers[i].Address < s.Workers[j].Address })
+	return s
+}
+
+// grantLease gives addr a fresh lease; a worker that cannot take one is deposed.
+func (m *Master) grantLease(ctx context.Context, addr string) error {
+	err := m.callWorker(ctx, addr, "KV.GrantLease", &common.LeaseArgs{Duration: m.leases.duration}, &common.LeaseReply{})
+	if err != nil {
+		if m.leases.depose(addr) {
+			log.Printf("[Lease] Deposed %s: %v", addr, err)
+		}
+		return err
+	}
+	m.leases.granted(addr, time.Now())
+	return nil
+}
+
+// primaryFor returns the primary and backups for key, waiting out the lease of
+// a deposed primary if it has to and granting a lease to one that lacks it.
+func (m *Master) primaryFor(ctx context.Context, key string) (string, []string, error) {
+	replicas := m.getReplicas(key)
+	for {
+		primary, backups, wait, needsGrant := m.leases.route(replicas)
+		switch {
+		case primary != "":
+			return primary, backups, nil
+		case needsGrant != "":
+			m.grantLease(ctx, needsGrant) // On failure it is deposed and passed over
+		case wait > 0:
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return "", nil, fmt.Errorf("primary for %s is changing hands: %v", key, ctx.Err())
+			}
+		default:
+			return "", nil, fmt.Errorf("no replica of %s can take a primary lease", key)
+		}
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+	}
+}
+
+// putPrimary: Send the write to the key's primary, which orders it and copies
+// it to the backups. A failed primary or backup is deposed and the write
+// retried; versions make a retry that lands twice harmless.
+func (m *Master) putPrimary(ctx context.Context, args *common.PutArgs) error {
+	for {
+		primary, backups, err := m.primaryFor(ctx, args.Key)
+		if err != nil {
+			return err
+		}
+		primaryArgs := *args
+		primaryArgs.Primary = true
+		primaryArgs.Backups = backups
+		reply := &common.PutReply{}
+		err = m.callPut(ctx, primary, &primaryArgs, reply)
+		if err == nil {
+			if args.Incr {
+				settleIncr(args, reply)
+			}
+			return nil
+		}
+		if !m.leaseFailover(ctx, primary, err) {
+			return err
+		}
+	}
+}
+
+// getPrimary: Read from the key's primary, which answers only under a valid lease.
+func (m *Master) getPrimary(ctx context.Context, args *common.GetArgs, reply *common.GetReply) error {
+	for {
+		primary, _, err := m.primaryFor(ctx, args.Key)
+		if err != nil {
+			return err
+		}
+		primaryArgs := *args
+		primaryArgs.Primary = true
+		r := &common.GetReply{}
+		err = m.callWorker(ctx, primary, "KV.Get", &primaryArgs, r)
+		if err == nil {
+			*reply = *r
+			return nil
+		}
+		if !m.leaseFailover(ctx, primary, err) {
+			return err
+		}
+	}
+}
+
+// leaseFailover reacts to a failed primary-mode call, reporting whether it is
+// worth retrying: a worker whose lease lapsed gets a fresh one, and a failed
+// primary or backup is deposed.
+func (m *Master) leaseFailover(ctx context.Context, primary string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if strings.Contains(err.Error(), common.ErrNoLease.Error()) {
+		// Our lease bookkeeping ran ahead of the worker's; renew and retry.
+		return m.grantLease(ctx, primary) == nil
+	}
+	failed, ok := failedLink(primary, err)
+	if !ok || !m.leases.depose(failed) {
+		return false
+	}
+	log.Printf("[Lease] Deposed %s: %v", failed, err)
+	return true
+}
+
+// superviseLeases renews every active worker's lease well before it runs out,
+// and brings deposed workers back once they answer again.
+func (m *Master) superviseLeases() {
+	ticker := time.NewTicker(m.leases.duration / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.RLock()
+		workers := make([]string, len(m.workers))
+		copy(workers, m.workers)
+		m.mu.RUnlock()
+
+		for _, addr := range workers {
+			switch m.leases.state(addr) {
+			case leaseActive:
+				ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+				m.grantLease(ctx, addr)
+				cancel()
+			case leaseDeposed:
+				ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+				err := common.Call(ctx, addr, "KV.GetStats", &common.StatsArgs{}, &common.StatsReply{})
+				cancel()
+				if err == nil {
+					m.restorePrimary(addr, workers)
+				}
+			}
+		}
+	}
+}
+
+// restorePrimary re-syncs a recovered worker from the current primaries and
+// makes it eligible for a lease again. While it re-syncs it is already a
+// backup, so it cannot miss a write.
+func (m *Master) restorePrimary(addr string, workers []string) {
+	m.leases.setState(addr, leaseRecovering)
+	log.Printf("[Lease] %s is back; re-syncing", addr)
+
+	var sources []string
+	for _, w := range workers {
+		if w != addr && m.leases.state(w) == leaseActive {
+			sources = append(sources, w)
+		}
+	}
+	read := func(ctx context.Context, key string) (*common.GetReply, error) {
+		value := &common.GetReply{}
+		return value, m.getPrimary(ctx, &common.GetArgs{Key: key, Deadline: common.DeadlineOf(ctx)}, value)
+	}
+	synced, err := m.resync(addr, sources, read)
+	if err != nil {
+		m.leases.depose(addr)
+		log.Printf("[Lease] Re-sync of %s failed, staying deposed: %v", addr, err)
+		return
+	}
+	m.leases.setState(addr, leaseActive)
+	log.Printf("[Lease] %s restored after re-syncing %d keys", addr, synced)
+}
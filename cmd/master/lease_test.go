@@ -0,0 +1,84 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestPutPrimary_PrimaryOrdersAndReplicates(t *testing.T) {
+	fakes := make(map[string]*fakeWorker)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "primary"
+
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	primary := m.getReplicas("k")[0]
+	for addr, f := range fakes {
+		if !f.has("k") {
+			t.Errorf("Expected %s to hold the write", addr)
+		}
+		if want := map[bool]int{true: 1, false: 0}[addr == primary]; f.primaryPuts != want {
+			t.Errorf("%s: expected %d writes as primary, got %d", addr, want, f.primaryPuts)
+		}
+	}
+
+	reply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k"}, reply); err != nil || reply.Value != "v" {
+		t.Errorf("Expected Get from the primary to return v, got %q (%v)", reply.Value, err)
+	}
+}
+
+func TestPutPrimary_WaitsOutDeposedLease(t *testing.T) {
+	fakes := make(map[string]*fakeWorker)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "primary"
+	m.leases = newLeaseManager(200 * time.Millisecond)
+
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v1"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	replicas := m.getReplicas("k")
+	m.leases.depose(replicas[0])
+
+	start := time.Now()
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v2"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put after deposing the primary failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the new primary to wait for the old lease to run out, took %v", elapsed)
+	}
+	if fakes[replicas[1]].primaryPuts != 1 {
+		t.Errorf("Expected the next replica to take over as primary")
+	}
+}
+
+func TestPutPrimary_DeposesDeadWorker(t *testing.T) {
+	a, _ := startFakeWorker(t)
+	b, _ := startFakeWorker(t)
+	dead := deadAddr(t)
+
+	m := newTestMaster([]string{a, b, dead}, 3)
+	m.mode = "primary"
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		if err := m.Put(&common.PutArgs{Key: key, Value: "v"}, &common.PutReply{}); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+	}
+	if state := m.leases.state(dead); state != leaseDeposed {
+		t.Errorf("Expected the dead worker to be deposed, got %q", state)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"customise-db/common"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"hash/crc32"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/rpc"
@@ -21,16 +23,32 @@ import (
 
 // ConsistentHash handles the ring logic.
 type ConsistentHash struct {
-	replicas int               // Virtual nodes per physical node
-	keys     []int             // Sorted hash ring
-	hashMap  map[int]string    // Map hash -> physical node
+	replicas int                // Virtual nodes per physical node
+	keys     []int              // Sorted hash ring
+	hashMap  map[int]string     // Map hash -> physical node
+	hash     HashFunc           // Places keys and virtual nodes on the ring
+	vnodes   map[string]int     // Physical node -> its virtual nodes on the ring
+	weights  map[string]float64 // Physical node -> weight (absent = 1); see weights.go
+	zones    map[string]string  // Physical node -> zone label (absent = none); see zones.go
 	mu       sync.RWMutex
+
+	// Bounded-load placement (see boundedload.go); off while balance is 0.
+	balance float64
+	placed  map[string][]string  // Key -> the nodes it was placed on
+	expires map[string]time.Time // Key -> when its TTL runs out, releasing its nodes
+	load    map[string]int       // Node -> key replicas placed on it
+	total   int
+	pruned  time.Time // Last sweep for expired placements
 }
 
 func NewConsistentHash(replicas int) *ConsistentHash {
 	return &ConsistentHash{
 		replicas: replicas,
 		hashMap:  make(map[int]string),
+		hash:     crc32.ChecksumIEEE,
+		vnodes:   make(map[string]int),
+		weights:  make(map[string]float64),
+		zones:    make(map[string]string),
 	}
 }
 
@@ -38,17 +56,22 @@ func (c *ConsistentHash) Add(nodes ...string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for _, node := range nodes {
-		for i := 0; i < c.replicas; i++ {
-			hash := int(crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node)))
+		count := c.vnodeCountLocked(node)
+		for i := 0; i < count; i++ {
+			hash := int(c.hash([]byte(strconv.Itoa(i) + node)))
 			c.keys = append(c.keys, hash)
 			c.hashMap[hash] = node
 		}
+		c.vnodes[node] = count
 	}
 	sort.Ints(c.keys)
 }
 
 // GetN returns the 'n' distinct physical nodes responsible for the key.
 func (c *ConsistentHash) GetN(key string, n int) []string {
+	if c.bounded() {
+		return c.getBounded(key, n, false)
+	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -56,8 +79,8 @@ func (c *ConsistentHash) GetN(key string, n int) []string {
 		return nil
 	}
 
-	hash := int(crc32.ChecksumIEEE([]byte(key)))
-	
+	hash := int(c.hash([]byte(key)))
+
 	// Binary search for appropriate replica
 	idx := sort.Search(len(c.keys), func(i int) bool {
 		return c.keys[i] >= hash
@@ -67,64 +90,216 @@ func (c *ConsistentHash) GetN(key string, n int) []string {
 		idx = 0
 	}
 
-	uniqueNodes := make(map[string]bool)
-	var nodes []string
+	// Walk the ring clockwise, spreading over zones
+	return c.walkLocked(idx, n)
+}
 
-	// Walk the ring clockwise
-	for len(nodes) < n && len(uniqueNodes) < len(c.hashMap)/c.replicas {
-		node := c.hashMap[c.keys[idx]]
-		if !uniqueNodes[node] {
-			uniqueNodes[node] = true
-			nodes = append(nodes, node)
-		}
-		idx = (idx + 1) % len(c.keys)
+type Master struct {
+	workers     []string    // Keep for reference
+	ring        Partitioner // Places keys on workers
+	partitioner string      // Name of the ring's algorithm and hash function, e.g. "maglev/xxhash"
+	weights     *nodeWeights
+	zones       *workerZones // Zone each worker reported
+	mode        string
+	mu          sync.RWMutex
+	lastScale   time.Time
+	timeouts    Timeouts
+	retry       RetryPolicy
+	breakers    *breakerSet // Per-worker circuit breakers
+	rf          int         // Desired replication factor
+	rebalance   rebalancer
+	epoch       epochState   // Cluster epoch stamped on every call to a worker
+	requests    requestIDs   // Request IDs for writes the client did not name
+	quorum      QuorumConfig // Default R/W for quorum mode
+	clock       versionClock // Stamps every write with a version
+	namespaces  *namespaceRegistry
+	craq        bool // Apportion chain reads across every chain node
+	chains      *chainManager
+	leases      *leaseManager
+	replication *replicationQueues // Writes owed to replicas in async and any
+	hedge       *hedger            // When failover reads also ask the next replica
+	selector    *replicaSelector   // Per-worker load and per-mode read policies
+	txns        *txnManager        // Open transactions and the coordinator log
+	entropy     antiEntropyStat    // Background CRDT repair; see crdt.go
+}
+
+// versionClock hands out strictly increasing, roughly wall-clock versions so
+// replicas (and quorum reads) can tell which of two writes is newer. It is
+// also the timestamp oracle for snapshot reads; see mvcc.go.
+type versionClock struct {
+	mu       sync.Mutex
+	last     int64
+	inflight map[int64]bool // Versions of writes not yet finished
+}
+
+func (c *versionClock) next() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nextLocked()
+}
+
+func (c *versionClock) nextLocked() int64 {
+	now := time.Now().UnixNano()
+	if now <= c.last {
+		now = c.last + 1
 	}
-	
-	return nodes
+	c.last = now
+	return now
 }
 
-type Master struct {
-	workers []string // Keep for reference
-	ring    *ConsistentHash
-	mode    string
-	mu      sync.RWMutex
-	lastScale time.Time
+// observe moves the clock past v, a version some worker already stores, so
+// later writes are not refused as stale.
+func (c *versionClock) observe(v int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v > c.last {
+		c.last = v
+	}
+}
+
+// Timeouts bounds how long each kind of operation may take end to end,
+// including every hop of a chain and every replica of a quorum.
+type Timeouts struct {
+	Put   time.Duration
+	Get   time.Duration
+	Stats time.Duration
 }
 
 // getReplicas returns the addresses of the workers that should store this key.
+// key is the storage key, so a namespaced key gets its namespace's RF.
 func (m *Master) getReplicas(key string) []string {
+	nsRF := 0
+	if ns := m.namespaces.forStorageKey(key); ns != nil {
+		nsRF = ns.ReplicationFactor
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	// Determine RF based on worker count
-	rf := 2
-	if len(m.workers) >= 3 {
-		rf = 3
+	return m.ring.GetN(key, m.replicationFactorLocked(nsRF))
+}
+
+// replicationFactorLocked is the number of replicas a key of a namespace with
+// replication factor nsRF (0 = the cluster's) gets. Caller holds m.mu.
+func (m *Master) replicationFactorLocked(nsRF int) int {
+	rf := m.rf
+	if nsRF > 0 {
+		rf = nsRF
 	}
 	// Avoid asking for more replicas than workers
 	if rf > len(m.workers) {
 		rf = len(m.workers)
 	}
-	return m.ring.GetN(key, rf)
+	return rf
+}
+
+// validateRF checks a replication factor against the cluster size.
+func validateRF(rf, workers int) error {
+	if rf < 1 {
+		return fmt.Errorf("replication factor must be at least 1, got %d", rf)
+	}
+	if rf > workers {
+		return fmt.Errorf("replication factor %d exceeds cluster size %d", rf, workers)
+	}
+	return nil
 }
 
-// Put delegates to the specific strategy.
+// Put is the RPC entry point; it delegates to put under the configured put timeout.
 func (m *Master) Put(args *common.PutArgs, reply *common.PutReply) error {
-	switch m.mode {
-	case "async":
-		return m.putAsync(args)
-	case "chain":
-		return m.putChain(args)
-	case "quorum":
-		return m.putQuorum(args)
-	case "sync":
+	return m.put(context.Background(), args, reply)
+}
+
+// put bounds the write by the caller's deadline and the put timeout, then
+// delegates to the strategy for the requested consistency level (the
+// namespace's mode's level if none was requested).
+func (m *Master) put(ctx context.Context, args *common.PutArgs, reply *common.PutReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
+	}
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := m.namespaces.checkKey(ns, args.Key); err != nil {
+		return err
+	}
+	level, err := m.resolveLevel(args.Consistency, ns.Mode)
+	if err != nil {
+		return err
+	}
+	reply.Consistency = level
+	if err := m.requests.assign(args); err != nil {
+		return err
+	}
+
+	if err := m.namespaces.admit(ns, args.Key); err != nil {
+		return err
+	}
+	userKey := args.Key
+	args.Key = ns.storageKey(userKey)
+	if args.TTL == 0 {
+		args.TTL = ns.ttl()
+	}
+	m.placeKey(args.Key, args.TTL)
+	if err := m.putAtLevel(ctx, level, args); err != nil {
+		m.namespaces.release(ns, userKey)
+		return err
+	}
+	m.namespaces.record(ns, userKey, args.TTL)
+	return nil
+}
+
+// putAtLevel runs the write strategy for level.
+func (m *Master) putAtLevel(ctx context.Context, level string, args *common.PutArgs) error {
+	ctx, cancel := common.WithDeadline(ctx, args.Deadline, m.timeouts.Put)
+	defer cancel()
+	args.Deadline = common.DeadlineOf(ctx)
+	err := m.writeAtLevel(ctx, level, args)
+	if stored, ok := common.StaleVersion(err); ok && ctx.Err() == nil {
+		// A replica already stores a newer version than our clock gave this
+		// write (another master stamped it, or our clock is behind): move past
+		// it and restamp the write once. Replicas that applied the first attempt
+		// store it again at the new version instead of answering from their
+		// dedup tables, so every replica ends up with the same version.
+		m.clock.observe(stored)
+		args.Restamp = true
+		err = m.writeAtLevel(ctx, level, args)
+	}
+	return err
+}
+
+// writeAtLevel stamps args with a fresh version and runs the write strategy for level.
+func (m *Master) writeAtLevel(ctx context.Context, level string, args *common.PutArgs) error {
+	version := m.clock.begin()
+	defer m.clock.end(version) // Incr and CRDT writes change args.Version as they resolve
+	args.Version = version
+
+	if args.Op != nil {
+		return m.putCRDT(ctx, level, args)
+	}
+	// Chain and primary modes order increments like any write; see incr.go.
+	if args.Incr && level != common.ConsistencyTailRead && level != common.ConsistencyPrimary {
+		return m.putIncr(ctx, level, args)
+	}
+	switch level {
+	case common.ConsistencyOne:
+		return m.putAsync(ctx, args)
+	case common.ConsistencyAny:
+		return m.putAny(ctx, args)
+	case common.ConsistencyTailRead:
+		return m.putChain(ctx, args)
+	case common.ConsistencyQuorum:
+		return m.putQuorum(ctx, args)
+	case common.ConsistencyPrimary:
+		return m.putPrimary(ctx, args)
+	case common.ConsistencyAll:
 		fallthrough
 	default:
-		return m.putSync(args)
+		return m.putSync(ctx, args)
 	}
 }
 
 // putSync: Write to all replicas, wait for all.
-func (m *Master) putSync(args *common.PutArgs) error {
+func (m *Master) putSync(ctx context.Context, args *common.PutArgs) error {
 	replicas := m.getReplicas(args.Key)
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(replicas))
@@ -133,7 +308,7 @@ func (m *Master) putSync(args *common.PutArgs) error {
 		wg.Add(1)
 		go func(workerAddr string) {
 			defer wg.Done()
-			if err := callWorker(workerAddr, "KV.Put", args, &common.PutReply{}); err != nil {
+			if err := m.callPut(ctx, workerAddr, args, &common.PutReply{}); err != nil {
 				errChan <- err
 			}
 		}(addr)
@@ -141,178 +316,325 @@ func (m *Master) putSync(args *common.PutArgs) error {
 	wg.Wait()
 	close(errChan)
 
-	if err := <-errChan; err != nil {
-		return err // Fail if ANY replica fails (Strict Sync)
+	var firstErr error
+	for err := range errChan {
+		if firstErr == nil || common.IsStaleVersion(err) {
+			firstErr = err // A stale version lets put restamp and retry
+		}
 	}
-	return nil
+	return firstErr // Fail if ANY replica fails (Strict Sync)
 }
 
 // putAsync: Write to Primary (wait), others in background.
-func (m *Master) putAsync(args *common.PutArgs) error {
+func (m *Master) putAsync(ctx context.Context, args *common.PutArgs) error {
 	replicas := m.getReplicas(args.Key)
 	primaryAddr := replicas[0]
-	
+	if err := m.replication.full(replicas[1:]); err != nil {
+		return err
+	}
+
 	// Write to Primary
-	if err := callWorker(primaryAddr, "KV.Put", args, &common.PutReply{}); err != nil {
+	if err := m.callPut(ctx, primaryAddr, args, &common.PutReply{}); err != nil {
 		return fmt.Errorf("primary write failed: %v", err)
 	}
 
 	// Replicate to others in background
-	for i := 1; i < len(replicas); i++ {
-		go func(workerAddr string) {
-			callWorker(workerAddr, "KV.Put", args, &common.PutReply{})
-		}(replicas[i])
-	}
-	return nil
+	return m.replicateInBackground(args, replicas[1:])
 }
 
-// putQuorum: Write to all, succeed if Majority (N/2 + 1) ack.
-func (m *Master) putQuorum(args *common.PutArgs) error {
+// putQuorum: Write to all, succeed once W replicas ack (majority by default).
+// Outstanding writes are cancelled as soon as the outcome is decided.
+func (m *Master) putQuorum(ctx context.Context, args *common.PutArgs) error {
 	replicas := m.getReplicas(args.Key)
-	required := (len(replicas) / 2) + 1
-	
-	successChan := make(chan bool, len(replicas))
-	
+	required, err := m.quorum.size(args.W, m.quorum.W, len(replicas))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errChan := make(chan error, len(replicas))
+
 	for _, addr := range replicas {
 		go func(workerAddr string) {
-			if err := callWorker(workerAddr, "KV.Put", args, &common.PutReply{}); err == nil {
-				successChan <- true
-			} else {
-				successChan <- false
-			}
+			errChan <- m.callPut(ctx, workerAddr, args, &common.PutReply{})
 		}(addr)
 	}
 
 	successCount := 0
 	failCount := 0
+	var lastErr error
 	for i := 0; i < len(replicas); i++ {
-		if <-successChan {
+		if err := <-errChan; err == nil {
 			successCount++
 		} else {
 			failCount++
+			if lastErr == nil || common.IsStaleVersion(err) {
+				lastErr = err // Keep a stale version, so put can restamp and retry
+			}
 		}
 		if successCount >= required {
 			return nil
 		}
 		if failCount > (len(replicas) - required) {
-			return fmt.Errorf("quorum failed: %d/%d success", successCount, len(replicas))
+			return fmt.Errorf("quorum failed: %d/%d success: %v", successCount, len(replicas), lastErr)
 		}
 	}
 	return fmt.Errorf("quorum failed")
 }
 
-// putChain: Write to Head, Head forwards to next...
-func (m *Master) putChain(args *common.PutArgs) error {
-	replicas := m.getReplicas(args.Key)
-	head := replicas[0]
-	
-	// Construct the chain string: "w2,w3"
-	var chain []string
-	for i := 1; i < len(replicas); i++ {
-		chain = append(chain, replicas[i])
-	}
-	
-	args.ForwardTo = strings.Join(chain, ",")
-	return callWorker(head, "KV.Put", args, &common.PutReply{})
+// Get is the RPC entry point; it delegates to get under the configured get timeout.
+func (m *Master) Get(args *common.GetArgs, reply *common.GetReply) error {
+	return m.get(context.Background(), args, reply)
 }
 
-// Get delegates to strategy.
-func (m *Master) Get(args *common.GetArgs, reply *common.GetReply) error {
-	if m.mode == "quorum" {
-		return m.getQuorum(args, reply)
+// get bounds the read by the caller's deadline and the get timeout, then
+// delegates to the strategy for the requested consistency level.
+func (m *Master) get(ctx context.Context, args *common.GetArgs, reply *common.GetReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
 	}
-	// For Chain, Sync, Async -> Read from Tail (Chain) or Failover (Sync/Async)
-	if m.mode == "chain" {
-		return m.getChain(args, reply)
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	level, err := m.resolveLevel(args.Consistency, ns.Mode)
+	if err != nil {
+		return err
+	}
+	defer func() { reply.Consistency = level }()
+	args.Key = ns.storageKey(args.Key)
+
+	ctx, cancel := common.WithDeadline(ctx, args.Deadline, m.timeouts.Get)
+	defer cancel()
+	args.Deadline = common.DeadlineOf(ctx)
+
+	mode := ns.Mode
+	if mode == "" {
+		mode = m.currentMode()
+	}
+	policy := m.selector.policy(mode)
+
+	switch level {
+	case common.ConsistencyQuorum:
+		return m.getQuorum(ctx, args, reply, policy)
+	case common.ConsistencyAll:
+		if mode == "sync" && args.Consistency == "" && policy != readRing {
+			// Every acknowledged sync write is on every replica, so any one will do.
+			return m.getFailover(ctx, args, reply, policy)
+		}
+		return m.getAll(ctx, args, reply)
+	case common.ConsistencyTailRead:
+		return m.getChain(ctx, args, reply)
+	case common.ConsistencyPrimary:
+		return m.getPrimary(ctx, args, reply)
+	default:
+		// One/Any -> Failover across replicas
+		return m.getFailover(ctx, args, reply, policy)
 	}
-	return m.getFailover(args, reply)
 }
 
-// getFailover: Try replicas one by one.
-func (m *Master) getFailover(args *common.GetArgs, reply *common.GetReply) error {
-	replicas := m.getReplicas(args.Key)
+// getFailover: Try replicas one by one, in the order the read policy picks
+// (or hedge across them, if enabled).
+func (m *Master) getFailover(ctx context.Context, args *common.GetArgs, reply *common.GetReply, policy string) error {
+	replicas := m.selector.order(policy, m.getReplicas(args.Key))
+	if delay, ok := m.hedge.delay(); ok && len(replicas) > 1 {
+		return m.getHedged(ctx, args, reply, replicas, delay)
+	}
 	var lastErr error
 	for _, addr := range replicas {
-		if err := callWorker(addr, "KV.Get", args, reply); err == nil {
+		// A fresh reply per attempt: an abandoned call may still be decoding into the old one.
+		r := &common.GetReply{}
+		start := time.Now()
+		if err := m.callWorker(ctx, addr, "KV.Get", args, r); err == nil {
+			m.hedge.observe(time.Since(start))
+			m.hedge.record(0, false)
+			*reply = *r
 			return nil
 		} else {
 			lastErr = err
 		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
+	m.hedge.record(0, false)
 	return fmt.Errorf("all replicas failed: %v", lastErr)
 }
 
-// getChain: Read from the Tail (Last replica).
-func (m *Master) getChain(args *common.GetArgs, reply *common.GetReply) error {
-	replicas := m.getReplicas(args.Key)
+// getChain: Read the committed value. With CRAQ any chain node can answer (a
+// node holding an uncommitted write checks with the Tail first), so reads are
+// spread across the whole chain; without it only the Tail (Last replica) serves them.
+func (m *Master) getChain(ctx context.Context, args *common.GetArgs, reply *common.GetReply) error {
+	replicas := m.chainFor(args.Key)
+	if len(replicas) == 0 {
+		return fmt.Errorf("no live chain for %s: every replica has been spliced out", args.Key)
+	}
 	tail := replicas[len(replicas)-1]
-	return callWorker(tail, "KV.Get", args, reply)
+	if !m.craq || len(replicas) == 1 {
+		return m.callIdempotent(ctx, tail, "KV.Get", args, reply)
+	}
+
+	node := replicas[rand.Intn(len(replicas))]
+	craqArgs := *args
+	craqArgs.Tail = tail
+	r := &common.GetReply{}
+	err := m.callWorker(ctx, node, "KV.Get", &craqArgs, r)
+	if err == nil {
+		*reply = *r
+		return nil
+	}
+	if node == tail || ctx.Err() != nil {
+		return err
+	}
+	// The chosen node is unhealthy; the tail always has the committed value.
+	return m.callIdempotent(ctx, tail, "KV.Get", args, reply)
 }
 
-// getQuorum: Read from Majority, check for agreement.
-func (m *Master) getQuorum(args *common.GetArgs, reply *common.GetReply) error {
-	replicas := m.getReplicas(args.Key)
-	required := (len(replicas) / 2) + 1
-	
+// getQuorum: Read until R replicas answer (majority by default) and return the
+// newest version among them. When R+W>N that set overlaps every write quorum,
+// so the newest version is the latest acknowledged write.
+// Returns as soon as the outcome is decided and cancels the reads still outstanding.
+// Under ring policy every replica is asked at once; otherwise only the R the
+// policy prefers are, and each failure brings in the next.
+func (m *Master) getQuorum(ctx context.Context, args *common.GetArgs, reply *common.GetReply, policy string) error {
+	replicas := m.selector.order(policy, m.getReplicas(args.Key))
+	required, err := m.quorum.size(args.R, m.quorum.R, len(replicas))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	type result struct {
-		val   string
-		found bool
+		reply *common.GetReply
 		err   error
 	}
 	resChan := make(chan result, len(replicas))
 
-	for _, addr := range replicas {
-		go func(workerAddr string) {
+	next := 0
+	send := func() {
+		workerAddr := replicas[next]
+		next++
+		go func() {
 			r := &common.GetReply{}
-			if err := callWorker(workerAddr, "KV.Get", args, r); err != nil {
-				resChan <- result{err: err}
-			} else {
-				resChan <- result{val: r.Value, found: r.Found}
-			}
-		}(addr)
+			err := m.callIdempotent(ctx, workerAddr, "KV.Get", args, r)
+			resChan <- result{reply: r, err: err}
+		}()
+	}
+	initial := len(replicas)
+	if policy != readRing {
+		initial = required
+	}
+	for next < initial {
+		send()
 	}
 
-	counts := make(map[string]int)
-	// count successes not strictly needed if we just check consensus, but good for debugging
-	
+	var newest common.GetReply
+	successCount := 0
+	failCount := 0
 	for i := 0; i < len(replicas); i++ {
-		res := <-resChan
-		if res.err == nil && res.found {
-			counts[res.val]++
+		var res result
+		select {
+		case res = <-resChan:
+		case <-ctx.Done():
+			return fmt.Errorf("quorum read failed: %v", ctx.Err())
 		}
-	}
-
-	for val, count := range counts {
-		if count >= required {
-			reply.Value = val
-			reply.Found = true
+		if res.err != nil {
+			failCount++
+			if failCount > len(replicas)-required {
+				return fmt.Errorf("quorum read failed: %d/%d replicas answered, need %d: %v", successCount, len(replicas), required, res.err)
+			}
+			if next < len(replicas) {
+				send()
+			}
+			continue
+		}
+		successCount++
+		if res.reply.Found && (!newest.Found || res.reply.Version > newest.Version) {
+			newest = *res.reply
+		}
+		if successCount >= required {
+			*reply = newest
 			return nil
 		}
 	}
-	return fmt.Errorf("quorum read failed: no consensus found")
+	return fmt.Errorf("quorum read failed")
 }
 
-func callWorker(addr string, method string, args interface{}, reply interface{}) error {
-	client, err := rpc.Dial("tcp", addr)
+// callWorker invokes method on the worker at addr, bounded by ctx.
+// Requests to a worker whose circuit breaker is open fail fast without dialing.
+func (m *Master) callWorker(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	probe, err := m.breakers.allow(addr)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
-	return client.Call(method, args, reply)
+	m.selector.begin(addr)
+	start := time.Now()
+	err = common.Call(ctx, addr, method, m.stamp(args), reply)
+	if common.IsStaleRouting(err) && m.staleRetry(addr, err) {
+		err = common.Call(ctx, addr, method, m.stamp(args), reply)
+	}
+	m.selector.end(addr, method, time.Since(start))
+	m.breakers.record(addr, probe, err)
+	return err
 }
 
 func enableCors(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Consistency-Level")
+}
+
+// intParam parses an optional integer query parameter (0 if absent).
+func intParam(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return n, nil
+}
+
+// durationParam parses an optional duration query parameter such as "30s" (0 if absent).
+func durationParam(r *http.Request, name string) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return d, nil
 }
 
 // API Structs
+
 type StatusResponse struct {
-	Nodes  []string     `json:"nodes"`
-	Mode   string       `json:"mode"`
-	Stats  []WorkerStat `json:"stats"`
-	Config SystemConfig `json:"config"`
+	Nodes       []string          `json:"nodes"`
+	Mode        string            `json:"mode"`
+	Stats       []WorkerStat      `json:"stats"`
+	Config      SystemConfig      `json:"config"`
+	Breakers    []BreakerStat     `json:"breakers"`
+	Rebalance   RebalanceStat     `json:"rebalance"`
+	Quorum      QuorumStat        `json:"quorum"`
+	Namespaces  []Namespace       `json:"namespaces"`
+	Chain       ChainStat         `json:"chain"`
+	Leases      LeaseStatus       `json:"leases"`
+	Replication []ReplicationStat `json:"replication"`
+	Hedging     HedgeStat         `json:"hedging"`
+	Reads       ReadPolicyStat    `json:"reads"`
+	Placement   PlacementStat     `json:"placement"`
+	Zones       ZoneStat          `json:"zones"`
+	Ranges      *RangeStat        `json:"ranges,omitempty"` // Range partitioner only
+	Epoch       EpochStat         `json:"epoch"`
+	Txns        TxnStat           `json:"transactions"`
+	AntiEntropy AntiEntropyStat   `json:"anti_entropy"`
 }
 
 type WorkerStat struct {
@@ -322,14 +644,29 @@ type WorkerStat struct {
 	MaxKeys     int      `json:"max_keys"`
 	MaxLoad     int      `json:"max_load"`
 	Keys        []string `json:"keys"`
+	CleanReads  int      `json:"clean_reads"`
+	DirtyReads  int      `json:"dirty_reads"`
+	DirtyKeys   int      `json:"dirty_keys"`
+	Zone        string   `json:"zone,omitempty"`
+	Duplicates  int      `json:"duplicates"`
+	OldVersions int      `json:"old_versions"`
 }
 
 type SystemConfig struct {
-	Replicas int `json:"replicas"`
+	Replicas          int    `json:"replicas"`
+	ReplicationFactor int    `json:"replication_factor"`
+	CRAQ              bool   `json:"craq"`
+	Hedge             string `json:"hedge"`
+	Partitioner       string `json:"partitioner"`
 }
 
 type ConfigRequest struct {
-	Mode string `json:"mode"`
+	Mode              string            `json:"mode"`
+	ReplicationFactor int               `json:"replication_factor"` // 0 = unchanged
+	ReadQuorum        *int              `json:"read_quorum"`        // nil = unchanged, 0 = majority
+	WriteQuorum       *int              `json:"write_quorum"`       // nil = unchanged, 0 = majority
+	Hedge             string            `json:"hedge"`              // "" = unchanged; "off", "pNN" or a duration
+	ReadPolicy        map[string]string `json:"read_policy"`        // Mode -> read policy; absent modes unchanged
 }
 
 func (m *Master) handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -342,8 +679,19 @@ func (m *Master) handleStatus(w http.ResponseWriter, r *http.Request) {
 	nodes := make([]string, len(m.workers))
 	copy(nodes, m.workers)
 	mode := m.mode
-	replicas := m.ring.replicas
+	replicas := 0
+	if ring, ok := m.ring.(*ConsistentHash); ok {
+		replicas = ring.replicas
+	}
+	rf := m.rf
+	quorum := m.quorum
 	m.mu.RUnlock()
+	if rf > len(nodes) {
+		rf = len(nodes)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.timeouts.Stats)
+	defer cancel()
 
 	stats := []WorkerStat{}
 	var wg sync.WaitGroup
@@ -354,7 +702,9 @@ func (m *Master) handleStatus(w http.ResponseWriter, r *http.Request) {
 		go func(addr string) {
 			defer wg.Done()
 			var s common.StatsReply
-			if err := callWorker(addr, "KV.GetStats", &common.StatsArgs{}, &s); err == nil {
+			if err := m.callIdempotent(ctx, addr, "KV.GetStats", &common.StatsArgs{}, &s); err == nil {
+				m.selector.observeRate(addr, s.RequestRate, s.MaxLoad)
+				m.observeZone(addr, s.Zone, false)
 				mu.Lock()
 				stats = append(stats, WorkerStat{
 					Address:     addr,
@@ -363,6 +713,12 @@ func (m *Master) handleStatus(w http.ResponseWriter, r *http.Request) {
 					MaxKeys:     s.MaxKeys,
 					MaxLoad:     s.MaxLoad,
 					Keys:        s.Keys,
+					CleanReads:  s.CleanReads,
+					DirtyReads:  s.DirtyReads,
+					DirtyKeys:   s.DirtyKeys,
+					Zone:        s.Zone,
+					Duplicates:  s.Duplicates,
+					OldVersions: s.OldVersions,
 				})
 				mu.Unlock()
 			}
@@ -375,8 +731,29 @@ func (m *Master) handleStatus(w http.ResponseWriter, r *http.Request) {
 		Mode:  mode,
 		Stats: stats,
 		Config: SystemConfig{
-			Replicas: replicas,
+			Replicas:          replicas,
+			ReplicationFactor: rf,
+			CRAQ:              m.craq,
+			Hedge:             m.hedge.stat().Policy,
+			Partitioner:       m.partitioner,
 		},
+		Breakers:    m.breakers.snapshot(),
+		Rebalance:   m.rebalance.status(),
+		Quorum:      quorum.stat(rf),
+		Namespaces:  m.namespaces.list(),
+		Chain:       m.chains.stat(),
+		Leases:      m.leases.status(),
+		Replication: m.replication.stats(),
+		Hedging:     m.hedge.stat(),
+		Reads:       m.selector.stat(),
+		Placement:   m.ring.Distribution(),
+		Zones:       zoneReport(m.zones.snapshot(), stats, rf),
+		Epoch:       m.epoch.stat(),
+		Txns:        m.txns.stat(),
+		AntiEntropy: m.entropy.status(),
+	}
+	if p, ok := m.ring.(*RangePartitioner); ok {
+		resp.Ranges = p.stat()
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -398,13 +775,75 @@ func (m *Master) handleConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate the whole request before applying any of it, so a bad field
+	// leaves the configuration as it was.
+	if req.Hedge != "" {
+		if _, _, err := parseHedge(req.Hedge); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	for mode, policy := range req.ReadPolicy {
+		if err := checkReadPolicy(mode, policy); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	if req.Mode != "" && !validMode(req.Mode) {
+		http.Error(w, fmt.Sprintf("unknown replication mode %q", req.Mode), 400)
+		return
+	}
+
 	m.mu.Lock()
+	rf, quorum := m.rf, m.quorum
+	if req.ReplicationFactor != 0 {
+		if err := validateRF(req.ReplicationFactor, len(m.workers)); err != nil {
+			m.mu.Unlock()
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		rf = req.ReplicationFactor
+	}
+	if req.ReadQuorum != nil {
+		quorum.R = *req.ReadQuorum
+	}
+	if req.WriteQuorum != nil {
+		quorum.W = *req.WriteQuorum
+	}
+	if err := quorum.validate(rf); err != nil {
+		m.mu.Unlock()
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if quorum != m.quorum {
+		m.quorum = quorum
+		st := quorum.stat(rf)
+		log.Printf("[Config] Quorum set to R=%d W=%d N=%d (strong: %v)", st.R, st.W, st.N, st.Strong)
+	}
+	if req.Hedge != "" {
+		m.hedge.configure(req.Hedge)
+		log.Printf("[Config] Read hedging set to %s", req.Hedge)
+	}
+	for mode, policy := range req.ReadPolicy {
+		m.selector.setPolicy(mode, policy)
+		log.Printf("[Config] Read policy for %s set to %s", mode, policy)
+	}
 	if req.Mode != "" {
 		m.mode = req.Mode
 		log.Printf("[Config] Mode changed to %s", m.mode)
 	}
+	rfChanged := req.ReplicationFactor != 0 && req.ReplicationFactor != m.rf
+	if rfChanged {
+		log.Printf("[Config] Replication factor changed from %d to %d", m.rf, req.ReplicationFactor)
+		m.rf = req.ReplicationFactor
+	}
 	m.mu.Unlock()
 
+	if rfChanged {
+		m.triggerRebalance(fmt.Sprintf("replication factor set to %d", req.ReplicationFactor))
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -415,6 +854,9 @@ func (m *Master) monitorAndScale() {
 	log.Println("[AutoScaler] Started monitoring...")
 	for range ticker.C {
 		m.scaleCheck()
+		if p, ok := m.ring.(*RangePartitioner); ok {
+			m.balanceRanges(p)
+		}
 	}
 }
 
@@ -431,10 +873,15 @@ func (m *Master) scaleCheck() {
 	}
 
 	var overload bool
-	
+
 	for _, w := range workers {
 		stats := &common.StatsReply{}
-		if err := callWorker(w, "KV.GetStats", &common.StatsArgs{}, stats); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, stats)
+		cancel()
+		if err == nil {
+			m.selector.observeRate(w, stats.RequestRate, stats.MaxLoad)
+			m.observeZone(w, stats.Zone, false)
 			// Rule 1: Key Capacity (> 80%)
 			if stats.MaxKeys > 0 && float64(stats.KeyCount) >= float64(stats.MaxKeys)*0.8 {
 				log.Printf("[AutoScaler] Worker %s is overloaded (Keys: %d/%d)", w, stats.KeyCount, stats.MaxKeys)
@@ -478,17 +925,17 @@ func (m *Master) scaleUp() {
 	log.Printf("[AutoScaler] Scaling Up! Starting new worker on %s...", newAddr)
 
 	// 2. Start the process
-	// Note: We inherit limits from a default or random. For now, let's just give it the same limits if we knew them, 
-	// but we don't easily know them here without tracking config. 
+	// Note: We inherit limits from a default or random. For now, let's just give it the same limits if we knew them,
+	// but we don't easily know them here without tracking config.
 	// We'll spawn with default (unlimited) or generic limits for the demo.
 	// Actually, let's give it generous limits: 1000 keys, 1000 req/s
-	cmd := exec.Command("go", "run", "./cmd/worker/main.go", "-max-keys=1000", "-max-load=100", strconv.Itoa(newPort))
-	
+	cmd := exec.Command("go", "run", "./cmd/worker", "-max-keys=1000", "-max-load=100", strconv.Itoa(newPort))
+
 	// Redirect logs so we can see them
 	logFile, _ := os.Create(fmt.Sprintf("logs/w%d.log", newPort))
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
-	
+
 	if err := cmd.Start(); err != nil {
 		log.Printf("[AutoScaler] Failed to start worker: %v", err)
 		return
@@ -498,15 +945,50 @@ func (m *Master) scaleUp() {
 
 	// 3. Add to Ring
 	// Wait a bit for it to come up
-	time.Sleep(1 * time.Second) 
-	
+	time.Sleep(1 * time.Second)
+
 	m.ring.Add(newAddr)
 	m.workers = append(m.workers, newAddr)
 	log.Printf("[AutoScaler] Worker %s added to cluster. Total workers: %d", newAddr, len(m.workers))
+	m.bumpEpoch("worker " + newAddr + " added")
+	if m.weights.fromCapacity {
+		go func() {
+			if err := m.reweightFromCapacity("worker " + newAddr + " added"); err != nil {
+				log.Printf("[Weights] %v", err)
+			}
+		}()
+	}
 }
 
 func main() {
-	mode := flag.String("mode", "sync", "Replication mode: sync, async, chain, quorum")
+	mode := flag.String("mode", "sync", "Replication mode: sync, async, chain, quorum, primary")
+	rf := flag.Int("rf", 3, "Replication factor: number of workers that store each key")
+	readQuorum := flag.Int("r", 0, "Read quorum for quorum mode (0 = majority of replicas)")
+	writeQuorum := flag.Int("w", 0, "Write quorum for quorum mode (0 = majority of replicas)")
+	leaseDuration := flag.Duration("lease", 3*time.Second, "Primary mode: how long a primary lease lasts before it must be renewed")
+	replDir := flag.String("repl-dir", "data/replication", "Directory for the durable async replication queues (empty = memory only)")
+	replQueue := flag.Int("repl-queue", 10000, "Maximum writes queued per replica before async writes are refused (0 = unlimited)")
+	antiEntropy := flag.Duration("anti-entropy", 10*time.Second, "How often to merge the replicas of every CRDT key with one another (0 = never)")
+	txnLog := flag.String("txn-log", "data/txn.log", "Durable coordinator log for transactions (empty = memory only)")
+	txnTimeout := flag.Duration("txn-timeout", 30*time.Second, "Drop an open transaction left idle this long")
+	readPolicy := flag.String("read-policy", "", "How reads pick replicas: ring, least-loaded or p2c, for every mode that allows it, or per mode (async=p2c,quorum=least-loaded)")
+	partitioner := flag.String("partitioner", partitionRing, "How keys are placed on workers: ring, rendezvous, jump, maglev or range")
+	rangeTable := flag.String("range-table", "data/ranges.json", "Range partitioner: file the range table is saved to (empty = memory only)")
+	rangeSplitKeys := flag.Int("range-split-keys", 500, "Range partitioner: split a range on a worker holding more keys than this (0 = never)")
+	rangeSplitRate := flag.Int("range-split-rate", 0, "Range partitioner: split a range on a worker serving more requests/s than this (0 = never)")
+	rangeMergeKeys := flag.Int("range-merge-keys", 100, "Range partitioner: merge adjacent ranges holding fewer keys than this between them (0 = never)")
+	hashName := flag.String("hash", "crc32", "Hash function the partitioner uses: crc32, fnv or xxhash")
+	capacityWeights := flag.Bool("capacity-weights", false, "Weight each worker's virtual nodes by the MaxKeys/MaxLoad it advertises (ring only)")
+	balance := flag.Float64("bounded-load", 0, "Bounded-load placement: cap each worker at (1+ε)×the average key replicas per worker; this sets ε (0 = off)")
+	hedge := flag.String("hedge", "off", "Hedge failover reads to the next replica after this delay: off, a percentile of recent read latency (p95) or a duration (20ms)")
+	craq := flag.Bool("craq", true, "Chain mode: apportion reads across all chain nodes (CRAQ) instead of only the tail")
+	putTimeout := flag.Duration("put-timeout", 3*time.Second, "Deadline for a whole Put, across every replica and chain hop")
+	getTimeout := flag.Duration("get-timeout", 2*time.Second, "Deadline for a whole Get, across every replica tried")
+	statsTimeout := flag.Duration("stats-timeout", 1*time.Second, "Deadline for polling a worker's stats")
+	breakerThreshold := flag.Int("breaker-threshold", 5, "Consecutive failures before a worker's circuit breaker opens")
+	breakerCooldown := flag.Duration("breaker-cooldown", 5*time.Second, "How long an open breaker sheds requests before probing")
+	retries := flag.Int("retries", 3, "Attempts per idempotent call (reads, stats), including the first")
+	retryBackoff := flag.Duration("retry-backoff", 50*time.Millisecond, "Base backoff between retries; doubles per attempt, with jitter")
 	flag.Parse()
 
 	args := flag.Args()
@@ -517,20 +999,107 @@ func main() {
 	masterPort := args[0]
 	workerAddrs := args[1:]
 
-	// Initialize Consistent Hash Ring
-	ring := NewConsistentHash(20) // 20 virtual nodes per worker
+	if err := validateRF(*rf, len(workerAddrs)); err != nil {
+		if *rf < 1 {
+			log.Fatalf("invalid -rf: %v", err)
+		}
+		// The auto-scaler may grow the cluster into it; until then getReplicas caps it.
+		log.Printf("Warning: %v; using %d replicas until the cluster grows", err, len(workerAddrs))
+	}
+	quorum := QuorumConfig{R: *readQuorum, W: *writeQuorum}
+	if err := quorum.validate(*rf); err != nil {
+		log.Fatalf("invalid -r/-w: %v", err)
+	}
+	hedging, err := newHedger(*hedge)
+	if err != nil {
+		log.Fatalf("invalid -hedge: %v", err)
+	}
+	readPolicies, err := parseReadPolicies(*readPolicy)
+	if err != nil {
+		log.Fatalf("invalid -read-policy: %v", err)
+	}
+
+	// Initialize the partitioner (a consistent hash ring by default)
+	ring, err := newPartitioner(*partitioner, *hashName, 20) // 20 virtual nodes per worker on a ring
+	if err != nil {
+		log.Fatalf("invalid -partitioner/-hash: %v", err)
+	}
+	partitionName := *partitioner + "/" + *hashName
+	if p, ok := ring.(*RangePartitioner); ok {
+		if err := p.Load(*rangeTable); err != nil {
+			log.Fatalf("Failed to load the range table: %v", err)
+		}
+		p.SetThresholds(*rangeSplitKeys, *rangeSplitRate, *rangeMergeKeys)
+		partitionName = *partitioner // Ranges are not hashed
+	}
 	ring.Add(workerAddrs...)
+	if *balance < 0 {
+		log.Fatalf("invalid -bounded-load: %v must not be negative", *balance)
+	}
+	if *balance > 0 {
+		c, ok := ring.(*ConsistentHash)
+		if !ok {
+			log.Fatalf("invalid -bounded-load: bounded loads need -partitioner=ring")
+		}
+		c.SetBalance(*balance)
+	}
 
 	master := &Master{
-		workers: workerAddrs, 
-		ring:    ring,
-		mode:    *mode,
+		workers:     workerAddrs,
+		ring:        ring,
+		partitioner: partitionName,
+		weights:     newNodeWeights(*capacityWeights),
+		zones:       newWorkerZones(),
+		mode:        *mode,
+		timeouts: Timeouts{
+			Put:   *putTimeout,
+			Get:   *getTimeout,
+			Stats: *statsTimeout,
+		},
+		retry: RetryPolicy{
+			Attempts: *retries,
+			Base:     *retryBackoff,
+			Max:      1 * time.Second,
+		},
+		breakers:   newBreakerSet(*breakerThreshold, *breakerCooldown),
+		rf:         *rf,
+		quorum:     quorum,
+		namespaces: newNamespaceRegistry(),
+		craq:       *craq,
+		chains:     newChainManager(),
+		leases:     newLeaseManager(*leaseDuration),
+		hedge:      hedging,
+		selector:   newReplicaSelector(readPolicies),
+	}
+	coordinatorLog, err := openTxnLog(*txnLog)
+	if err != nil {
+		log.Fatalf("Failed to open the transaction log: %v", err)
+	}
+	master.txns = newTxnManager(coordinatorLog, *txnTimeout, &master.clock)
+	master.replication = newReplicationQueues(master, *replDir, *replQueue)
+	if err := master.replication.load(); err != nil {
+		log.Fatalf("Failed to restore replication queues: %v", err)
+	}
+	master.takeOverEpoch()
+	master.recoverTxns()
+	master.learnZones(true)
+	if *balance > 0 {
+		master.restorePlacement()
+	}
+	if *capacityWeights {
+		if err := master.reweightFromCapacity("derived from capacity"); err != nil {
+			log.Printf("[Weights] Keeping equal weights for now: %v", err)
+		}
 	}
 	rpc.RegisterName("KV", master)
 	rpc.HandleHTTP()
-	
+
 	// Start AutoScaler
 	go master.monitorAndScale()
+	go master.superviseChains()
+	go master.superviseLeases()
+	go master.superviseVersions()
+	go master.superviseCRDTs(*antiEntropy)
 
 	// HTTP Gateway
 	http.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
@@ -540,19 +1109,56 @@ func main() {
 			http.Error(w, "missing params", 400)
 			return
 		}
-		if err := master.Put(&common.PutArgs{Key: key, Value: val}, &common.PutReply{}); err != nil {
-			http.Error(w, err.Error(), 500)
+		wq, err := intParam(r, "w")
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		level := r.URL.Query().Get("consistency")
+		if !common.ValidConsistency(level) {
+			http.Error(w, fmt.Sprintf("unknown consistency level %q", level), 400)
 			return
 		}
-		fmt.Fprintf(w, "OK (Mode: %s)\n", master.mode)
+		reply := &common.PutReply{}
+		putArgs := &common.PutArgs{Key: key, Value: val, W: wq, Consistency: level, Namespace: r.URL.Query().Get("ns"),
+			ClientID: r.URL.Query().Get("client"), RequestID: r.URL.Query().Get("request_id")}
+		if err := checkRequestID(putArgs.ClientID, putArgs.RequestID); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if putArgs.TTL, err = durationParam(r, "ttl"); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := master.put(r.Context(), putArgs, reply); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.Header().Set("X-Consistency-Level", reply.Consistency)
+		fmt.Fprintf(w, "OK (Mode: %s, Consistency: %s)\n", master.currentMode(), reply.Consistency)
 	})
 
 	http.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
 		enableCors(w)
 		key := r.URL.Query().Get("key")
+		rq, err := intParam(r, "r")
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		level := r.URL.Query().Get("consistency")
+		if !common.ValidConsistency(level) {
+			http.Error(w, fmt.Sprintf("unknown consistency level %q", level), 400)
+			return
+		}
 		reply := &common.GetReply{}
-		if err := master.Get(&common.GetArgs{Key: key}, reply); err != nil {
-			http.Error(w, err.Error(), 500)
+		err = master.get(r.Context(), &common.GetArgs{Key: key, R: rq, Consistency: level, Namespace: r.URL.Query().Get("ns")}, reply)
+		// The level (and version, for WATCH-style transactions) are echoed in
+		// headers so the body stays just the value.
+		w.Header().Set("X-Consistency-Level", reply.Consistency)
+		w.Header().Set("X-Version", strconv.FormatInt(reply.Version, 10))
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
 			return
 		}
 		if !reply.Found {
@@ -564,11 +1170,17 @@ func main() {
 
 	http.HandleFunc("/status", master.handleStatus)
 	http.HandleFunc("/config", master.handleConfig)
+	http.HandleFunc("/namespaces", master.handleNamespaces)
+	http.HandleFunc("/weights", master.handleWeights)
+	http.HandleFunc("/ranges", master.handleRanges)
+	http.HandleFunc("/txn/", master.handleTxn)
+	http.HandleFunc("/incr", master.handleIncr)
+	http.HandleFunc("/crdt", master.handleCRDT)
 
 	// Serve UI
 	fs := http.FileServer(http.Dir("./ui"))
 	http.Handle("/", fs)
-	
+
 	// Start HTTP Server for Client
 	go http.ListenAndServe(":8080", nil)
 
@@ -581,4 +1193,4 @@ func main() {
 		conn, _ := l.Accept()
 		go rpc.ServeConn(conn)
 	}
-}
\ No newline at end of file
+}
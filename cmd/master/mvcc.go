@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MVCC (see worker/mvcc.go): workers keep superseded versions of each
+// key, and a read-only transaction reads every key as of one snapshot
+// timestamp. The master's version clock is the timestamp oracle. It stamps
+// every write and tracks which writes are still in flight. A snapshot is
+// taken just below the oldest of them, so every write it can see has
+// already finished. Once a second the master tells every worker the oldest
+// timestamp an open snapshot reads at. Versions no snapshot can read any
+// longer are dropped.
+
+// versionGCInterval is how often workers are told to drop old versions.
+const versionGCInterval = time.Second
+
+// begin stamps a write with the next version and keeps snapshots below it
+// until end is called.
+func (c *versionClock) begin() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.nextLocked()
+	if c.inflight == nil {
+		c.inflight = make(map[int64]bool)
+	}
+	c.inflight[v] = true
+	return v
+}
+
+// end marks the write stamped v as finished, whether it succeeded or not.
+func (c *versionClock) end(v int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, v)
+}
+
+// snapshot returns a timestamp every write at or below which has finished.
+// Snapshots never go backwards: every write still in flight, and every write
+// begun later, has a newer version.
+func (c *versionClock) snapshot() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.inflight) == 0 {
+		return c.nextLocked()
+	}
+	oldest := int64(0)
+	for v := range c.inflight {
+		if oldest == 0 || v < oldest {
+			oldest = v
+		}
+	}
+	return oldest - 1
+}
+
+// snapshotGet reads a key as of ts from every replica and keeps the newest
+// version found. An async write may have finished on a single replica, so
+// every replica must answer: otherwise the one holding it may be the one
+// that did not.
+func (m *Master) snapshotGet(ctx context.Context, args *common.TxnGetArgs, ts int64, reply *common.GetReply) error {
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	key := ns.storageKey(args.Key)
+	ctx, cancel := common.WithDeadline(ctx, time.Time{}, m.timeouts.Get)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	answered := 0
+	for _, addr := range m.getReplicas(key) {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var r common.GetReply
+			err := m.callIdempotent(ctx, addr, "KV.Get", &common.GetArgs{Key: key, AsOf: ts, Deadline: common.DeadlineOf(ctx)}, &r)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				if firstErr == nil || common.IsSnapshotTooOld(err) {
+					firstErr = fmt.Errorf("%s: %w", addr, err)
+				}
+			case answered == 0 || (r.Found && (!reply.Found || r.Version > reply.Version)):
+				answered++
+				*reply = r
+			default:
+				answered++
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		*reply = common.GetReply{}
+		return fmt.Errorf("snapshot read of %s: %w", args.Key, firstErr)
+	}
+	return nil
+}
+
+// superviseVersions periodically lets workers drop versions no snapshot can read.
+func (m *Master) superviseVersions() {
+	ticker := time.NewTicker(versionGCInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.collectVersions()
+	}
+}
+
+// collectVersions sends every worker the oldest timestamp an open snapshot
+// may read at.
+func (m *Master) collectVersions() {
+	before := m.txns.watermark()
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+	dropped := 0
+	for _, w := range workers {
+		var reply common.CollectReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callWorker(ctx, w, "KV.Collect", &common.CollectArgs{Before: before}, &reply)
+		cancel()
+		if err != nil {
+			log.Printf("[MVCC] Could not collect old versions on %s: %v", w, err)
+			continue
+		}
+		dropped += reply.Dropped
+	}
+	m.txns.mu.Lock()
+	m.txns.collected += dropped
+	m.txns.mu.Unlock()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"customise-db/common"
+	"customise-db/worker"
+	"testing"
+)
+
+func TestVersionClock_SnapshotsStayBelowWritesInFlight(t *testing.T) {
+	var c versionClock
+	first, second := c.begin(), c.begin()
+	if s := c.snapshot(); s != first-1 {
+		t.Errorf("Expected a snapshot just below the oldest write in flight (%d), got %d", first-1, s)
+	}
+	c.end(first)
+	if s := c.snapshot(); s != second-1 {
+		t.Errorf("Expected a snapshot just below %d once %d finished, got %d", second, first, s)
+	}
+	c.end(second)
+	if s := c.snapshot(); s <= second {
+		t.Errorf("Expected a snapshot past every finished write, got %d <= %d", s, second)
+	}
+}
+
+func TestSnapshotTxn_ReadsEveryKeyAsOfOneTimestamp(t *testing.T) {
+	var addrs []string
+	workers := make(map[string]*worker.KVWorker)
+	for i := 0; i < 3; i++ {
+		addr, w := startWorker(t)
+		addrs = append(addrs, addr)
+		workers[addr] = w
+	}
+	m := newTestMaster(addrs, 2)
+	for _, key := range []string{"a", "b"} {
+		if err := m.Put(&common.PutArgs{Key: key, Value: "1"}, &common.PutReply{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	// One replica of b lags behind, as it may in async mode.
+	workers[m.getReplicas("b")[0]].Delete(&common.DeleteArgs{Key: "b"}, &common.DeleteReply{})
+
+	snap := &common.TxnReply{}
+	if err := m.Begin(&common.TxnArgs{ReadOnly: true}, snap); err != nil || snap.Snapshot == 0 {
+		t.Fatalf("Begin failed: %+v (%v)", snap, err)
+	}
+	m.Put(&common.PutArgs{Key: "a", Value: "2"}, &common.PutReply{})
+	m.Put(&common.PutArgs{Key: "new", Value: "2"}, &common.PutReply{})
+
+	for key, want := range map[string]string{"a": "1", "b": "1", "new": ""} {
+		got := &common.GetReply{}
+		if err := m.TxnGet(&common.TxnGetArgs{TxnID: snap.TxnID, Key: key}, got); err != nil || got.Value != want || got.Found != (want != "") {
+			t.Errorf("%s in the snapshot: expected %q, got %+v (%v)", key, want, got, err)
+		}
+	}
+	if latest := (&common.GetReply{}); m.Get(&common.GetArgs{Key: "a"}, latest) != nil || latest.Value != "2" {
+		t.Errorf("Expected a plain read to see the newer write, got %+v", latest)
+	}
+	if err := m.TxnPut(&common.TxnPutArgs{TxnID: snap.TxnID, Key: "a", Value: "3"}, &common.TxnReply{}); err == nil {
+		t.Errorf("Expected a write in a read-only transaction to be refused")
+	}
+
+	// Workers keep what the open snapshot needs, and no more once it ends.
+	asOf := func(key string) (*common.GetReply, error) {
+		got := &common.GetReply{}
+		return got, workers[m.getReplicas(key)[0]].Get(&common.GetArgs{Key: key, AsOf: snap.Snapshot}, got)
+	}
+	m.collectVersions()
+	if got, err := asOf("a"); err != nil || got.Value != "1" {
+		t.Errorf("Expected a's version in the snapshot kept while it is open, got %+v (%v)", got, err)
+	}
+	m.Commit(&common.TxnArgs{TxnID: snap.TxnID}, &common.TxnReply{})
+	m.collectVersions()
+	if _, err := asOf("a"); !common.IsSnapshotTooOld(err) {
+		t.Errorf("Expected collection past the finished snapshot, got %v", err)
+	}
+	if s := m.txns.stat(); s.Snapshots != 0 {
+		t.Errorf("Expected no open snapshots, got %+v", s)
+	}
+}
+
+func TestSnapshotGet_NeedsEveryReplica(t *testing.T) {
+	live, w := startWorker(t)
+	m := newTestMaster([]string{live, deadAddr(t)}, 2)
+	// The down replica may be the only one holding the newest finished write.
+	w.Put(&common.PutArgs{Key: "k", Value: "old", Version: 1}, &common.PutReply{})
+
+	snap := &common.TxnReply{}
+	if err := m.Begin(&common.TxnArgs{ReadOnly: true}, snap); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	got := &common.GetReply{}
+	if err := m.TxnGet(&common.TxnGetArgs{TxnID: snap.TxnID, Key: "k"}, got); err == nil {
+		t.Errorf("Expected a snapshot read with a replica down to fail, got %+v", got)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNamespace is the flat keyspace every key lived in before namespaces.
+// Its keys are stored unprefixed and follow the cluster-wide settings.
+const defaultNamespace = "default"
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Namespace is a named bucket of keys with its own replication settings.
+// Zero-valued settings inherit the cluster's.
+type Namespace struct {
+	Name              string `json:"name"`
+	Mode              string `json:"mode"`               // Replication mode ("" = cluster mode)
+	ReplicationFactor int    `json:"replication_factor"` // 0 = cluster RF
+	TTLSeconds        int    `json:"ttl_seconds"`        // Default TTL for keys (0 = no expiry)
+	Quota             int    `json:"quota"`              // Maximum number of live keys (0 = unlimited)
+	KeyCount          int    `json:"key_count"`          // Live keys written through this master
+
+	keys    map[string]time.Time // Key -> expiry (zero = never), for quota accounting
+	pending map[string]int       // Key -> writes in flight, each holding a slot of the quota
+}
+
+// storageKey is the key a namespaced key is stored under on the workers.
+func (ns *Namespace) storageKey(key string) string {
+	if ns.Name == defaultNamespace {
+		return key
+	}
+	return ns.Name + "/" + key
+}
+
+func (ns *Namespace) ttl() time.Duration {
+	return time.Duration(ns.TTLSeconds) * time.Second
+}
+
+// namespaceRegistry holds every namespace created through the admin API.
+type namespaceRegistry struct {
+	mu     sync.RWMutex
+	byName map[string]*Namespace
+}
+
+func newNamespaceRegistry() *namespaceRegistry {
+	return &namespaceRegistry{byName: make(map[string]*Namespace)}
+}
+
+// lookup returns the namespace called name ("" and "default" are the default namespace).
+func (r *namespaceRegistry) lookup(name string) (*Namespace, error) {
+	if name == "" || name == defaultNamespace {
+		return &Namespace{Name: defaultNamespace}, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ns, ok := r.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown namespace %q", name)
+	}
+	return ns, nil
+}
+
+// forStorageKey returns the namespace a stored key belongs to, or nil for the default namespace.
+func (r *namespaceRegistry) forStorageKey(key string) *Namespace {
+	i := strings.IndexByte(key, '/')
+	if i < 0 {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byName[key[:i]]
+}
+
+// checkKey rejects keys that cannot be written to ns: default-namespace keys
+// that would land inside a named namespace's storage prefix.
+func (r *namespaceRegistry) checkKey(ns *Namespace, key string) error {
+	if ns.Name != defaultNamespace {
+		return nil
+	}
+	if owner := r.forStorageKey(key); owner != nil {
+		return fmt.Errorf("key %q collides with namespace %q; write it with namespace %q instead", key, owner.Name, owner.Name)
+	}
+	return nil
+}
+
+// admit reserves a slot of the namespace's quota for a write of key. The
+// caller must record the write once it succeeds, or release the slot if it
+// fails, so concurrent writes of new keys cannot overrun the quota.
+func (r *namespaceRegistry) admit(ns *Namespace, key string) error {
+	if ns.Quota == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := ns.checkQuotaLocked(key); err != nil {
+		return err
+	}
+	ns.pending[key]++
+	return nil
+}
+
+// check rejects a write of key that the namespace's quota has no room for,
+// without reserving a slot.
+func (r *namespaceRegistry) check(ns *Namespace, key string) error {
+	if ns.Quota == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ns.checkQuotaLocked(key)
+}
+
+// release gives back the slot admit reserved for a write that failed.
+func (r *namespaceRegistry) release(ns *Namespace, key string) {
+	if ns.Quota == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns.unreserveLocked(key)
+}
+
+// record notes a successful write of key to ns, turning its reserved slot
+// into a live key.
+func (r *namespaceRegistry) record(ns *Namespace, key string, ttl time.Duration) {
+	if ns.Name == defaultNamespace {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ns.Quota > 0 {
+		ns.unreserveLocked(key)
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	ns.keys[key] = expires
+}
+
+// checkQuotaLocked counts live keys and keys with writes in flight against
+// the quota. Caller holds r.mu.
+func (ns *Namespace) checkQuotaLocked(key string) error {
+	ns.pruneExpired(time.Now())
+	if _, exists := ns.keys[key]; exists || ns.pending[key] > 0 {
+		return nil
+	}
+	used := len(ns.keys)
+	for k := range ns.pending {
+		if _, exists := ns.keys[k]; !exists {
+			used++
+		}
+	}
+	if used >= ns.Quota {
+		return fmt.Errorf("namespace %q quota exceeded: %d keys", ns.Name, ns.Quota)
+	}
+	return nil
+}
+
+func (ns *Namespace) unreserveLocked(key string) {
+	if ns.pending[key] <= 1 {
+		delete(ns.pending, key)
+		return
+	}
+	ns.pending[key]--
+}
+
+func (ns *Namespace) pruneExpired(now time.Time) {
+	for k, exp := range ns.keys {
+		if !exp.IsZero() && now.After(exp) {
+			delete(ns.keys, k)
+		}
+	}
+}
+
+func (r *namespaceRegistry) create(ns *Namespace) error {
+	if !namespaceName.MatchString(ns.Name) || ns.Name == defaultNamespace {
+		return fmt.Errorf("invalid namespace name %q", ns.Name)
+	}
+	if ns.Mode != "" && !validMode(ns.Mode) {
+		return fmt.Errorf("unknown replication mode %q", ns.Mode)
+	}
+	if ns.ReplicationFactor < 0 || ns.TTLSeconds < 0 || ns.Quota < 0 {
+		return fmt.Errorf("replication_factor, ttl_seconds and quota must not be negative")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[ns.Name]; exists {
+		return fmt.Errorf("namespace %q already exists", ns.Name)
+	}
+	ns.keys = make(map[string]time.Time)
+	ns.pending = make(map[string]int)
+	r.byName[ns.Name] = ns
+	return nil
+}
+
+func (r *namespaceRegistry) remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.byName[name]
+	delete(r.byName, name)
+	return ok
+}
+
+func (r *namespaceRegistry) list() []Namespace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	out := make([]Namespace, 0, len(r.byName))
+	for _, ns := range r.byName {
+		ns.pruneExpired(now)
+		cp := *ns
+		cp.KeyCount = len(ns.keys)
+		cp.keys, cp.pending = nil, nil
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// validMode reports whether mode is a replication mode the master understands.
+func validMode(mode string) bool {
+	switch mode {
+	case "sync", "async", "chain", "quorum", "primary":
+		return true
+	}
+	return false
+}
+
+// handleNamespaces is the admin API: GET lists, POST creates, DELETE ?name= removes.
+// Removing a namespace forgets its settings; its keys stay on the workers.
+func (m *Master) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	switch r.Method {
+	case "OPTIONS":
+		return
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.namespaces.list())
+	case "POST":
+		var ns Namespace
+		if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m.mu.RLock()
+		workers := len(m.workers)
+		m.mu.RUnlock()
+		if ns.ReplicationFactor != 0 {
+			if err := validateRF(ns.ReplicationFactor, workers); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+		if err := m.namespaces.create(&ns); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		log.Printf("[Namespace] Created %s (mode: %q, rf: %d, ttl: %ds, quota: %d)",
+			ns.Name, ns.Mode, ns.ReplicationFactor, ns.TTLSeconds, ns.Quota)
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		if !m.namespaces.remove(name) {
+			http.Error(w, fmt.Sprintf("unknown namespace %q", name), 404)
+			return
+		}
+		log.Printf("[Namespace] Removed %s", name)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
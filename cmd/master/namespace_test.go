@@ -0,0 +1,129 @@
+package main
+
+import (
+	"customise-db/common"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestNamespaceRegistry_Create(t *testing.T) {
+	r := newNamespaceRegistry()
+
+	if err := r.create(&Namespace{Name: "orders", Mode: "quorum"}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if err := r.create(&Namespace{Name: "orders"}); err == nil {
+		t.Errorf("Expected duplicate namespace to be rejected")
+	}
+	if err := r.create(&Namespace{Name: "Bad/Name"}); err == nil {
+		t.Errorf("Expected invalid name to be rejected")
+	}
+	if err := r.create(&Namespace{Name: "scratch", Mode: "eventual"}); err == nil {
+		t.Errorf("Expected unknown mode to be rejected")
+	}
+
+	ns, err := r.lookup("orders")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if got := ns.storageKey("42"); got != "orders/42" {
+		t.Errorf("Expected storage key 'orders/42', got %q", got)
+	}
+	if owner := r.forStorageKey("orders/42"); owner == nil || owner.Name != "orders" {
+		t.Errorf("Expected 'orders/42' to belong to namespace orders, got %v", owner)
+	}
+	if err := r.checkKey(&Namespace{Name: defaultNamespace}, "orders/42"); err == nil {
+		t.Errorf("Expected a default-namespace key inside orders/ to be rejected")
+	}
+}
+
+func TestPut_NamespaceSettings(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	if err := m.namespaces.create(&Namespace{Name: "scratch", ReplicationFactor: 1, Quota: 1}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	reply := &common.PutReply{}
+	if err := m.Put(&common.PutArgs{Namespace: "scratch", Key: "a", Value: "1"}, reply); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	holders := 0
+	for _, f := range fakes {
+		if f.has("scratch/a") {
+			holders++
+		}
+	}
+	if holders != 1 {
+		t.Errorf("Expected namespace RF 1 to store 1 copy, got %d", holders)
+	}
+
+	if err := m.Put(&common.PutArgs{Namespace: "scratch", Key: "b", Value: "2"}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected second key to exceed the namespace quota")
+	}
+	if err := m.Put(&common.PutArgs{Namespace: "scratch", Key: "a", Value: "3"}, &common.PutReply{}); err != nil {
+		t.Errorf("Expected overwriting an existing key to stay within quota, got %v", err)
+	}
+
+	getReply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Namespace: "scratch", Key: "a"}, getReply); err != nil || getReply.Value != "3" {
+		t.Errorf("Expected namespaced Get to return '3', got %q (%v)", getReply.Value, err)
+	}
+	if err := m.Get(&common.GetArgs{Namespace: "missing", Key: "a"}, &common.GetReply{}); err == nil {
+		t.Errorf("Expected Get in an unknown namespace to fail")
+	}
+}
+
+func TestNamespaceRegistry_QuotaReservesInFlightWrites(t *testing.T) {
+	r := newNamespaceRegistry()
+	ns := &Namespace{Name: "scratch", Quota: 2}
+	if err := r.create(ns); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	// Concurrent writes of new keys each reserve a slot before any records.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if r.admit(ns, key) == nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("k%d", i))
+	}
+	wg.Wait()
+	if admitted != 2 {
+		t.Fatalf("Expected the quota to admit 2 concurrent new keys, admitted %d", admitted)
+	}
+
+	// A released slot (its write failed) is free again; a recorded one is not.
+	r.mu.Lock()
+	var held []string
+	for k := range ns.pending {
+		held = append(held, k)
+	}
+	r.mu.Unlock()
+	r.release(ns, held[0])
+	r.record(ns, held[1], 0)
+	if err := r.admit(ns, "new"); err != nil {
+		t.Fatalf("Expected a released slot to be reusable, got %v", err)
+	}
+	if err := r.admit(ns, "another"); err == nil {
+		t.Errorf("Expected the quota to be full again")
+	}
+	if err := r.admit(ns, held[1]); err != nil {
+		t.Errorf("Expected rewriting a recorded key to stay within quota, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+)
+
+// Optimistic transactions, in the style of Redis's WATCH/MULTI/EXEC: a client
+// watches keys (reads them and notes their versions), queues writes, and
+// executes them. The writes are applied atomically only if no watched key has
+// changed since it was read. Commit locks the watched keys along with the
+// written ones and compares their versions before it decides. Nothing is
+// locked while the client works, so a conflict costs a retry, not a wait.
+// Exec does it all in one call for clients that read the versions themselves.
+
+// Watch is the RPC entry point for reading a key in a read-write transaction
+// and watching it at the version read.
+func (m *Master) Watch(args *common.TxnGetArgs, reply *common.GetReply) error {
+	return m.watch(context.Background(), args, reply)
+}
+
+// watch reads a key at the mode's consistency level and watches the version
+// read. A stale read only makes the commit fail: the check is made against
+// every replica.
+func (m *Master) watch(ctx context.Context, args *common.TxnGetArgs, reply *common.GetReply) error {
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := m.get(ctx, &common.GetArgs{Key: args.Key, Namespace: args.Namespace}, reply); err != nil {
+		return err
+	}
+	version := reply.Version
+	if !reply.Found {
+		version = 0
+	}
+	return m.txns.watchKey(args.TxnID, ns.storageKey(args.Key), version)
+}
+
+// watchKey records the version a transaction read a key at. The first read
+// of a key is the one that counts.
+func (t *txnManager) watchKey(id, key string, version int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx, err := t.getLocked(id)
+	if err != nil {
+		return err
+	}
+	if tx.snapshot != 0 {
+		return fmt.Errorf("transaction %s is read-only", tx.id)
+	}
+	if _, ok := tx.watch[key]; !ok {
+		tx.watch[key] = version
+	}
+	return nil
+}
+
+// Exec is the RPC entry point for an optimistic transaction in one call.
+func (m *Master) Exec(args *common.ExecArgs, reply *common.TxnReply) error {
+	return m.exec(context.Background(), args, reply)
+}
+
+// exec applies a batch of writes if every watched key is still at the version
+// given, as one transaction.
+func (m *Master) exec(ctx context.Context, args *common.ExecArgs, reply *common.TxnReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
+	}
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	id, _ := m.txns.begin(false)
+	for key, version := range args.Watch {
+		if err := m.txns.watchKey(id, ns.storageKey(key), version); err != nil {
+			m.Abort(&common.TxnArgs{TxnID: id}, &common.TxnReply{})
+			return err
+		}
+	}
+	for _, w := range args.Writes {
+		put := &common.TxnPutArgs{TxnID: id, Key: w.Key, Value: w.Value, Namespace: args.Namespace}
+		if err := m.TxnPut(put, &common.TxnReply{}); err != nil {
+			m.Abort(&common.TxnArgs{TxnID: id}, &common.TxnReply{})
+			return err
+		}
+	}
+	return m.commit(ctx, id, reply)
+}
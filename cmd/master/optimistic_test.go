@@ -0,0 +1,70 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+)
+
+func TestExec_AppliesOnlyIfWatchedKeysUnchanged(t *testing.T) {
+	for _, mode := range []string{"chain", "quorum"} {
+		t.Run(mode, func(t *testing.T) {
+			var addrs []string
+			for i := 0; i < 3; i++ {
+				addr, _ := startWorker(t)
+				addrs = append(addrs, addr)
+			}
+			m := newTestMaster(addrs, 3)
+			m.mode = mode
+			m.Put(&common.PutArgs{Key: "balance", Value: "100"}, &common.PutReply{})
+			read := &common.GetReply{}
+			if err := m.Get(&common.GetArgs{Key: "balance"}, read); err != nil || read.Version == 0 {
+				t.Fatalf("Get failed: %+v (%v)", read, err)
+			}
+
+			exec := &common.ExecArgs{
+				Watch:  map[string]int64{"balance": read.Version, "absent": 0},
+				Writes: []common.ExecWrite{{Key: "balance", Value: "70"}, {Key: "other", Value: "30"}},
+			}
+			if err := m.Exec(exec, &common.TxnReply{}); err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+			// The same batch again is conditional on a version that is gone.
+			exec.Writes = []common.ExecWrite{{Key: "balance", Value: "40"}}
+			if err := m.Exec(exec, &common.TxnReply{}); !common.IsTxnConflict(err) {
+				t.Errorf("Expected a stale watch to fail the batch, got %v", err)
+			}
+			got := &common.GetReply{}
+			if m.Get(&common.GetArgs{Key: "balance"}, got); got.Value != "70" {
+				t.Errorf("Expected balance 70 after one applied batch, got %q", got.Value)
+			}
+		})
+	}
+}
+
+func TestWatch_CommitFailsIfKeyChangesMeanwhile(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, _ := startWorker(t)
+		addrs = append(addrs, addr)
+	}
+	m := newTestMaster(addrs, 2)
+	m.mode = "quorum"
+	m.Put(&common.PutArgs{Key: "counter", Value: "1"}, &common.PutReply{})
+
+	begin := &common.TxnReply{}
+	m.Begin(&common.TxnArgs{}, begin)
+	read := &common.GetReply{}
+	if err := m.Watch(&common.TxnGetArgs{TxnID: begin.TxnID, Key: "counter"}, read); err != nil || read.Value != "1" {
+		t.Fatalf("Watch failed: %+v (%v)", read, err)
+	}
+	m.TxnPut(&common.TxnPutArgs{TxnID: begin.TxnID, Key: "counter", Value: "2"}, &common.TxnReply{})
+	m.Put(&common.PutArgs{Key: "counter", Value: "5"}, &common.PutReply{}) // Another client gets there first
+
+	if err := m.Commit(&common.TxnArgs{TxnID: begin.TxnID}, &common.TxnReply{}); !common.IsTxnConflict(err) {
+		t.Fatalf("Expected the commit to fail on the watched key, got %v", err)
+	}
+	got := &common.GetReply{}
+	if m.Get(&common.GetArgs{Key: "counter"}, got); got.Value != "5" {
+		t.Errorf("Expected the other client's write to stand, got %q", got.Value)
+	}
+}
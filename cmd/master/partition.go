@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Partitioner decides which workers store a key.
+type Partitioner interface {
+	// Add puts nodes into the pool of workers keys are placed on.
+	Add(nodes ...string)
+	// GetN returns the n distinct nodes responsible for key, preferred first.
+	GetN(key string, n int) []string
+	// Distribution reports how the key space is spread across the nodes.
+	Distribution() PlacementStat
+}
+
+// Partitioning algorithms selectable with -partitioner.
+const (
+	partitionRing       = "ring"       // Consistent hashing with virtual nodes
+	partitionRendezvous = "rendezvous" // Highest random weight
+	partitionJump       = "jump"       // Jump consistent hashing
+	partitionMaglev     = "maglev"     // Maglev lookup table
+	partitionRange      = "range"      // Table of key ranges; see ranges.go
+)
+
+// HashFunc hashes a key or node name onto 32 bits.
+type HashFunc func([]byte) uint32
+
+// hashFuncs are the hash functions selectable with -hash.
+var hashFuncs = map[string]HashFunc{
+	"crc32":  crc32.ChecksumIEEE,
+	"fnv":    fnv32a,
+	"xxhash": xxhash32,
+}
+
+// newPartitioner builds the named partitioner over the named hash function.
+// vnodes is the number of virtual nodes per worker on the ring.
+func newPartitioner(algorithm, hashName string, vnodes int) (Partitioner, error) {
+	hash, ok := hashFuncs[hashName]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %q: want crc32, fnv or xxhash", hashName)
+	}
+	switch algorithm {
+	case partitionRing:
+		c := NewConsistentHash(vnodes)
+		c.hash = hash
+		return c, nil
+	case partitionRendezvous:
+		return &Rendezvous{hash: hash}, nil
+	case partitionJump:
+		return &JumpHash{hash: hash}, nil
+	case partitionMaglev:
+		return NewMaglev(maglevTableSize, hash), nil
+	case partitionRange:
+		return NewRangePartitioner(), nil
+	default:
+		return nil, fmt.Errorf("unknown partitioner %q: want ring, rendezvous, jump, maglev or range", algorithm)
+	}
+}
+
+func fnv32a(b []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(b)
+	return h.Sum32()
+}
+
+// xxHash32 primes.
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// xxhash32 is xxHash32 with seed 0.
+func xxhash32(b []byte) uint32 {
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		var seed uint32
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(b) >= 16 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint32(b[12:]))
+			b = b[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = xxPrime5
+	}
+	h += uint32(n)
+	for len(b) >= 4 {
+		h += binary.LittleEndian.Uint32(b) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+		b = b[4:]
+	}
+	for _, c := range b {
+		h += uint32(c) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(acc, input uint32) uint32 {
+	return bits.RotateLeft32(acc+input*xxPrime2, 13) * xxPrime1
+}
+
+// mix64 spreads a 64-bit value's entropy over all its bits (splitmix64's finaliser).
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// evenShares reports every node as owning the same share of the key space,
+// as rendezvous and jump hashing do in expectation.
+func evenShares(balance float64, nodes []string) PlacementStat {
+	st := PlacementStat{Balance: balance, Nodes: []NodePlacement{}}
+	for _, node := range nodes {
+		st.Nodes = append(st.Nodes, NodePlacement{Address: node, Share: 1 / float64(len(nodes)), Weight: 1})
+	}
+	sort.Slice(st.Nodes, func(i, j int) bool { return st.Nodes[i].Address < st.Nodes[j].Address })
+	return st
+}
+
+// Rendezvous places a key on the n nodes that score highest for it. Adding a
+// node only moves the keys it now outscores everyone for.
+type Rendezvous struct {
+	mu    sync.RWMutex
+	hash  HashFunc
+	nodes []string
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes = append(r.nodes, nodes...)
+}
+
+func (r *Rendezvous) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k := uint64(r.hash([]byte(key))) << 32
+	type scored struct {
+		node  string
+		score uint64
+	}
+	all := make([]scored, len(r.nodes))
+	for i, node := range r.nodes {
+		all[i] = scored{node, mix64(k | uint64(r.hash([]byte(node))))}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+	if n > len(all) {
+		n = len(all)
+	}
+	out := make([]string, n)
+	for i := range out {
+		out[i] = all[i].node
+	}
+	return out
+}
+
+func (r *Rendezvous) Distribution() PlacementStat {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return evenShares(0, r.nodes)
+}
+
+// JumpHash places a key with Lamping and Veach's jump consistent hash, which
+// needs no ring and moves only 1/(N+1) of the keys when a node is added.
+// Nodes are numbered in the order they were added; further replicas are the
+// nodes numbered after the first.
+type JumpHash struct {
+	mu    sync.RWMutex
+	hash  HashFunc
+	nodes []string
+}
+
+func (j *JumpHash) Add(nodes ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.nodes = append(j.nodes, nodes...)
+}
+
+func (j *JumpHash) GetN(key string, n int) []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.nodes) == 0 {
+		return nil
+	}
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	first := jump(mix64(uint64(j.hash([]byte(key)))), len(j.nodes))
+	out := make([]string, n)
+	for i := range out {
+		out[i] = j.nodes[(first+i)%len(j.nodes)]
+	}
+	return out
+}
+
+func (j *JumpHash) Distribution() PlacementStat {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return evenShares(0, j.nodes)
+}
+
+// jump maps key to one of buckets buckets.
+func jump(key uint64, buckets int) int {
+	b, next := int64(-1), int64(0)
+	for next < int64(buckets) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// maglevTableSize is the Maglev lookup table size: a prime well above the
+// number of workers, so every worker gets close to its even share of entries.
+const maglevTableSize = 65537
+
+// Maglev places keys through a lookup table that every node fills in its own
+// pseudo-random order, taking turns, so each ends up with an almost equal
+// share of entries and adding a node disturbs few of the others'.
+type Maglev struct {
+	mu    sync.RWMutex
+	hash  HashFunc
+	size  int
+	nodes []string
+	table []int // Entry -> index into nodes
+}
+
+func NewMaglev(size int, hash HashFunc) *Maglev {
+	return &Maglev{hash: hash, size: size}
+}
+
+func (m *Maglev) Add(nodes ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = append(m.nodes, nodes...)
+	// Fill in a fixed node order so the table does not depend on the order of Adds.
+	sort.Strings(m.nodes)
+	m.populate()
+}
+
+// populate rebuilds the lookup table. Caller holds m.mu.
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		return
+	}
+	offsets := make([]int, len(m.nodes))
+	skips := make([]int, len(m.nodes))
+	for i, node := range m.nodes {
+		offsets[i] = int(m.hash([]byte(node)) % uint32(m.size))
+		skips[i] = int(m.hash([]byte("skip/"+node))%uint32(m.size-1)) + 1
+	}
+	m.table = make([]int, m.size)
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	next := make([]int, len(m.nodes))
+	for filled := 0; filled < m.size; {
+		for i := range m.nodes {
+			c := (offsets[i] + next[i]*skips[i]) % m.size
+			for m.table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			m.table[c] = i
+			next[i]++
+			if filled++; filled == m.size {
+				break
+			}
+		}
+	}
+}
+
+func (m *Maglev) GetN(key string, n int) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.nodes) == 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	var out []string
+	for e := int(m.hash([]byte(key)) % uint32(m.size)); len(out) < n; e = (e + 1) % m.size {
+		if node := m.nodes[m.table[e]]; !contains(out, node) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func (m *Maglev) Distribution() PlacementStat {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := PlacementStat{Nodes: []NodePlacement{}}
+	entries := make([]int, len(m.nodes))
+	for _, i := range m.table {
+		entries[i]++
+	}
+	for i, node := range m.nodes {
+		st.Nodes = append(st.Nodes, NodePlacement{Address: node, Share: float64(entries[i]) / float64(m.size), Weight: 1})
+	}
+	return st
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestXXHash32(t *testing.T) {
+	// Reference values of XXH32 with seed 0.
+	tests := map[string]uint32{
+		"":    0x02cc5d05,
+		"a":   0x550d7456,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	}
+	for in, want := range tests {
+		if got := xxhash32([]byte(in)); got != want {
+			t.Errorf("xxhash32(%q) = %#08x, want %#08x", in, got, want)
+		}
+	}
+}
+
+func TestNewPartitioner(t *testing.T) {
+	if _, err := newPartitioner("consistent", "crc32", 20); err == nil {
+		t.Errorf("Expected an unknown partitioner to be rejected")
+	}
+	if _, err := newPartitioner(partitionRing, "md5", 20); err == nil {
+		t.Errorf("Expected an unknown hash function to be rejected")
+	}
+}
+
+// TestPartitioners_BalanceAndMovement compares every partitioner and hash
+// function on how evenly they spread keys and how many keys move when a node
+// is added. Run with -v to see the comparison.
+func TestPartitioners_BalanceAndMovement(t *testing.T) {
+	const nodes, keys = 10, 20000
+	// The most load any node may carry, relative to the average.
+	maxBalance := map[string]float64{
+		partitionRing:       2.0, // 20 vnodes per node is only roughly even
+		partitionRendezvous: 1.15,
+		partitionJump:       1.15,
+		partitionMaglev:     1.15,
+	}
+	for _, algorithm := range []string{partitionRing, partitionRendezvous, partitionJump, partitionMaglev} {
+		for _, hashName := range []string{"crc32", "fnv", "xxhash"} {
+			p, err := newPartitioner(algorithm, hashName, 20)
+			if err != nil {
+				t.Fatalf("newPartitioner(%s, %s): %v", algorithm, hashName, err)
+			}
+			for i := 0; i < nodes; i++ {
+				p.Add(fmt.Sprintf("10.0.0.%d:9000", i))
+			}
+			before := make([]string, keys)
+			load := make(map[string]int)
+			for i := range before {
+				replicas := p.GetN("key"+strconv.Itoa(i), 3)
+				if len(replicas) != 3 || replicas[0] == replicas[1] || replicas[1] == replicas[2] || replicas[0] == replicas[2] {
+					t.Fatalf("%s/%s: expected 3 distinct replicas, got %v", algorithm, hashName, replicas)
+				}
+				before[i] = replicas[0]
+				load[replicas[0]]++
+			}
+			most := 0
+			for _, n := range load {
+				if n > most {
+					most = n
+				}
+			}
+			balance := float64(most) / (float64(keys) / nodes)
+
+			added := "10.0.0.99:9000"
+			p.Add(added)
+			moved, movedElsewhere := 0, 0
+			for i, was := range before {
+				now := p.GetN("key"+strconv.Itoa(i), 1)[0]
+				if now != was {
+					moved++
+					if now != added {
+						movedElsewhere++
+					}
+				}
+			}
+			movement := float64(moved) / keys
+			ideal := 1.0 / (nodes + 1)
+
+			t.Logf("%-10s %-6s max/avg %.3f  moved %.3f (ideal %.3f, %d not to the new node)",
+				algorithm, hashName, balance, movement, ideal, movedElsewhere)
+			if balance > maxBalance[algorithm] {
+				t.Errorf("%s/%s: busiest node has %.2fx the average load, want at most %.2fx", algorithm, hashName, balance, maxBalance[algorithm])
+			}
+			if movement > 2*ideal {
+				t.Errorf("%s/%s: %.3f of keys moved on adding a node, want near %.3f", algorithm, hashName, movement, ideal)
+			}
+			if algorithm != partitionMaglev && movedElsewhere > 0 {
+				t.Errorf("%s/%s: %d keys moved between old nodes", algorithm, hashName, movedElsewhere)
+			}
+		}
+	}
+}
+
+func BenchmarkPartitioners_GetN(b *testing.B) {
+	for _, algorithm := range []string{partitionRing, partitionRendezvous, partitionJump, partitionMaglev} {
+		for _, hashName := range []string{"crc32", "fnv", "xxhash"} {
+			p, _ := newPartitioner(algorithm, hashName, 20)
+			for i := 0; i < 10; i++ {
+				p.Add(fmt.Sprintf("10.0.0.%d:9000", i))
+			}
+			b.Run(algorithm+"/"+hashName, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.GetN("key"+strconv.Itoa(i), 3)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "fmt"
+
+// QuorumConfig holds the cluster-wide default read and write quorum sizes for
+// quorum mode. Zero means a strict majority of the key's replicas.
+type QuorumConfig struct {
+	R int
+	W int
+}
+
+// size resolves the quorum to use for one request over n replicas: the
+// per-request override if given, else the cluster default, else a majority.
+// A default larger than n (e.g. after the cluster shrank) is capped at n, but an
+// explicit override that cannot be met is an error.
+func (q QuorumConfig) size(override, def, n int) (int, error) {
+	if override < 0 {
+		return 0, fmt.Errorf("quorum size must not be negative, got %d", override)
+	}
+	if override > 0 {
+		if override > n {
+			return 0, fmt.Errorf("quorum size %d exceeds the %d replicas of this key", override, n)
+		}
+		return override, nil
+	}
+	if def > 0 {
+		if def > n {
+			return n, nil
+		}
+		return def, nil
+	}
+	return n/2 + 1, nil
+}
+
+// QuorumStat describes the effective quorum configuration on /status.
+type QuorumStat struct {
+	N      int  `json:"n"`      // Replicas per key
+	R      int  `json:"r"`      // Effective read quorum
+	W      int  `json:"w"`      // Effective write quorum
+	Strong bool `json:"strong"` // R+W>N: every read quorum overlaps every write quorum
+}
+
+func (q QuorumConfig) stat(n int) QuorumStat {
+	r, _ := q.size(0, q.R, n)
+	w, _ := q.size(0, q.W, n)
+	return QuorumStat{N: n, R: r, W: w, Strong: r+w > n}
+}
+
+// validate checks the defaults against the replication factor.
+func (q QuorumConfig) validate(rf int) error {
+	if q.R < 0 || q.W < 0 {
+		return fmt.Errorf("quorum sizes must not be negative (r=%d, w=%d)", q.R, q.W)
+	}
+	if q.R > rf || q.W > rf {
+		return fmt.Errorf("quorum sizes (r=%d, w=%d) exceed replication factor %d", q.R, q.W, rf)
+	}
+	return nil
+}
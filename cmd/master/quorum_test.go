@@ -0,0 +1,85 @@
+package main
+
+import (
+	"customise-db/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQuorumConfig_Size(t *testing.T) {
+	testCases := []struct {
+		override, def, n int
+		expected         int
+		wantErr          bool
+	}{
+		{0, 0, 3, 2, false}, // Majority by default
+		{0, 0, 5, 3, false},
+		{0, 1, 3, 1, false}, // Cluster default
+		{0, 5, 3, 3, false}, // Default capped at N
+		{3, 1, 3, 3, false}, // Per-request override wins
+		{4, 0, 3, 0, true},  // Override that cannot be met
+		{-1, 0, 3, 0, true},
+	}
+
+	for _, tc := range testCases {
+		got, err := QuorumConfig{}.size(tc.override, tc.def, tc.n)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("size(%d, %d, %d) error = %v, wantErr %v", tc.override, tc.def, tc.n, err, tc.wantErr)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("size(%d, %d, %d) = %d, expected %d", tc.override, tc.def, tc.n, got, tc.expected)
+		}
+	}
+}
+
+func TestQuorumConfig_Strong(t *testing.T) {
+	if st := (QuorumConfig{}).stat(3); !st.Strong || st.R != 2 || st.W != 2 {
+		t.Errorf("Expected majority quorums to be strong, got %+v", st)
+	}
+	if st := (QuorumConfig{R: 1, W: 1}).stat(3); st.Strong {
+		t.Errorf("Expected R=1 W=1 N=3 to be weak, got %+v", st)
+	}
+}
+
+func TestGetQuorum_ReturnsNewestVersion(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "quorum"
+
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "old"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// A newer write that only reached one replica.
+	newest := m.clock.next()
+	fakes[addrs[0]].Put(&common.PutArgs{Key: "k", Value: "new", Version: newest}, &common.PutReply{})
+
+	reply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k", R: 3}, reply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if reply.Value != "new" || reply.Version != newest {
+		t.Errorf("Expected newest value 'new' (v%d), got %q (v%d)", newest, reply.Value, reply.Version)
+	}
+}
+
+func TestHandleConfig_RejectsTheWholeRequest(t *testing.T) {
+	m := newTestMaster([]string{"w1", "w2", "w3"}, 3)
+	body := `{"read_quorum": 3, "hedge": "10ms", "mode": "nonsense"}`
+	rec := httptest.NewRecorder()
+	m.handleConfig(rec, httptest.NewRequest("POST", "/config", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown mode, got %d", rec.Code)
+	}
+	if m.quorum.R != 0 || m.hedge.stat().Policy != "off" {
+		t.Errorf("Expected nothing applied, got R=%d hedge=%s", m.quorum.R, m.hedge.stat().Policy)
+	}
+}
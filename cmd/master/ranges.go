@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Range partitioning: instead of hashing, the key space is cut into
+// contiguous ranges of keys, each stored by a group of workers. Neighbouring
+// keys stay together, which keeps prefixes local and their order intact. The
+// master splits a range when a worker holding it grows too many keys or too
+// much traffic, merges cold neighbours, and can move a range to another
+// worker. Because the table is not derived from the keys, it is saved after
+// every change and reloaded when the master restarts.
+
+// KeyRange is the keys from Start up to (not including) End, and the workers
+// that store them, preferred first. The last range has End "" (unbounded).
+type KeyRange struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Group []string `json:"group"`
+}
+
+// RangePartitioner places keys by looking up their range in a table.
+type RangePartitioner struct {
+	mu     sync.RWMutex
+	nodes  []string
+	ranges []KeyRange // Sorted and contiguous; the first starts at ""
+	path   string     // Where the table is saved ("" = memory only)
+
+	// Thresholds for automatic splits and merges (see balanceRanges).
+	splitKeys int // Split a range on a worker holding more keys than this (0 = never)
+	splitRate int // Split a range on a worker serving more requests/s than this (0 = never)
+	mergeKeys int // Merge adjacent ranges holding fewer keys than this between them (0 = never)
+
+	keys   map[string]int // Range start -> keys in it, as of the last balancing pass
+	splits int
+	merges int
+	moves  int
+}
+
+func NewRangePartitioner() *RangePartitioner {
+	return &RangePartitioner{keys: make(map[string]int)}
+}
+
+// SetThresholds sets when ranges are split and merged automatically.
+func (p *RangePartitioner) SetThresholds(splitKeys, splitRate, mergeKeys int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.splitKeys, p.splitRate, p.mergeKeys = splitKeys, splitRate, mergeKeys
+}
+
+// Load reads the table saved at path, if there is one, and saves there from
+// now on.
+func (p *RangePartitioner) Load(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.path = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ranges []KeyRange
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	for i, r := range ranges {
+		if (i == 0 && r.Start != "") || (i > 0 && r.Start != ranges[i-1].End) || (i == len(ranges)-1 && r.End != "") || len(r.Group) == 0 {
+			return fmt.Errorf("reading %s: ranges do not cover the key space", path)
+		}
+	}
+	p.ranges = ranges
+	for _, r := range ranges {
+		for _, node := range r.Group {
+			if !contains(p.nodes, node) {
+				p.nodes = append(p.nodes, node)
+			}
+		}
+	}
+	return nil
+}
+
+// saveLocked writes the table to p.path. Caller holds p.mu.
+func (p *RangePartitioner) saveLocked() {
+	if p.path == "" {
+		return
+	}
+	data, _ := json.MarshalIndent(p.ranges, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		log.Printf("[Ranges] Could not save the range table: %v", err)
+		return
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[Ranges] Could not save the range table: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		log.Printf("[Ranges] Could not save the range table: %v", err)
+	}
+}
+
+// Add makes nodes available to ranges. A new node joins the end of every
+// group, so it takes no keys until a range is split or moved onto it.
+func (p *RangePartitioner) Add(nodes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, node := range nodes {
+		if contains(p.nodes, node) {
+			continue
+		}
+		p.nodes = append(p.nodes, node)
+		for i := range p.ranges {
+			p.ranges[i].Group = append(p.ranges[i].Group, node)
+		}
+	}
+	if len(p.ranges) == 0 && len(p.nodes) > 0 {
+		group := make([]string, len(p.nodes))
+		copy(group, p.nodes)
+		p.ranges = []KeyRange{{Group: group}}
+	}
+	p.saveLocked()
+}
+
+// findLocked returns the index of the range holding key. Caller holds p.mu.
+func (p *RangePartitioner) findLocked(key string) int {
+	return sort.Search(len(p.ranges), func(i int) bool { return p.ranges[i].End == "" || key < p.ranges[i].End })
+}
+
+func (p *RangePartitioner) GetN(key string, n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.ranges) == 0 {
+		return nil
+	}
+	group := p.ranges[p.findLocked(key)].Group
+	if n > len(group) {
+		n = len(group)
+	}
+	out := make([]string, n)
+	copy(out, group)
+	return out
+}
+
+// Split cuts the range holding at into two, the second starting at at. The
+// second range is stored by group, or by the same workers if group is nil.
+func (p *RangePartitioner) Split(at string, group []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ranges) == 0 {
+		return fmt.Errorf("no ranges")
+	}
+	i := p.findLocked(at)
+	r := p.ranges[i]
+	if at == r.Start {
+		return fmt.Errorf("a range already starts at %q", at)
+	}
+	if group == nil {
+		group = r.Group
+	}
+	upper := KeyRange{Start: at, End: r.End, Group: append([]string(nil), group...)}
+	p.ranges[i].End = at
+	p.ranges = append(p.ranges[:i+1], append([]KeyRange{upper}, p.ranges[i+1:]...)...)
+	p.splits++
+	p.saveLocked()
+	return nil
+}
+
+// Merge joins the range holding key with the range after it. The merged
+// range is stored by the first range's workers.
+func (p *RangePartitioner) Merge(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ranges) == 0 {
+		return fmt.Errorf("no ranges")
+	}
+	i := p.findLocked(key)
+	if i == len(p.ranges)-1 {
+		return fmt.Errorf("the range holding %q is the last one", key)
+	}
+	p.ranges[i].End = p.ranges[i+1].End
+	delete(p.keys, p.ranges[i+1].Start)
+	p.ranges = append(p.ranges[:i+1], p.ranges[i+2:]...)
+	p.merges++
+	p.saveLocked()
+	return nil
+}
+
+// Move makes to the preferred worker of the range holding key; the rest of
+// its group keeps its order.
+func (p *RangePartitioner) Move(key, to string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !contains(p.nodes, to) {
+		return fmt.Errorf("unknown worker %q", to)
+	}
+	if len(p.ranges) == 0 {
+		return fmt.Errorf("no ranges")
+	}
+	r := &p.ranges[p.findLocked(key)]
+	if r.Group[0] == to {
+		return nil
+	}
+	group := []string{to}
+	for _, node := range r.Group {
+		if node != to {
+			group = append(group, node)
+		}
+	}
+	r.Group = group
+	p.moves++
+	p.saveLocked()
+	return nil
+}
+
+// Ranges returns a copy of the table.
+func (p *RangePartitioner) Ranges() []KeyRange {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]KeyRange, len(p.ranges))
+	for i, r := range p.ranges {
+		out[i] = KeyRange{Start: r.Start, End: r.End, Group: append([]string(nil), r.Group...)}
+	}
+	return out
+}
+
+// Distribution reports the share of ranges each worker is preferred for.
+func (p *RangePartitioner) Distribution() PlacementStat {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st := PlacementStat{Nodes: []NodePlacement{}}
+	led := make(map[string]int)
+	for _, r := range p.ranges {
+		led[r.Group[0]]++
+	}
+	for _, node := range p.nodes {
+		np := NodePlacement{Address: node, Weight: 1}
+		if len(p.ranges) > 0 {
+			np.Share = float64(led[node]) / float64(len(p.ranges))
+		}
+		st.Nodes = append(st.Nodes, np)
+	}
+	sort.Slice(st.Nodes, func(i, j int) bool { return st.Nodes[i].Address < st.Nodes[j].Address })
+	return st
+}
+
+// RangeView is the JSON view of one range.
+type RangeView struct {
+	KeyRange
+	Keys int `json:"keys"` // Keys in it, as of the last balancing pass
+}
+
+// RangeStat reports the range table on /status.
+type RangeStat struct {
+	SplitKeys int         `json:"split_keys"`
+	SplitRate int         `json:"split_rate"`
+	MergeKeys int         `json:"merge_keys"`
+	Splits    int         `json:"splits"`
+	Merges    int         `json:"merges"`
+	Moves     int         `json:"moves"`
+	Ranges    []RangeView `json:"ranges"`
+}
+
+func (p *RangePartitioner) stat() *RangeStat {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st := &RangeStat{
+		SplitKeys: p.splitKeys, SplitRate: p.splitRate, MergeKeys: p.mergeKeys,
+		Splits: p.splits, Merges: p.merges, Moves: p.moves,
+		Ranges: make([]RangeView, 0, len(p.ranges)),
+	}
+	for _, r := range p.ranges {
+		st.Ranges = append(st.Ranges, RangeView{
+			KeyRange: KeyRange{Start: r.Start, End: r.End, Group: append([]string(nil), r.Group...)},
+			Keys:     p.keys[r.Start],
+		})
+	}
+	return st
+}
+
+// balanceRanges is one pass of automatic range management. A worker over a
+// split threshold has its biggest range split at the median key, and the
+// upper half goes to the workers holding the fewest keys. Then adjacent cold
+// ranges (too few keys between them, and neither preferred worker busy) are
+// merged. Either starts a rebalance that moves the keys. Nothing happens
+// unless every worker answered, as the counts would be off.
+//
+// Splits and merges keep clear of each other so a range does not flap
+// between them: a range is split only if its upper half leaves the hot
+// worker and each half keeps at least the merge threshold of keys, and
+// ranges are merged only while they hold under half the split threshold.
+func (m *Master) balanceRanges(p *RangePartitioner) {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	rf := m.rf
+	m.mu.RUnlock()
+
+	stats := make(map[string]*common.StatsReply)
+	for _, w := range workers {
+		s := &common.StatsReply{}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, s)
+		cancel()
+		if err != nil {
+			return
+		}
+		stats[w] = s
+	}
+	var reasons []string
+	for _, r := range m.splitHotRanges(p, stats, rf) {
+		reasons = append(reasons, "split at "+r)
+	}
+	for _, r := range mergeColdRanges(p, stats) {
+		reasons = append(reasons, "merged at "+r)
+	}
+	if len(reasons) > 0 {
+		m.triggerRebalance("ranges: " + strings.Join(reasons, ", "))
+	}
+}
+
+// countRangeKeys records how many distinct keys each range holds.
+func countRangeKeys(p *RangePartitioner, stats map[string]*common.StatsReply) {
+	seen := make(map[string]bool)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = make(map[string]int)
+	for _, s := range stats {
+		for _, k := range s.Keys {
+			if !seen[k] && len(p.ranges) > 0 {
+				seen[k] = true
+				p.keys[p.ranges[p.findLocked(k)].Start]++
+			}
+		}
+	}
+}
+
+// splitHotRanges splits one range per worker over a threshold and returns
+// where it split.
+func (m *Master) splitHotRanges(p *RangePartitioner, stats map[string]*common.StatsReply, rf int) []string {
+	countRangeKeys(p, stats)
+	p.mu.RLock()
+	splitKeys, splitRate, mergeKeys := p.splitKeys, p.splitRate, p.mergeKeys
+	p.mu.RUnlock()
+
+	// Workers holding the fewest keys first: where split-off ranges go.
+	byKeys := make([]string, 0, len(stats))
+	for w := range stats {
+		byKeys = append(byKeys, w)
+	}
+	sort.Slice(byKeys, func(i, j int) bool {
+		if stats[byKeys[i]].KeyCount != stats[byKeys[j]].KeyCount {
+			return stats[byKeys[i]].KeyCount < stats[byKeys[j]].KeyCount
+		}
+		return byKeys[i] < byKeys[j]
+	})
+
+	var splits []string
+	for w, s := range stats {
+		hot := (splitKeys > 0 && s.KeyCount > splitKeys) || (splitRate > 0 && s.RequestRate > splitRate)
+		if !hot {
+			continue
+		}
+		// The range with the most of this worker's keys, and those keys in order.
+		held := make(map[int][]string)
+		p.mu.RLock()
+		for _, k := range s.Keys {
+			i := p.findLocked(k)
+			if n := len(p.ranges[i].Group); contains(p.ranges[i].Group[:min(rf, n)], w) {
+				held[i] = append(held[i], k)
+			}
+		}
+		p.mu.RUnlock()
+		biggest := -1
+		for i, keys := range held {
+			if biggest < 0 || len(keys) > len(held[biggest]) {
+				biggest = i
+			}
+		}
+		if biggest < 0 || len(held[biggest]) < max(2, 2*mergeKeys) {
+			continue // Too small: the halves would be merged straight back
+		}
+		// The upper half goes to the coolest workers, the hot one last. If it
+		// would still be among the rf holding it, splitting moves no load.
+		group := make([]string, 0, len(byKeys))
+		for _, other := range byKeys {
+			if other != w {
+				group = append(group, other)
+			}
+		}
+		group = append(group, w)
+		if contains(group[:min(rf, len(group))], w) {
+			continue
+		}
+		keys := held[biggest]
+		sort.Strings(keys)
+		at := keys[len(keys)/2]
+		if err := p.Split(at, group); err != nil {
+			continue // Another worker's split already cut here
+		}
+		log.Printf("[Ranges] Worker %s is hot (%d keys, %d req/s): split at %q, upper half to %s", w, s.KeyCount, s.RequestRate, at, group[0])
+		splits = append(splits, at)
+	}
+	if len(splits) > 0 {
+		countRangeKeys(p, stats)
+	}
+	return splits
+}
+
+// mergeColdRanges merges adjacent ranges that hold fewer than the merge
+// threshold of keys between them (and under half the split threshold),
+// provided neither's preferred worker is anywhere near the split rate. It
+// returns where the merged ranges met.
+func mergeColdRanges(p *RangePartitioner, stats map[string]*common.StatsReply) []string {
+	p.mu.RLock()
+	mergeKeys, splitKeys, splitRate := p.mergeKeys, p.splitKeys, p.splitRate
+	ranges := make([]KeyRange, len(p.ranges))
+	copy(ranges, p.ranges)
+	counts := make(map[string]int, len(p.keys))
+	for k, v := range p.keys {
+		counts[k] = v
+	}
+	p.mu.RUnlock()
+	if mergeKeys == 0 {
+		return nil
+	}
+	if splitKeys > 0 {
+		mergeKeys = min(mergeKeys, splitKeys/2)
+	}
+	busy := func(r KeyRange) bool {
+		s := stats[r.Group[0]]
+		return splitRate > 0 && s != nil && s.RequestRate > splitRate/2
+	}
+
+	var merged []string
+	for i := 0; i+1 < len(ranges); i++ {
+		a, b := ranges[i], ranges[i+1]
+		if counts[a.Start]+counts[b.Start] >= mergeKeys || busy(a) || busy(b) {
+			continue
+		}
+		if err := p.Merge(a.Start); err != nil {
+			continue
+		}
+		log.Printf("[Ranges] Merged cold ranges at %q (%d keys)", b.Start, counts[a.Start]+counts[b.Start])
+		merged = append(merged, b.Start)
+		counts[a.Start] += counts[b.Start]
+		ranges[i+1] = KeyRange{Start: a.Start, End: b.End, Group: a.Group}
+		i++ // Leave the merged range alone until the next pass
+	}
+	return merged
+}
+
+// RangeRequest changes the range table through /ranges. Key names the range
+// holding it; "" is a key like any other, held by the first range.
+type RangeRequest struct {
+	Split *string `json:"split"` // Start a new range at this key
+	Merge *string `json:"merge"` // Merge the range holding this key with the next one
+	Move  *string `json:"move"`  // Move the range holding this key...
+	To    string  `json:"to"`    // ...to this worker
+}
+
+// handleRanges is the admin API: GET returns the range table, POST splits,
+// merges or moves a range and starts a rebalance.
+func (m *Master) handleRanges(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	p, ok := m.ring.(*RangePartitioner)
+	switch {
+	case r.Method == "OPTIONS":
+		return
+	case !ok:
+		http.Error(w, fmt.Sprintf("the %s partitioner has no ranges", m.partitioner), 400)
+	case r.Method == "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.stat())
+	case r.Method == "POST":
+		var req RangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		var err error
+		var reason string
+		switch {
+		case req.Split != nil:
+			err = p.Split(*req.Split, nil)
+			reason = "split at " + *req.Split
+		case req.Merge != nil:
+			err = p.Merge(*req.Merge)
+			reason = "merged at " + *req.Merge
+		case req.Move != nil:
+			err = p.Move(*req.Move, req.To)
+			reason = "moved " + *req.Move + " to " + req.To
+		default:
+			err = fmt.Errorf("want split, merge or move")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m.triggerRebalance("ranges: " + reason)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
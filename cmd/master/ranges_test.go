@@ -0,0 +1,195 @@
+package main
+
+import (
+	"customise-db/common"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangePartitioner_SplitMergeMove(t *testing.T) {
+	p := NewRangePartitioner()
+	p.Add("a", "b", "c")
+	if got := p.GetN("anything", 2); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("Expected one range on [a b], got %v", got)
+	}
+
+	if err := p.Split("m", []string{"c", "a", "b"}); err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if err := p.Split("m", nil); err == nil {
+		t.Errorf("Expected a second split at m to be rejected")
+	}
+	if got := p.GetN("l", 1); got[0] != "a" {
+		t.Errorf("Expected l below the split on a, got %v", got)
+	}
+	if got := p.GetN("m", 1); got[0] != "c" {
+		t.Errorf("Expected m above the split on c, got %v", got)
+	}
+
+	if err := p.Move("x", "b"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if got := p.GetN("x", 3); got[0] != "b" || got[1] != "c" || got[2] != "a" {
+		t.Errorf("Expected x's range on [b c a] after the move, got %v", got)
+	}
+	if err := p.Move("x", "nobody"); err == nil {
+		t.Errorf("Expected a move to an unknown worker to be rejected")
+	}
+
+	if err := p.Merge("x"); err == nil {
+		t.Errorf("Expected merging the last range to be rejected")
+	}
+	if err := p.Merge(""); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if r := p.Ranges(); len(r) != 1 || r[0].Start != "" || r[0].End != "" || r[0].Group[0] != "a" {
+		t.Errorf("Expected one range on a after the merge, got %+v", r)
+	}
+}
+
+func TestRangePartitioner_LoadsSavedTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ranges.json")
+	p := NewRangePartitioner()
+	if err := p.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	p.Add("a", "b")
+	p.Split("k", []string{"b", "a"})
+
+	restored := NewRangePartitioner()
+	if err := restored.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	restored.Add("a", "b", "c")
+	r := restored.Ranges()
+	if len(r) != 2 || r[1].Start != "k" || r[1].Group[0] != "b" {
+		t.Fatalf("Expected the split at k on b to survive, got %+v", r)
+	}
+	if g := r[0].Group; len(g) != 3 || g[0] != "a" || g[2] != "c" {
+		t.Errorf("Expected a new worker at the end of each group, got %v", g)
+	}
+}
+
+func TestBalanceRanges_SplitsHotWorkerAndMergesCold(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 1)
+	p := NewRangePartitioner()
+	p.Add(addrs...)
+	p.SetThresholds(10, 0, 5)
+	m.ring = p
+	for i := 0; i < 20; i++ {
+		if err := m.Put(&common.PutArgs{Key: fmt.Sprintf("k%02d", i), Value: "v"}, &common.PutReply{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	waitRebalance := func(reason string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for stat := m.rebalance.status(); stat.Running || stat.Reason != reason; stat = m.rebalance.status() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Rebalance did not finish: %+v", stat)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	m.balanceRanges(p)
+	waitRebalance("ranges: split at k10")
+	r := p.Ranges()
+	if len(r) != 2 || r[1].Start != "k10" || r[1].Group[0] == addrs[0] {
+		t.Fatalf("Expected the upper half split off at k10 to another worker, got %+v", r)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		want := addrs[0]
+		if i >= 10 {
+			want = r[1].Group[0]
+		}
+		for addr, f := range fakes {
+			if f.has(key) != (addr == want) {
+				t.Errorf("%s: expected only %s to hold it, %s has it: %v", key, want, addr, f.has(key))
+			}
+		}
+	}
+
+	// Once most keys are gone the halves are cold and merge back.
+	for _, f := range fakes {
+		for i := 2; i < 20; i++ {
+			f.Delete(&common.DeleteArgs{Key: fmt.Sprintf("k%02d", i)}, &common.DeleteReply{})
+		}
+	}
+	m.balanceRanges(p)
+	waitRebalance("ranges: merged at k10")
+	if r := p.Ranges(); len(r) != 1 {
+		t.Errorf("Expected the cold ranges merged, got %+v", r)
+	}
+}
+
+func TestBalanceRanges_NoSplitWhenEveryWorkerHoldsIt(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		addr, _ := startFakeWorker(t)
+		addrs = append(addrs, addr)
+	}
+	// With rf 2 both workers hold every range: a split would move no load.
+	m := newTestMaster(addrs, 2)
+	p := NewRangePartitioner()
+	p.Add(addrs...)
+	p.SetThresholds(10, 0, 5)
+	m.ring = p
+	for i := 0; i < 20; i++ {
+		if err := m.Put(&common.PutArgs{Key: fmt.Sprintf("k%02d", i), Value: "v"}, &common.PutReply{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	m.balanceRanges(p)
+	if r := p.Ranges(); len(r) != 1 {
+		t.Errorf("Expected no split, got %+v", r)
+	}
+}
+
+func TestMergeColdRanges_StaysClearOfTheSplitThreshold(t *testing.T) {
+	p := NewRangePartitioner()
+	p.Add("a", "b")
+	p.Split("m", nil)
+	p.SetThresholds(10, 0, 8)
+	p.keys = map[string]int{"": 3, "m": 3}
+
+	// 6 keys is under the merge threshold but not under half the split one.
+	if merged := mergeColdRanges(p, nil); len(merged) != 0 {
+		t.Errorf("Expected no merge so close to the split threshold, merged at %v", merged)
+	}
+	p.keys = map[string]int{"": 2, "m": 2}
+	if merged := mergeColdRanges(p, nil); len(merged) != 1 {
+		t.Errorf("Expected the cold ranges merged")
+	}
+}
+
+func TestHandleRanges_MergesTheFirstRange(t *testing.T) {
+	addr, _ := startFakeWorker(t)
+	m := newTestMaster([]string{addr}, 1)
+	p := NewRangePartitioner()
+	p.Add(addr)
+	p.Split("m", nil)
+	m.ring = p
+
+	rec := httptest.NewRecorder()
+	m.handleRanges(rec, httptest.NewRequest("POST", "/ranges", strings.NewReader(`{"merge": ""}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first range to merge, got %d: %s", rec.Code, rec.Body)
+	}
+	if r := p.Ranges(); len(r) != 1 {
+		t.Errorf("Expected one range left, got %+v", r)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read policies: how the master orders a key's replicas before reading them.
+const (
+	readRing        = "ring"         // Ring order (the default)
+	readLeastLoaded = "least-loaded" // Cheapest replica first
+	readP2C         = "p2c"          // Cheaper of two random replicas first, then ring order
+)
+
+// ewmaAlpha weights each new read latency in a worker's moving average.
+const ewmaAlpha = 0.2
+
+// readPolicyModes are the modes whose reads may be sent to any replica, and so
+// may use a read policy other than ring. Chain and primary reads have to go to
+// particular nodes.
+var readPolicyModes = []string{"sync", "async", "quorum"}
+
+func validReadPolicy(p string) bool {
+	return p == readRing || p == readLeastLoaded || p == readP2C
+}
+
+// parseReadPolicies reads a -read-policy spec: either one policy for every
+// mode that supports it ("p2c"), or per-mode policies ("async=p2c,quorum=least-loaded").
+func parseReadPolicies(spec string) (map[string]string, error) {
+	policies := make(map[string]string)
+	if spec == "" {
+		return policies, nil
+	}
+	if !strings.Contains(spec, "=") {
+		if !validReadPolicy(spec) {
+			return nil, fmt.Errorf("unknown read policy %q", spec)
+		}
+		for _, mode := range readPolicyModes {
+			policies[mode] = spec
+		}
+		return policies, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		mode, policy, _ := strings.Cut(part, "=")
+		if err := checkReadPolicy(mode, policy); err != nil {
+			return nil, err
+		}
+		policies[mode] = policy
+	}
+	return policies, nil
+}
+
+func checkReadPolicy(mode, policy string) error {
+	if !validReadPolicy(policy) {
+		return fmt.Errorf("unknown read policy %q", policy)
+	}
+	if policy != readRing && !contains(readPolicyModes, mode) {
+		return fmt.Errorf("mode %q reads from fixed replicas; read policies apply to %s", mode, strings.Join(readPolicyModes, ", "))
+	}
+	return nil
+}
+
+// workerLoad is the master's view of how busy one worker is.
+type workerLoad struct {
+	latency  time.Duration // EWMA of its read latency
+	inFlight int           // Calls the master has outstanding to it
+	rate     int           // Requests per second, as last reported by the worker
+	maxLoad  int           // Its configured request-rate limit (0 = unlimited)
+}
+
+// cost estimates how long a read sent to the worker now would take: its usual
+// latency, scaled by the queue of calls ahead of it and by how close it is to
+// its rate limit. Workers not yet measured cost nothing, so they get tried.
+func (l *workerLoad) cost() float64 {
+	c := float64(l.latency) * float64(l.inFlight+1)
+	if l.maxLoad > 0 {
+		c *= 1 + float64(l.rate)/float64(l.maxLoad)
+	}
+	return c
+}
+
+// replicaSelector tracks per-worker load and orders replicas for reads
+// according to each mode's read policy.
+type replicaSelector struct {
+	mu       sync.Mutex
+	workers  map[string]*workerLoad
+	policies map[string]string // Mode -> read policy (absent = ring)
+}
+
+func newReplicaSelector(policies map[string]string) *replicaSelector {
+	if policies == nil {
+		policies = make(map[string]string)
+	}
+	return &replicaSelector{workers: make(map[string]*workerLoad), policies: policies}
+}
+
+// load returns addr's record. Caller holds s.mu.
+func (s *replicaSelector) load(addr string) *workerLoad {
+	l, ok := s.workers[addr]
+	if !ok {
+		l = &workerLoad{}
+		s.workers[addr] = l
+	}
+	return l
+}
+
+// begin counts a call to addr as in flight.
+func (s *replicaSelector) begin(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load(addr).inFlight++
+}
+
+// end counts a call to addr as finished; read latencies feed its average.
+func (s *replicaSelector) end(addr, method string, took time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.load(addr)
+	l.inFlight--
+	if method != "KV.Get" {
+		return
+	}
+	if l.latency == 0 {
+		l.latency = took
+	} else {
+		l.latency = time.Duration(ewmaAlpha*float64(took) + (1-ewmaAlpha)*float64(l.latency))
+	}
+}
+
+// observeRate records the request rate a worker reported in its stats.
+func (s *replicaSelector) observeRate(addr string, rate, maxLoad int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.load(addr)
+	l.rate, l.maxLoad = rate, maxLoad
+}
+
+func (s *replicaSelector) policy(mode string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.policies[mode]; ok {
+		return p
+	}
+	return readRing
+}
+
+func (s *replicaSelector) setPolicy(mode, policy string) error {
+	if err := checkReadPolicy(mode, policy); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies[mode] = policy
+	return nil
+}
+
+// order returns replicas in the order policy says to read them.
+func (s *replicaSelector) order(policy string, replicas []string) []string {
+	if policy == readRing || len(replicas) < 2 {
+		return replicas
+	}
+	s.mu.Lock()
+	costs := make(map[string]float64, len(replicas))
+	for _, r := range replicas {
+		costs[r] = s.load(r).cost()
+	}
+	s.mu.Unlock()
+
+	ordered := make([]string, len(replicas))
+	copy(ordered, replicas)
+	switch policy {
+	case readLeastLoaded:
+		sort.SliceStable(ordered, func(i, j int) bool { return costs[ordered[i]] < costs[ordered[j]] })
+	case readP2C:
+		i := rand.Intn(len(ordered))
+		j := rand.Intn(len(ordered) - 1)
+		if j >= i {
+			j++
+		}
+		if costs[ordered[j]] < costs[ordered[i]] {
+			i = j
+		}
+		// The chosen replica first; the rest stay in ring order for failover.
+		chosen := ordered[i]
+		copy(ordered[1:i+1], ordered[:i])
+		ordered[0] = chosen
+	}
+	return ordered
+}
+
+// WorkerLoadStat is the JSON view of one worker's load.
+type WorkerLoadStat struct {
+	Address   string  `json:"address"`
+	LatencyMs float64 `json:"latency_ms"` // EWMA read latency
+	InFlight  int     `json:"in_flight"`
+	Rate      int     `json:"rate"`
+}
+
+// ReadPolicyStat summarises replica selection for /status.
+type ReadPolicyStat struct {
+	Policies map[string]string `json:"policies"` // Mode -> read policy
+	Workers  []WorkerLoadStat  `json:"workers"`
+}
+
+func (s *replicaSelector) stat() ReadPolicyStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := ReadPolicyStat{Policies: make(map[string]string), Workers: []WorkerLoadStat{}}
+	for _, mode := range readPolicyModes {
+		st.Policies[mode] = readRing
+	}
+	for mode, p := range s.policies {
+		st.Policies[mode] = p
+	}
+	for addr, l := range s.workers {
+		st.Workers = append(st.Workers, WorkerLoadStat{
+			Address:   addr,
+			LatencyMs: float64(l.latency) / float64(time.Millisecond),
+			InFlight:  l.inFlight,
+			Rate:      l.rate,
+		})
+	}
+	sort.Slice(st.Workers, func(i, j int) bool { return st.Workers[i].Address < st.Workers[j].Address })
+	return st
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestParseReadPolicies(t *testing.T) {
+	all, err := parseReadPolicies("p2c")
+	if err != nil || all["sync"] != readP2C || all["async"] != readP2C || all["quorum"] != readP2C {
+		t.Errorf("Expected p2c for every mode that allows it, got %v (%v)", all, err)
+	}
+	if _, ok := all["chain"]; ok {
+		t.Errorf("Expected chain reads to keep their fixed replicas")
+	}
+	per, err := parseReadPolicies("async=least-loaded,quorum=p2c")
+	if err != nil || len(per) != 2 || per["async"] != readLeastLoaded || per["quorum"] != readP2C {
+		t.Errorf("Expected per-mode policies, got %v (%v)", per, err)
+	}
+	for _, bad := range []string{"fastest", "chain=p2c", "primary=least-loaded", "async=fastest"} {
+		if _, err := parseReadPolicies(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestReplicaSelector_Order(t *testing.T) {
+	s := newReplicaSelector(nil)
+	replicas := []string{"a", "b", "c"}
+	timeRead(s, "a", 50*time.Millisecond)
+	timeRead(s, "b", 10*time.Millisecond)
+	timeRead(s, "c", 10*time.Millisecond)
+	s.begin("b") // b has a read outstanding
+	s.begin("b")
+	s.begin("c")
+
+	if got := s.order(readRing, replicas); got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Expected ring order, got %v", got)
+	}
+	if got := s.order(readLeastLoaded, replicas); got[0] != "c" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("Expected c, b, a by cost, got %v", got)
+	}
+	for i := 0; i < 50; i++ {
+		if got := s.order(readP2C, replicas); got[0] == "a" || len(got) != 3 {
+			t.Fatalf("Expected p2c never to prefer the costliest replica, got %v", got)
+		}
+	}
+
+	s.observeRate("c", 90, 100) // Near its rate limit
+	if got := s.order(readLeastLoaded, replicas); got[0] != "b" {
+		t.Errorf("Expected the busy worker to be passed over, got %v", got)
+	}
+}
+
+func TestGetQuorum_PolicyAsksPreferredSubset(t *testing.T) {
+	fakes := make(map[string]*fakeWorker)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 3)
+	m.mode = "quorum"
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := m.selector.setPolicy("quorum", readLeastLoaded); err != nil {
+		t.Fatalf("setPolicy: %v", err)
+	}
+	slow := addrs[0]
+	for _, addr := range addrs {
+		timeRead(m.selector, addr, time.Millisecond)
+	}
+	timeRead(m.selector, slow, time.Second)
+
+	reply := &common.GetReply{}
+	if err := m.Get(&common.GetArgs{Key: "k"}, reply); err != nil || reply.Value != "v" {
+		t.Fatalf("Expected v, got %q (%v)", reply.Value, err)
+	}
+	for addr, f := range fakes {
+		f.mu.Lock()
+		gets := f.gets
+		f.mu.Unlock()
+		if want := map[bool]int{true: 0, false: 1}[addr == slow]; gets != want {
+			t.Errorf("%s: expected %d reads, got %d", addr, want, gets)
+		}
+	}
+}
+
+// timeRead records a finished read of addr that took took.
+func timeRead(s *replicaSelector, addr string, took time.Duration) {
+	s.begin(addr)
+	s.end(addr, "KV.Get", took)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RebalanceStat summarises the most recent background rebalance.
+type RebalanceStat struct {
+	Running  bool      `json:"running"`
+	Reason   string    `json:"reason"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Scanned  int       `json:"scanned"` // Distinct keys found across the cluster
+	Copied   int       `json:"copied"`  // Replicas added
+	Trimmed  int       `json:"trimmed"` // Replicas removed
+	Errors   int       `json:"errors"`
+}
+
+// rebalancer makes sure at most one rebalance runs at a time. A request that
+// arrives while one is running is remembered and run right after it.
+type rebalancer struct {
+	mu      sync.Mutex
+	running bool
+	pending string // Reason for a rebalance requested while one was running
+	last    RebalanceStat
+}
+
+func (r *rebalancer) status() RebalanceStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// triggerRebalance starts a background pass that moves every key onto exactly
+// the replicas the current ring and replication factor call for. The ring
+// has changed, so it moves to a new epoch first.
+func (m *Master) triggerRebalance(reason string) {
+	m.bumpEpoch(reason)
+	m.rebalance.mu.Lock()
+	if m.rebalance.running {
+		m.rebalance.pending = reason
+		m.rebalance.mu.Unlock()
+		return
+	}
+	m.rebalance.running = true
+	m.rebalance.last = RebalanceStat{Running: true, Reason: reason, Started: time.Now()}
+	m.rebalance.mu.Unlock()
+
+	go func() {
+		for {
+			stat := m.rebalanceOnce(reason)
+
+			m.rebalance.mu.Lock()
+			m.rebalance.last = stat
+			if m.rebalance.pending == "" {
+				m.rebalance.running = false
+				m.rebalance.mu.Unlock()
+				return
+			}
+			reason = m.rebalance.pending
+			m.rebalance.pending = ""
+			m.rebalance.last = RebalanceStat{Running: true, Reason: reason, Started: time.Now()}
+			m.rebalance.mu.Unlock()
+		}
+	}()
+}
+
+// rebalanceOnce scans every worker's keys, copies each key to any replica that
+// should hold it but doesn't, and then trims it from workers that shouldn't.
+// Copies always happen before trims so a key is never left under-replicated.
+func (m *Master) rebalanceOnce(reason string) RebalanceStat {
+	stat := RebalanceStat{Reason: reason, Started: time.Now()}
+	log.Printf("[Rebalance] Starting (%s)", reason)
+
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	// 1. Who holds what?
+	holders := make(map[string][]string)
+	for _, w := range workers {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			// Without a full picture we must not trim anything this worker may be the last copy of.
+			log.Printf("[Rebalance] Skipping unreachable worker %s: %v", w, err)
+			stat.Errors++
+			continue
+		}
+		for _, k := range s.Keys {
+			holders[k] = append(holders[k], w)
+		}
+	}
+	stat.Scanned = len(holders)
+	complete := stat.Errors == 0
+
+	// 2. Move each key towards its desired replica set.
+	for key, have := range holders {
+		copied, trimmed, err := m.rebalanceKey(key, have, complete)
+		stat.Copied += copied
+		stat.Trimmed += trimmed
+		if err != nil {
+			log.Printf("[Rebalance] Key %s: %v", key, err)
+			stat.Errors++
+		}
+	}
+
+	stat.Finished = time.Now()
+	log.Printf("[Rebalance] Done (%s): %d keys, %d copied, %d trimmed, %d errors",
+		reason, stat.Scanned, stat.Copied, stat.Trimmed, stat.Errors)
+	return stat
+}
+
+// rebalanceKey reconciles a single key. have lists the workers currently
+// holding it; trimming is only allowed when the scan saw every worker. The
+// newest copy may be on any holder (an async write may have reached just one),
+// so every holder is read and the newest copy goes to each desired replica
+// that lacks it. Holders are trimmed only once every read and copy succeeded,
+// and only of the version read from them.
+func (m *Master) rebalanceKey(key string, have []string, allowTrim bool) (copied, trimmed int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Get)
+	states, answered, readErr := m.readStates(ctx, key, have)
+	cancel()
+	if answered == 0 {
+		return 0, 0, fmt.Errorf("read failed: %v", readErr)
+	}
+	value, err := newestCopy(states)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !value.Found {
+		if readErr == nil {
+			m.forgetKey(key) // Gone from every holder: expired since the scan
+		}
+		return 0, 0, nil
+	}
+
+	m.placeKey(key, value.TTL)
+	want := m.getReplicas(key)
+	wanted := make(map[string]bool, len(want))
+	for _, w := range want {
+		wanted[w] = true
+	}
+
+	for _, w := range want {
+		if held, ok := states[w]; ok && held.Found && held.Version >= value.Version && held.Value == value.Value {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+		err := m.callWorker(ctx, w, "KV.Put", &common.PutArgs{Key: key, Value: value.Value, Version: value.Version, TTL: value.TTL, CRDT: value.CRDT, Deadline: common.DeadlineOf(ctx)}, &common.PutReply{})
+		cancel()
+		if common.IsStaleVersion(err) {
+			continue // Written since the read; the newer write is the one to keep
+		}
+		if err != nil {
+			return copied, trimmed, fmt.Errorf("copy to %s failed: %v", w, err)
+		}
+		copied++
+	}
+
+	if readErr != nil {
+		return copied, trimmed, fmt.Errorf("not trimming: a holder could not be read: %v", readErr)
+	}
+	if !allowTrim {
+		return copied, trimmed, nil
+	}
+	for _, w := range have {
+		if wanted[w] || !states[w].Found {
+			continue // Wanted, or gone from it since the scan
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+		err := m.callWorker(ctx, w, "KV.Delete", &common.DeleteArgs{Key: key, Version: states[w].Version, Deadline: common.DeadlineOf(ctx)}, &common.DeleteReply{})
+		cancel()
+		if common.IsStaleVersion(err) {
+			continue // Written since the read; the next pass moves the newer write
+		}
+		if err != nil {
+			return copied, trimmed, fmt.Errorf("trim from %s failed: %v", w, err)
+		}
+		trimmed++
+	}
+	return copied, trimmed, nil
+}
+
+// newestCopy picks the copy of a key to spread among states: the highest
+// version, or for a CRDT the merge of every state.
+func newestCopy(states map[string]*common.GetReply) (common.GetReply, error) {
+	var newest common.GetReply
+	for _, s := range states {
+		if !s.Found {
+			continue
+		}
+		if s.CRDT != "" {
+			return mergeStates(states)
+		}
+		if !newest.Found || s.Version > newest.Version {
+			newest = *s
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"customise-db/common"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+func TestValidateRF(t *testing.T) {
+	if err := validateRF(0, 3); err == nil {
+		t.Errorf("Expected RF 0 to be rejected")
+	}
+	if err := validateRF(4, 3); err == nil {
+		t.Errorf("Expected RF larger than the cluster to be rejected")
+	}
+	if err := validateRF(3, 3); err != nil {
+		t.Errorf("Expected RF equal to cluster size to be accepted, got %v", err)
+	}
+}
+
+func TestRebalance_GrowAndShrinkRF(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 4; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+
+	m := newTestMaster(addrs, 1)
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	countHolders := func() int {
+		n := 0
+		for _, f := range fakes {
+			if f.has("k") {
+				n++
+			}
+		}
+		return n
+	}
+
+	m.rf = 3
+	if stat := m.rebalanceOnce("test grow"); stat.Copied != 2 {
+		t.Errorf("Expected 2 replicas copied, got %+v", stat)
+	}
+	if n := countHolders(); n != 3 {
+		t.Errorf("Expected 3 holders after growing RF, got %d", n)
+	}
+
+	m.rf = 2
+	if stat := m.rebalanceOnce("test shrink"); stat.Trimmed != 1 {
+		t.Errorf("Expected 1 replica trimmed, got %+v", stat)
+	}
+	for _, addr := range m.getReplicas("k") {
+		if !fakes[addr].has("k") {
+			t.Errorf("Desired replica %s lost the key", addr)
+		}
+	}
+	if n := countHolders(); n != 2 {
+		t.Errorf("Expected 2 holders after shrinking RF, got %d", n)
+	}
+}
+
+func TestRebalance_KeepsTheNewestCopyOnAnUnwantedHolder(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 1)
+	wanted := m.getReplicas("k")[0]
+	var unwanted string
+	for _, addr := range addrs {
+		if addr != wanted {
+			unwanted = addr
+			break
+		}
+	}
+	// The wanted replica lags; the newest write only reached a worker that no longer should hold it.
+	fakes[wanted].Put(&common.PutArgs{Key: "k", Value: "old", Version: 1}, &common.PutReply{})
+	fakes[unwanted].Put(&common.PutArgs{Key: "k", Value: "new", Version: 2}, &common.PutReply{})
+
+	if stat := m.rebalanceOnce("test"); stat.Copied != 1 || stat.Trimmed != 1 || stat.Errors != 0 {
+		t.Errorf("Expected the newest copy moved and the stray trimmed, got %+v", stat)
+	}
+	got := &common.GetReply{}
+	if fakes[wanted].Get(&common.GetArgs{Key: "k"}, got); got.Value != "new" {
+		t.Errorf("Expected the wanted replica to hold the newest version, got %q", got.Value)
+	}
+	if fakes[unwanted].has("k") {
+		t.Errorf("Expected the stray copy trimmed")
+	}
+}
+
+// writtenAfterRead is a fakeWorker that takes a newer write of the key just
+// after answering a read of it.
+type writtenAfterRead struct {
+	*fakeWorker
+}
+
+func (w writtenAfterRead) Get(args *common.GetArgs, reply *common.GetReply) error {
+	err := w.fakeWorker.Get(args, reply)
+	w.fakeWorker.Put(&common.PutArgs{Key: args.Key, Value: "newer", Version: reply.Version + 1}, &common.PutReply{})
+	return err
+}
+
+func TestRebalance_TrimKeepsAWriteMadeSinceTheRead(t *testing.T) {
+	wanted, fw := startFakeWorker(t)
+	m := newTestMaster([]string{wanted}, 1)
+	// A holder that should no longer have the key is written to right after the rebalance reads it.
+	stray := &fakeWorker{data: map[string]string{"k": "v"}, versions: map[string]int64{"k": 1}}
+	srv := rpc.NewServer()
+	srv.RegisterName("KV", writtenAfterRead{stray})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.Accept(l)
+
+	copied, trimmed, err := m.rebalanceKey("k", []string{l.Addr().String()}, true)
+	if err != nil || copied != 1 || trimmed != 0 {
+		t.Errorf("Expected the copy made and the trim skipped, got %d copied, %d trimmed (%v)", copied, trimmed, err)
+	}
+	if !fw.has("k") || !stray.has("k") {
+		t.Errorf("Expected the key copied and the write made since the read kept")
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"customise-db/common"
+	"customise-db/crdt"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicationRetry paces redelivery to a replica that is down. Unlike RetryPolicy
+// for reads it never gives up on transport failures: the write must get there.
+var replicationRetry = RetryPolicy{Base: 100 * time.Millisecond, Max: 5 * time.Second}
+
+// compactAfter is how many delivered writes a queue file collects before it is rewritten.
+const compactAfter = 1000
+
+// queuedWrite is one write waiting to be copied to a replica.
+type queuedWrite struct {
+	Seq      uint64    `json:"seq"`
+	Key      string    `json:"key"`
+	Value    string    `json:"value"`
+	Version  int64     `json:"version"`
+	Expires  time.Time `json:"expires,omitempty"` // When the value's TTL runs out (zero = never)
+	CRDT     string    `json:"crdt,omitempty"`    // Value is a CRDT state of this type
+	Enqueued time.Time `json:"enqueued"`          // When the key was first owed, for lag
+}
+
+// expiry turns a write's TTL into the time it runs out (zero = never).
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// queueRecord is one line of a queue file: a write queued (or superseded, by a
+// later record with the same Seq) or delivered.
+type queueRecord struct {
+	Put *queuedWrite `json:"put,omitempty"`
+	Ack uint64       `json:"ack,omitempty"`
+}
+
+// destQueue holds the writes owed to one replica, oldest first. Writes are
+// delivered one at a time in queue order, so a replica sees a key's writes in
+// the order they were made. A queued write is replaced in place by a newer
+// write to the same key, since the replica only needs the latest.
+type destQueue struct {
+	addr string
+	wake chan struct{}
+
+	mu        sync.Mutex
+	entries   []*queuedWrite          // Oldest first; entries[0] may be in flight
+	byKey     map[string]*queuedWrite // Queued writes not yet in flight
+	nextSeq   uint64
+	file      *os.File // Nil when the queue is kept in memory only
+	acked     int      // Deliveries logged since the file was last compacted
+	delivered int
+	retries   int
+	dropped   int
+	lastError string
+}
+
+// replicationQueues are the master's bounded, durable per-replica queues for
+// writes that are acknowledged before every replica has them (async and any).
+type replicationQueues struct {
+	mu     sync.Mutex
+	dir    string // Where queue files live ("" = memory only)
+	limit  int    // Maximum queued writes per replica (0 = unlimited)
+	queues map[string]*destQueue
+	m      *Master
+}
+
+func newReplicationQueues(m *Master, dir string, limit int) *replicationQueues {
+	return &replicationQueues{dir: dir, limit: limit, queues: make(map[string]*destQueue), m: m}
+}
+
+func queueFile(dir, addr string) string {
+	return filepath.Join(dir, strings.NewReplacer(":", "_", "/", "_").Replace(addr)+".queue")
+}
+
+// load restores every queue left on disk by a previous run and starts
+// delivering it.
+func (r *replicationQueues) load() error {
+	if r.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.queue"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		addr := strings.TrimSuffix(filepath.Base(f), ".queue")
+		if i := strings.LastIndex(addr, "_"); i >= 0 {
+			addr = addr[:i] + ":" + addr[i+1:]
+		}
+		q, err := r.queue(addr)
+		if err != nil {
+			return err
+		}
+		q.mu.Lock()
+		n := len(q.entries)
+		q.mu.Unlock()
+		if n > 0 {
+			log.Printf("[Replication] Restored %d queued writes for %s", n, addr)
+		}
+	}
+	return nil
+}
+
+// queue returns the queue for addr, opening (and replaying) its file and
+// starting its sender on first use.
+func (r *replicationQueues) queue(addr string) (*destQueue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if q, ok := r.queues[addr]; ok {
+		return q, nil
+	}
+	q := &destQueue{addr: addr, wake: make(chan struct{}, 1), byKey: make(map[string]*queuedWrite), nextSeq: 1}
+	if r.dir != "" {
+		if err := q.open(queueFile(r.dir, addr)); err != nil {
+			return nil, fmt.Errorf("replication queue for %s: %v", addr, err)
+		}
+	}
+	r.queues[addr] = q
+	go r.m.deliverQueued(q)
+	return q, nil
+}
+
+// open replays a queue file and reopens it for appending.
+func (q *destQueue) open(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	pending := make(map[uint64]*queuedWrite)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec queueRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue // A torn final line from a crash
+		}
+		if rec.Put != nil {
+			pending[rec.Put.Seq] = rec.Put
+		}
+		if rec.Ack != 0 {
+			delete(pending, rec.Ack)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	for _, w := range pending {
+		q.entries = append(q.entries, w)
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].Seq < q.entries[j].Seq })
+	for _, w := range q.entries {
+		q.byKey[w.Key] = w
+		if w.Seq >= q.nextSeq {
+			q.nextSeq = w.Seq + 1
+		}
+	}
+	q.file = f
+	return q.compactLocked()
+}
+
+// appendLocked logs rec to the queue file. Caller holds q.mu.
+func (q *destQueue) appendLocked(rec queueRecord, sync bool) error {
+	if q.file == nil {
+		return nil
+	}
+	if err := writeLine(q.file, rec); err != nil {
+		return err
+	}
+	if sync {
+		return q.file.Sync()
+	}
+	return nil
+}
+
+// compactLocked rewrites the queue file to hold just the pending writes.
+// Caller holds q.mu.
+func (q *destQueue) compactLocked() error {
+	q.acked = 0
+	if q.file == nil {
+		return nil
+	}
+	f, err := replaceFile(q.file, func(f *os.File) error {
+		for _, w := range q.entries {
+			if err := writeLine(f, queueRecord{Put: w}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if f != nil {
+		q.file = f
+	}
+	return err
+}
+
+// writeLine appends rec to f as one line of JSON.
+func writeLine(f *os.File, rec any) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// replaceFile swaps the contents of old for what write puts in a new file,
+// returning it reopened for appending. The new file is synced and renamed
+// over old, and the directory synced, so a crash at any point leaves one of
+// the two whole. Unless it returns a file, old is still open.
+func replaceFile(old *os.File, write func(f *os.File) error) (*os.File, error) {
+	path := old.Name()
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	old.Close()
+	return f, syncDir(dir)
+}
+
+// syncDir makes the entries of dir, such as a rename into it, durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// full reports whether any of replicas has no room for another write.
+func (r *replicationQueues) full(replicas []string) error {
+	if r.limit == 0 {
+		return nil
+	}
+	for _, addr := range replicas {
+		q, err := r.queue(addr)
+		if err != nil {
+			return err
+		}
+		q.mu.Lock()
+		n := len(q.entries)
+		q.mu.Unlock()
+		if n >= r.limit {
+			return fmt.Errorf("replication queue for %s is full (%d writes behind)", addr, n)
+		}
+	}
+	return nil
+}
+
+// enqueue durably queues args for delivery to addr.
+func (r *replicationQueues) enqueue(addr string, args *common.PutArgs) error {
+	q, err := r.queue(addr)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w, ok := q.byKey[args.Key]; ok {
+		if args.CRDT != "" && w.CRDT == args.CRDT {
+			// Neither state need include the other: owe the replica both.
+			merged, err := crdt.Merge(args.CRDT, w.Value, args.Value)
+			if err != nil {
+				return err
+			}
+			w.Value, w.Version, w.Expires = merged, max(w.Version, args.Version), expiry(args.TTL)
+			return q.appendLocked(queueRecord{Put: w}, true)
+		}
+		if args.Version < w.Version {
+			return nil // Already owed something newer
+		}
+		w.Value, w.Version, w.Expires, w.CRDT = args.Value, args.Version, expiry(args.TTL), args.CRDT
+		return q.appendLocked(queueRecord{Put: w}, true)
+	}
+	w := &queuedWrite{Seq: q.nextSeq, Key: args.Key, Value: args.Value, Version: args.Version, Expires: expiry(args.TTL), CRDT: args.CRDT, Enqueued: time.Now()}
+	q.nextSeq++
+	if err := q.appendLocked(queueRecord{Put: w}, true); err != nil {
+		return err
+	}
+	q.entries = append(q.entries, w)
+	q.byKey[w.Key] = w
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next hands the sender the oldest queued write. From then on it is in flight:
+// a newer write to the same key is queued behind it rather than replacing it.
+func (q *destQueue) next() (*queuedWrite, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return nil, false
+	}
+	w := *q.entries[0]
+	delete(q.byKey, w.Key)
+	return &w, true
+}
+
+// done removes the write at the head of the queue once it is delivered (or
+// given up on).
+func (q *destQueue) done(delivered bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := q.entries[0]
+	q.entries = q.entries[1:]
+	if delivered {
+		q.delivered++
+	} else {
+		q.dropped++
+	}
+	q.acked++
+	var err error
+	if len(q.entries) == 0 || q.acked >= compactAfter {
+		err = q.compactLocked()
+	} else {
+		err = q.appendLocked(queueRecord{Ack: w.Seq}, false)
+	}
+	if err != nil {
+		log.Printf("[Replication] Queue file for %s: %v", q.addr, err)
+	}
+}
+
+// deliverQueued is the sender for one replica: it delivers the queue in order,
+// retrying each write until the replica takes it.
+func (m *Master) deliverQueued(q *destQueue) {
+	for {
+		w, ok := q.next()
+		if !ok {
+			<-q.wake
+			continue
+		}
+		q.done(m.deliver(q, w))
+	}
+}
+
+// deliver copies one queued write to its replica, waiting out transport
+// failures. It gives up only on a write that has expired or that the replica
+// keeps rejecting.
+func (m *Master) deliver(q *destQueue, w *queuedWrite) bool {
+	rejections := 0
+	for attempt := 1; ; attempt++ {
+		args := &common.PutArgs{Key: w.Key, Value: w.Value, Version: w.Version, CRDT: w.CRDT}
+		if !w.Expires.IsZero() {
+			if args.TTL = time.Until(w.Expires); args.TTL <= 0 {
+				return false // Expired before it could be delivered
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+		args.Deadline = common.DeadlineOf(ctx)
+		err := m.callWorker(ctx, q.addr, "KV.Put", args, &common.PutReply{})
+		cancel()
+		if err == nil || common.IsStaleVersion(err) {
+			return true // A stale version: the replica already holds a newer write
+		}
+
+		q.mu.Lock()
+		q.retries++
+		q.lastError = err.Error()
+		q.mu.Unlock()
+		if !countsAsFailure(err) {
+			if rejections++; rejections >= 3 {
+				log.Printf("[Replication] Dropping %s v%d for %s: %v", w.Key, w.Version, q.addr, err)
+				return false
+			}
+		}
+		time.Sleep(replicationRetry.backoff(attempt))
+	}
+}
+
+// ReplicationStat is the JSON view of one replica's queue.
+type ReplicationStat struct {
+	Address    string  `json:"address"`
+	Pending    int     `json:"pending"`     // Writes the replica is behind by
+	LagSeconds float64 `json:"lag_seconds"` // Age of the oldest write it has not got
+	Delivered  int     `json:"delivered"`
+	Retries    int     `json:"retries"`
+	Dropped    int     `json:"dropped"`
+	LastError  string  `json:"last_error,omitempty"`
+}
+
+func (r *replicationQueues) stats() []ReplicationStat {
+	r.mu.Lock()
+	queues := make([]*destQueue, 0, len(r.queues))
+	for _, q := range r.queues {
+		queues = append(queues, q)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	out := make([]ReplicationStat, 0, len(queues))
+	for _, q := range queues {
+		q.mu.Lock()
+		s := ReplicationStat{
+			Address:   q.addr,
+			Pending:   len(q.entries),
+			Delivered: q.delivered,
+			Retries:   q.retries,
+			Dropped:   q.dropped,
+			LastError: q.lastError,
+		}
+		if len(q.entries) > 0 {
+			s.LagSeconds = now.Sub(q.entries[0].Enqueued).Seconds()
+		}
+		q.mu.Unlock()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"customise-db/common"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplicationQueue_DeliversAfterOutage(t *testing.T) {
+	primary, _ := startFakeWorker(t)
+	replica := deadAddr(t)
+	m := newTestMaster([]string{primary, replica}, 2)
+	m.mode = "async"
+	key := "k"
+	for i := 0; m.getReplicas(key)[0] != primary; i++ {
+		key = fmt.Sprintf("k%d", i)
+	}
+
+	if err := m.Put(&common.PutArgs{Key: key, Value: "v"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	stats := m.replication.stats()
+	if len(stats) != 1 || stats[0].Address != replica {
+		t.Fatalf("Expected one queue, for %s, got %+v", replica, stats)
+	}
+	if stats[0].Pending != 1 || stats[0].LagSeconds <= 0 || stats[0].Retries == 0 {
+		t.Errorf("Expected the down replica to be 1 write behind and retrying, got %+v", stats[0])
+	}
+
+	_, f := startFakeWorkerAt(t, replica)
+	deadline := time.Now().Add(10 * time.Second)
+	for !f.has(key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Queued write never reached the recovered replica")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s := m.replication.stats()[0]; s.Pending != 0 || s.Delivered != 1 {
+		t.Errorf("Expected the queue to drain, got %+v", s)
+	}
+}
+
+func TestReplicationQueue_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	replica := deadAddr(t)
+	m := newTestMaster([]string{replica}, 1)
+	m.replication = newReplicationQueues(m, dir, 3)
+	if err := m.replication.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	for _, w := range []common.PutArgs{
+		{Key: "x", Value: "1", Version: 1}, // Head of the queue, stuck in flight
+		{Key: "a", Value: "1", Version: 1},
+		{Key: "b", Value: "1", Version: 2},
+		{Key: "a", Value: "2", Version: 3}, // Replaces the queued write to a
+	} {
+		if err := m.replication.enqueue(replica, &w); err != nil {
+			t.Fatalf("enqueue %s: %v", w.Key, err)
+		}
+	}
+	if err := m.replication.full([]string{replica}); err == nil {
+		t.Errorf("Expected a queue at its limit to refuse more writes")
+	}
+
+	restarted := newTestMaster([]string{replica}, 1)
+	restarted.replication = newReplicationQueues(restarted, dir, 3)
+	if err := restarted.replication.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	q, _ := restarted.replication.queue(replica)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) != 3 {
+		t.Fatalf("Expected 3 queued writes after restart, got %d", len(q.entries))
+	}
+	if a := q.entries[1]; a.Key != "a" || a.Value != "2" || a.Version != 3 {
+		t.Errorf("Expected a=2 (v3) second in the queue, got %+v", a)
+	}
+	if b := q.entries[2]; b.Key != "b" {
+		t.Errorf("Expected b last in the queue, got %+v", b)
+	}
+}
+
+func TestReplicationQueue_ReplacementKeepsItsOwnTTL(t *testing.T) {
+	replica := deadAddr(t)
+	m := newTestMaster([]string{replica}, 1)
+	for _, w := range []common.PutArgs{
+		{Key: "x", Value: "1", Version: 1}, // Head of the queue, stuck in flight
+		{Key: "a", Value: "1", Version: 2, TTL: time.Second},
+	} {
+		if err := m.replication.enqueue(replica, &w); err != nil {
+			t.Fatalf("enqueue %s: %v", w.Key, err)
+		}
+	}
+	q, _ := m.replication.queue(replica)
+	q.mu.Lock()
+	q.byKey["a"].Enqueued = time.Now().Add(-time.Hour) // Owed for an hour already
+	q.mu.Unlock()
+
+	if err := m.replication.enqueue(replica, &common.PutArgs{Key: "a", Value: "2", Version: 3, TTL: time.Minute}); err != nil {
+		t.Fatalf("enqueue a: %v", err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a := q.byKey["a"]
+	if left := time.Until(a.Expires); a.Value != "2" || left < 50*time.Second {
+		t.Errorf("Expected a=2 to keep about a minute of its TTL, got %q with %v left", a.Value, left)
+	}
+	if time.Since(a.Enqueued) < time.Hour {
+		t.Errorf("Expected the replacement to keep the queue's lag")
+	}
+}
+
+func TestReplaceFile_LeavesOneWholeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "w.queue")
+	old, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	old.WriteString("old\n")
+
+	if _, err := replaceFile(old, func(f *os.File) error {
+		f.WriteString("half")
+		return errors.New("disk full")
+	}); err == nil {
+		t.Fatalf("Expected the failed write to be reported")
+	}
+	if got, _ := os.ReadFile(path); string(got) != "old\n" {
+		t.Errorf("Expected the old file kept whole, got %q", got)
+	}
+	if tmp, _ := filepath.Glob(path + ".*"); len(tmp) != 0 {
+		t.Errorf("Expected no temporary file left, got %v", tmp)
+	}
+
+	f, err := replaceFile(old, func(f *os.File) error { return writeLine(f, "new") })
+	if err != nil {
+		t.Fatalf("replaceFile: %v", err)
+	}
+	writeLine(f, "appended")
+	f.Close()
+	if got, _ := os.ReadFile(path); string(got) != "\"new\"\n\"appended\"\n" {
+		t.Errorf("Expected the new contents, then the append, got %q", got)
+	}
+}
+
+func TestPutAsync_FailsWhenAReplicaCannotBeQueued(t *testing.T) {
+	a, _ := startFakeWorker(t)
+	b, _ := startFakeWorker(t)
+	m := newTestMaster([]string{a, b}, 2)
+	m.mode = "async"
+	notADir := filepath.Join(t.TempDir(), "queues")
+	os.WriteFile(notADir, nil, 0o644)
+	m.replication = newReplicationQueues(m, notADir, 0)
+
+	if err := m.Put(&common.PutArgs{Key: "k", Value: "v"}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected the write to fail when a replica's queue cannot take it")
+	}
+}
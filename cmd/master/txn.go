@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"customise-db/common"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transactions (see worker/txn.go): Begin opens a transaction on the
+// master, TxnPut buffers writes in it and TxnGet reads through them. Commit
+// runs two-phase commit over every replica of every key written: each
+// participant locks its keys and votes, the decision is logged durably (see
+// txnlog.go), then every participant is told. The writes go to every replica
+// directly, whatever the replication mode, at one version shared by the whole
+// transaction. Reads see committed data only; they take no locks.
+//
+// A master that crashes mid-commit finishes the job when it restarts: a
+// decided transaction has its decision redelivered, and an undecided one is
+// aborted, since no participant can have committed it. A participant that
+// crashes loses its staged writes and locks with the rest of its memory; the
+// redelivered commit carries the writes.
+
+// errUnknownTxn is returned for a transaction that was never begun, has
+// already finished, or was dropped for being idle.
+var errUnknownTxn = errors.New("unknown transaction")
+
+// txnWrite is a write buffered in an open transaction.
+type txnWrite struct {
+	common.TxnWrite
+	ns      *Namespace
+	userKey string
+}
+
+// transaction is one open transaction.
+type transaction struct {
+	id       string
+	writes   map[string]*txnWrite // Storage key -> buffered write
+	watch    map[string]int64     // Storage key -> version read; the commit fails if it has changed
+	snapshot int64                // Read-only: the timestamp every read is made at (0 = read-write)
+	touched  time.Time
+}
+
+// txnManager holds the open transactions and the coordinator log.
+type txnManager struct {
+	mu        sync.Mutex
+	active    map[string]*transaction
+	timeout   time.Duration // Idle time after which an open transaction is dropped
+	log       *txnLog
+	clock     *versionClock // Timestamp oracle for snapshots
+	prefix    string        // Unique to this master run, so IDs never repeat across restarts
+	next      uint64
+	committed int
+	aborted   int
+	conflicts int // Aborted because another transaction held a key
+	recovered int // Left unfinished by a previous run and finished by this one
+	collected int // Old versions workers dropped
+}
+
+func newTxnManager(l *txnLog, timeout time.Duration, clock *versionClock) *txnManager {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &txnManager{active: make(map[string]*transaction), timeout: timeout, log: l, clock: clock, prefix: hex.EncodeToString(b)}
+}
+
+// begin opens a transaction, read-only ones at a snapshot timestamp.
+func (t *txnManager) begin(readOnly bool) (id string, snapshot int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireLocked()
+	t.next++
+	id = "txn-" + t.prefix + "-" + strconv.FormatUint(t.next, 10)
+	if readOnly {
+		// Taken under t.mu, so no collection can pass it before it is open.
+		snapshot = t.clock.snapshot()
+	}
+	t.active[id] = &transaction{id: id, writes: make(map[string]*txnWrite), watch: make(map[string]int64), snapshot: snapshot, touched: time.Now()}
+	return id, snapshot
+}
+
+// expireLocked drops transactions left idle too long. Caller holds t.mu.
+func (t *txnManager) expireLocked() {
+	now := time.Now()
+	for id, tx := range t.active {
+		if now.Sub(tx.touched) > t.timeout {
+			log.Printf("[Txn] Dropping %s: idle for %v", id, now.Sub(tx.touched).Round(time.Second))
+			delete(t.active, id)
+			t.aborted++
+		}
+	}
+}
+
+// watermark is the oldest timestamp an open snapshot reads at, or a fresh
+// snapshot timestamp if none is open: no later snapshot can read older
+// versions than it.
+func (t *txnManager) watermark() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireLocked()
+	oldest := t.clock.snapshot()
+	for _, tx := range t.active {
+		if tx.snapshot != 0 && tx.snapshot < oldest {
+			oldest = tx.snapshot
+		}
+	}
+	return oldest
+}
+
+// getLocked returns an open transaction that has not gone idle. Caller holds t.mu.
+func (t *txnManager) getLocked(id string) (*transaction, error) {
+	tx, ok := t.active[id]
+	if !ok || time.Since(tx.touched) > t.timeout {
+		return nil, fmt.Errorf("%w %q", errUnknownTxn, id)
+	}
+	tx.touched = time.Now()
+	return tx, nil
+}
+
+// take removes an open transaction so it can be committed or aborted.
+func (t *txnManager) take(id string) (*transaction, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx, err := t.getLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	delete(t.active, id)
+	return tx, nil
+}
+
+// finished counts a transaction's outcome.
+func (t *txnManager) finished(committed bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case committed:
+		t.committed++
+	case common.IsTxnConflict(err):
+		t.conflicts++
+		fallthrough
+	default:
+		t.aborted++
+	}
+}
+
+// Begin is the RPC entry point for opening a transaction.
+func (m *Master) Begin(args *common.TxnArgs, reply *common.TxnReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
+	}
+	reply.TxnID, reply.Snapshot = m.txns.begin(args.ReadOnly)
+	return nil
+}
+
+// TxnPut buffers a write in a transaction; nothing reaches a worker until it commits.
+func (m *Master) TxnPut(args *common.TxnPutArgs, reply *common.TxnReply) error {
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := m.namespaces.checkKey(ns, args.Key); err != nil {
+		return err
+	}
+	// The slot is reserved at commit; this only fails early.
+	if err := m.namespaces.check(ns, args.Key); err != nil {
+		return err
+	}
+	ttl := args.TTL
+	if ttl == 0 {
+		ttl = ns.ttl()
+	}
+	key := ns.storageKey(args.Key)
+
+	m.txns.mu.Lock()
+	defer m.txns.mu.Unlock()
+	tx, err := m.txns.getLocked(args.TxnID)
+	if err != nil {
+		return err
+	}
+	if tx.snapshot != 0 {
+		return fmt.Errorf("transaction %s is read-only", tx.id)
+	}
+	tx.writes[key] = &txnWrite{TxnWrite: common.TxnWrite{Key: key, Value: args.Value, TTL: ttl}, ns: ns, userKey: args.Key}
+	reply.TxnID = tx.id
+	return nil
+}
+
+// TxnGet is the RPC entry point for reading inside a transaction.
+func (m *Master) TxnGet(args *common.TxnGetArgs, reply *common.GetReply) error {
+	return m.txnGet(context.Background(), args, reply)
+}
+
+// txnGet reads a key in a transaction. A read-only transaction reads as of
+// its snapshot; a read-write one returns its own write of the key if it has
+// one, else the committed value.
+func (m *Master) txnGet(ctx context.Context, args *common.TxnGetArgs, reply *common.GetReply) error {
+	ns, err := m.namespaces.lookup(args.Namespace)
+	if err != nil {
+		return err
+	}
+	m.txns.mu.Lock()
+	tx, err := m.txns.getLocked(args.TxnID)
+	var buffered *txnWrite
+	var snapshot int64
+	if err == nil {
+		buffered, snapshot = tx.writes[ns.storageKey(args.Key)], tx.snapshot
+	}
+	m.txns.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if snapshot != 0 {
+		return m.snapshotGet(ctx, args, snapshot, reply)
+	}
+	if buffered != nil {
+		reply.Value, reply.Found = buffered.Value, true
+		return nil
+	}
+	return m.get(ctx, &common.GetArgs{Key: args.Key, Namespace: args.Namespace}, reply)
+}
+
+// Abort is the RPC entry point for abandoning a transaction. Nothing has
+// reached a worker yet, so the buffered writes are simply dropped.
+func (m *Master) Abort(args *common.TxnArgs, reply *common.TxnReply) error {
+	if _, err := m.txns.take(args.TxnID); err != nil {
+		return err
+	}
+	m.txns.finished(false, nil)
+	reply.TxnID = args.TxnID
+	return nil
+}
+
+// Commit is the RPC entry point for committing a transaction.
+func (m *Master) Commit(args *common.TxnArgs, reply *common.TxnReply) error {
+	return m.commit(context.Background(), args.TxnID, reply)
+}
+
+// commit runs two-phase commit for a transaction under the put timeout. Once
+// a decision is logged it stands: a commit whose decision some participant
+// has not yet acknowledged is still reported committed, and is redelivered
+// in the background.
+func (m *Master) commit(ctx context.Context, id string, reply *common.TxnReply) error {
+	if err := m.epoch.check(); err != nil {
+		return err
+	}
+	tx, err := m.txns.take(id)
+	if err != nil {
+		return err
+	}
+	reply.TxnID = id
+	if len(tx.writes) == 0 {
+		m.txns.finished(true, nil)
+		return nil
+	}
+	if err := m.admitWrites(tx); err != nil {
+		m.txns.finished(false, err)
+		return fmt.Errorf("commit %s: aborted: %w", id, err)
+	}
+	ctx, cancel := common.WithDeadline(ctx, time.Time{}, m.timeouts.Put)
+	defer cancel()
+
+	participants := make(map[string][]common.TxnWrite)
+	for key, w := range tx.writes {
+		m.placeKey(key, w.TTL)
+		for _, addr := range m.getReplicas(key) {
+			participants[addr] = append(participants[addr], w.TxnWrite)
+		}
+	}
+	// Watched keys are locked too, so they cannot change between the check
+	// and the commit. A worker holding only those still hears the decision.
+	reads := make(map[string][]string)
+	for key := range tx.watch {
+		for _, addr := range m.getReplicas(key) {
+			reads[addr] = append(reads[addr], key)
+			if _, ok := participants[addr]; !ok {
+				participants[addr] = nil
+			}
+		}
+	}
+	if err := m.txns.log.record(txnRecord{TxnID: id, State: txnPreparing, Writes: participants}); err != nil {
+		m.releaseWrites(tx)
+		m.txns.finished(false, err)
+		return fmt.Errorf("commit %s: logging: %v", id, err)
+	}
+
+	versions, err := m.prepareAll(ctx, id, participants, reads)
+	if err == nil {
+		err = checkWatched(tx.watch, versions)
+	}
+	decision, version := txnCommitted, int64(0)
+	if err != nil {
+		decision = txnAborted
+	} else {
+		// The keys are locked on every replica now, so no plain write can
+		// land on them. Commit above every version a replica reported, or
+		// one already holding a newer write would skip the commit.
+		for _, v := range versions {
+			m.clock.observe(v)
+		}
+		version = m.clock.begin()
+		defer m.clock.end(version)
+		stampWrites(participants, version)
+	}
+	if lerr := m.txns.log.record(txnRecord{TxnID: id, State: decision, Version: version}); lerr != nil && decision == txnCommitted {
+		// Unlogged, the commit must not happen: recovery would abort it.
+		decision, err = txnAborted, fmt.Errorf("logging the decision: %v", lerr)
+	}
+	m.txns.finished(decision == txnCommitted, err)
+	if !m.deliverDecision(ctx, id, decision == txnCommitted, participants) {
+		go m.redeliverDecision(id, decision == txnCommitted, participants)
+	}
+	if err != nil {
+		m.releaseWrites(tx)
+		log.Printf("[Txn] Aborted %s: %v", id, err)
+		return fmt.Errorf("commit %s: aborted: %w", id, err)
+	}
+	for _, w := range tx.writes {
+		m.namespaces.record(w.ns, w.userKey, w.TTL)
+	}
+	reply.Version = version
+	log.Printf("[Txn] Committed %s: %d keys on %d workers at v%d", id, len(tx.writes), len(participants), version)
+	return nil
+}
+
+// stampWrites sets the commit version on every participant's writes.
+func stampWrites(participants map[string][]common.TxnWrite, version int64) {
+	for _, writes := range participants {
+		for i := range writes {
+			writes[i].Version = version
+		}
+	}
+}
+
+// admitWrites reserves a namespace quota slot for each of tx's writes, or
+// none of them.
+func (m *Master) admitWrites(tx *transaction) error {
+	var admitted []*txnWrite
+	for _, w := range tx.writes {
+		if err := m.namespaces.admit(w.ns, w.userKey); err != nil {
+			for _, a := range admitted {
+				m.namespaces.release(a.ns, a.userKey)
+			}
+			return err
+		}
+		admitted = append(admitted, w)
+	}
+	return nil
+}
+
+// releaseWrites gives back the quota slots of an aborted transaction's writes.
+func (m *Master) releaseWrites(tx *transaction) {
+	for _, w := range tx.writes {
+		m.namespaces.release(w.ns, w.userKey)
+	}
+}
+
+// prepareAll asks every participant to prepare, returning the first no vote
+// and, for each key, the newest version any replica of it reported.
+func (m *Master) prepareAll(ctx context.Context, id string, participants map[string][]common.TxnWrite, reads map[string][]string) (map[string]int64, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	versions := make(map[string]int64)
+	errs := make(chan error, len(participants))
+	for addr, writes := range participants {
+		wg.Add(1)
+		go func(addr string, writes []common.TxnWrite) {
+			defer wg.Done()
+			args := &common.PrepareArgs{TxnID: id, Writes: writes, Reads: reads[addr], Deadline: common.DeadlineOf(ctx)}
+			var reply common.PrepareReply
+			// A worker answers a repeated prepare with the same vote.
+			if err := m.callIdempotent(ctx, addr, "KV.Prepare", args, &reply); err != nil {
+				errs <- fmt.Errorf("%s: %w", addr, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for key, v := range reply.Versions {
+				if v > versions[key] {
+					versions[key] = v
+				}
+			}
+		}(addr, writes)
+	}
+	wg.Wait()
+	close(errs)
+	return versions, <-errs
+}
+
+// checkWatched fails a transaction if any watched key is no longer at the
+// version it was read at.
+func checkWatched(watch, versions map[string]int64) error {
+	for key, read := range watch {
+		if now := versions[key]; now != read {
+			return fmt.Errorf("%w: watched key %s changed from v%d to v%d", common.ErrTxnConflict, key, read, now)
+		}
+	}
+	return nil
+}
+
+// deliverDecision tells every participant the outcome once, reporting
+// whether all of them acknowledged it. If they did, the transaction is done.
+func (m *Master) deliverDecision(ctx context.Context, id string, commit bool, participants map[string][]common.TxnWrite) bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	delivered := true
+	for addr, writes := range participants {
+		wg.Add(1)
+		go func(addr string, writes []common.TxnWrite) {
+			defer wg.Done()
+			args := &common.DecideArgs{TxnID: id, Commit: commit}
+			if commit {
+				args.Writes = writes
+			}
+			if err := m.callWorker(ctx, addr, "KV.Decide", args, &common.DecideReply{}); err != nil {
+				mu.Lock()
+				delivered = false
+				mu.Unlock()
+			}
+		}(addr, writes)
+	}
+	wg.Wait()
+	if delivered {
+		if err := m.txns.log.record(txnRecord{TxnID: id, State: txnDone}); err != nil {
+			log.Printf("[Txn] Could not log %s done: %v", id, err)
+		}
+	}
+	return delivered
+}
+
+// redeliverDecision retries a decision until every participant has it,
+// waiting out workers that are down, at most replicationRetry.Max apart. It
+// stops if this master is fenced: the workers refuse its decisions, and the
+// transaction stays open in the log for the master that takes over from it.
+func (m *Master) redeliverDecision(id string, commit bool, participants map[string][]common.TxnWrite) {
+	for attempt := 1; ; attempt++ {
+		time.Sleep(replicationRetry.backoff(attempt))
+		if err := m.epoch.check(); err != nil {
+			log.Printf("[Txn] Stopped redelivering the decision on %s: %v", id, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Put)
+		delivered := m.deliverDecision(ctx, id, commit, participants)
+		cancel()
+		if delivered {
+			log.Printf("[Txn] Delivered the decision on %s after %d retries", id, attempt)
+			return
+		}
+	}
+}
+
+// recoverTxns finishes the transactions a previous run left in the log.
+func (m *Master) recoverTxns() {
+	pending := m.txns.log.unfinished()
+	for _, t := range pending {
+		commit := t.state == txnCommitted
+		if t.state == txnPreparing {
+			// Undecided: no participant can have committed, so abort.
+			if err := m.txns.log.record(txnRecord{TxnID: t.id, State: txnAborted}); err != nil {
+				log.Printf("[Txn] Could not log %s aborted: %v", t.id, err)
+			}
+		}
+		log.Printf("[Txn] Recovering %s: redelivering %s to %d workers", t.id, map[bool]string{true: "commit", false: "abort"}[commit], len(t.writes))
+		go m.redeliverDecision(t.id, commit, t.writes)
+	}
+	m.txns.mu.Lock()
+	m.txns.recovered += len(pending)
+	m.txns.mu.Unlock()
+}
+
+// TxnStat is the JSON view of transactions.
+type TxnStat struct {
+	Active     int `json:"active"`
+	Committed  int `json:"committed"`
+	Aborted    int `json:"aborted"`
+	Conflicts  int `json:"conflicts"`  // Aborts because another transaction held a key
+	Unfinished int `json:"unfinished"` // Decided (or being recovered) but not yet acknowledged by every participant
+	Recovered  int `json:"recovered"`  // Left unfinished by the previous run
+
+	Snapshots int   `json:"snapshots"`    // Open read-only transactions
+	Watermark int64 `json:"gc_watermark"` // Oldest timestamp an open snapshot reads at
+	Collected int   `json:"collected"`    // Old versions workers have dropped
+}
+
+func (t *txnManager) stat() TxnStat {
+	unfinished := len(t.log.unfinished())
+	watermark := t.watermark()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshots := 0
+	for _, tx := range t.active {
+		if tx.snapshot != 0 {
+			snapshots++
+		}
+	}
+	return TxnStat{Active: len(t.active), Committed: t.committed, Aborted: t.aborted, Conflicts: t.conflicts,
+		Unfinished: unfinished, Recovered: t.recovered, Snapshots: snapshots, Watermark: watermark, Collected: t.collected}
+}
+
+// handleTxn serves /txn/begin, /txn/put, /txn/get, /txn/watch, /txn/commit,
+// /txn/abort and /txn/exec.
+func (m *Master) handleTxn(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	q := r.URL.Query()
+	id := q.Get("txn")
+	var err error
+	switch op := strings.TrimPrefix(r.URL.Path, "/txn/"); op {
+	case "begin":
+		reply := &common.TxnReply{}
+		if err = m.Begin(&common.TxnArgs{ReadOnly: q.Get("readonly") == "true"}, reply); err == nil {
+			if reply.Snapshot != 0 {
+				w.Header().Set("X-Snapshot", strconv.FormatInt(reply.Snapshot, 10))
+			}
+			fmt.Fprintf(w, "%s\n", reply.TxnID)
+		}
+	case "put":
+		args := &common.TxnPutArgs{TxnID: id, Key: q.Get("key"), Value: q.Get("value"), Namespace: q.Get("ns")}
+		if args.Key == "" || args.Value == "" {
+			http.Error(w, "missing params", 400)
+			return
+		}
+		if args.TTL, err = durationParam(r, "ttl"); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err = m.TxnPut(args, &common.TxnReply{}); err == nil {
+			fmt.Fprintf(w, "OK\n")
+		}
+	case "get":
+		reply := &common.GetReply{}
+		if err = m.txnGet(r.Context(), &common.TxnGetArgs{TxnID: id, Key: q.Get("key"), Namespace: q.Get("ns")}, reply); err == nil {
+			if !reply.Found {
+				http.Error(w, "Not Found", 404)
+				return
+			}
+			fmt.Fprintf(w, "%s\n", reply.Value)
+		}
+	case "watch":
+		reply := &common.GetReply{}
+		if err = m.watch(r.Context(), &common.TxnGetArgs{TxnID: id, Key: q.Get("key"), Namespace: q.Get("ns")}, reply); err == nil {
+			w.Header().Set("X-Version", strconv.FormatInt(reply.Version, 10))
+			if !reply.Found {
+				http.Error(w, "Not Found", 404)
+				return
+			}
+			fmt.Fprintf(w, "%s\n", reply.Value)
+		}
+	case "exec":
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+		var args common.ExecArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		reply := &common.TxnReply{}
+		if err = m.exec(r.Context(), &args, reply); err == nil {
+			fmt.Fprintf(w, "OK (Version: %d)\n", reply.Version)
+		}
+	case "commit":
+		reply := &common.TxnReply{}
+		if err = m.commit(r.Context(), id, reply); err == nil {
+			fmt.Fprintf(w, "OK (Version: %d)\n", reply.Version)
+		}
+	case "abort":
+		if err = m.Abort(&common.TxnArgs{TxnID: id}, &common.TxnReply{}); err == nil {
+			fmt.Fprintf(w, "OK\n")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown transaction operation %q", op), 404)
+		return
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, errUnknownTxn):
+		http.Error(w, err.Error(), 404)
+	default:
+		http.Error(w, err.Error(), errorStatus(err))
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"customise-db/common"
+	"customise-db/worker"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommit_AppliesOnEveryReplicaOrNone(t *testing.T) {
+	var addrs []string
+	workers := make(map[string]*worker.KVWorker)
+	for i := 0; i < 3; i++ {
+		addr, w := startWorker(t)
+		addrs = append(addrs, addr)
+		workers[addr] = w
+	}
+	m := newTestMaster(addrs, 2)
+
+	begin := &common.TxnReply{}
+	m.Begin(&common.TxnArgs{}, begin)
+	for _, key := range []string{"alice", "bob"} {
+		if err := m.TxnPut(&common.TxnPutArgs{TxnID: begin.TxnID, Key: key, Value: "100"}, &common.TxnReply{}); err != nil {
+			t.Fatalf("TxnPut failed: %v", err)
+		}
+	}
+	got := &common.GetReply{}
+	if err := m.TxnGet(&common.TxnGetArgs{TxnID: begin.TxnID, Key: "alice"}, got); err != nil || got.Value != "100" {
+		t.Errorf("Expected the transaction to read its own write, got %+v (%v)", got, err)
+	}
+	for addr, w := range workers {
+		if stored(t, w, "alice").Found {
+			t.Errorf("Expected nothing on %s before commit", addr)
+		}
+	}
+	committed := &common.TxnReply{}
+	if err := m.Commit(&common.TxnArgs{TxnID: begin.TxnID}, committed); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for _, key := range []string{"alice", "bob"} {
+		for _, addr := range m.getReplicas(key) {
+			if got := stored(t, workers[addr], key); got.Value != "100" || got.Version != committed.Version {
+				t.Errorf("%s on %s: expected 100 at v%d, got %q at v%d", key, addr, committed.Version, got.Value, got.Version)
+			}
+		}
+	}
+	if err := m.Commit(&common.TxnArgs{TxnID: begin.TxnID}, &common.TxnReply{}); !errors.Is(err, errUnknownTxn) {
+		t.Errorf("Expected a second commit to find no transaction, got %v", err)
+	}
+
+	// One participant votes no: nothing is applied anywhere.
+	m.Begin(&common.TxnArgs{}, begin)
+	m.TxnPut(&common.TxnPutArgs{TxnID: begin.TxnID, Key: "alice", Value: "50"}, &common.TxnReply{})
+	m.TxnPut(&common.TxnPutArgs{TxnID: begin.TxnID, Key: "bob", Value: "150"}, &common.TxnReply{})
+	// Another transaction holds bob on one replica, so that replica votes no.
+	holder := &common.PrepareArgs{TxnID: "other", Writes: []common.TxnWrite{{Key: "bob", Value: "0", Version: 1}}}
+	if err := workers[m.getReplicas("bob")[0]].Prepare(holder, &common.PrepareReply{}); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if err := m.Commit(&common.TxnArgs{TxnID: begin.TxnID}, &common.TxnReply{}); !common.IsTxnConflict(err) {
+		t.Fatalf("Expected the commit aborted by a conflict, got %v", err)
+	}
+	for addr, w := range workers {
+		if alice, bob := stored(t, w, "alice"), stored(t, w, "bob"); alice.Value == "50" || bob.Value == "150" {
+			t.Errorf("Expected no write of the aborted transaction on %s, got alice %q and bob %q", addr, alice.Value, bob.Value)
+		}
+	}
+	// Every lock the aborted transaction took has been released.
+	for _, addr := range m.getReplicas("alice") {
+		retry := &common.PrepareArgs{TxnID: "after", Writes: []common.TxnWrite{{Key: "alice", Value: "1", Version: 1}}}
+		if err := workers[addr].Prepare(retry, &common.PrepareReply{}); err != nil {
+			t.Errorf("Expected alice free on %s after the abort, got %v", addr, err)
+		}
+	}
+	if s := m.txns.stat(); s.Committed != 1 || s.Aborted != 1 || s.Conflicts != 1 || s.Unfinished != 0 {
+		t.Errorf("Expected 1 committed and 1 conflict aborted, got %+v", s)
+	}
+}
+
+func TestCommit_VersionsAboveWhatReplicasHold(t *testing.T) {
+	a, wa := startWorker(t)
+	b, _ := startWorker(t)
+	m := newTestMaster([]string{a, b}, 2)
+
+	// A plain write reached one replica after the transaction began.
+	begin := &common.TxnReply{}
+	m.Begin(&common.TxnArgs{}, begin)
+	m.TxnPut(&common.TxnPutArgs{TxnID: begin.TxnID, Key: "k", Value: "txn"}, &common.TxnReply{})
+	newer := time.Now().Add(time.Hour).UnixNano()
+	if err := wa.Put(&common.PutArgs{Key: "k", Value: "plain", Version: newer}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	committed := &common.TxnReply{}
+	if err := m.Commit(&common.TxnArgs{TxnID: begin.TxnID}, committed); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if committed.Version <= newer {
+		t.Errorf("Expected the commit above v%d, got v%d", newer, committed.Version)
+	}
+	if got := stored(t, wa, "k"); got.Value != "txn" {
+		t.Errorf("Expected the commit applied on the replica holding the newer write, got %q", got.Value)
+	}
+}
+
+func TestRecoverTxns_FinishesLoggedTransactions(t *testing.T) {
+	live, w := startWorker(t)
+	down := deadAddr(t)
+	path := filepath.Join(t.TempDir(), "txn.log")
+
+	// A previous run decided t1 but crashed before telling anyone, and crashed
+	// while t2 was still preparing.
+	previous, err := openTxnLog(path)
+	if err != nil {
+		t.Fatalf("openTxnLog: %v", err)
+	}
+	writes := map[string][]common.TxnWrite{
+		live: {{Key: "k", Value: "v", Version: 7}},
+		down: {{Key: "k", Value: "v", Version: 7}},
+	}
+	previous.record(txnRecord{TxnID: "t1", State: txnPreparing, Writes: writes})
+	previous.record(txnRecord{TxnID: "t1", State: txnCommitted})
+	previous.record(txnRecord{TxnID: "t2", State: txnPreparing, Writes: map[string][]common.TxnWrite{live: {{Key: "x", Value: "v", Version: 8}}}})
+	previous.file.Close()
+
+	restored, err := openTxnLog(path)
+	if err != nil {
+		t.Fatalf("openTxnLog: %v", err)
+	}
+	m := newTestMaster([]string{live, down}, 2)
+	m.txns = newTxnManager(restored, time.Minute, &m.clock)
+	m.recoverTxns()
+
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitFor("t1 committed on the live worker", func() bool { return stored(t, w, "k").Found })
+	// The master logs t2 done only after the worker has acknowledged the abort.
+	waitFor("the log to finish t2", func() bool { return m.txns.stat().Unfinished == 1 })
+	if stored(t, w, "x").Found {
+		t.Errorf("Expected the undecided t2 not applied")
+	}
+	if s := m.txns.stat(); s.Recovered != 2 {
+		t.Errorf("Expected both logged transactions recovered, got %+v", s)
+	}
+
+	// The down worker comes back and gets the commit.
+	_, back := startWorkerAt(t, down)
+	waitFor("t1 committed on the returning worker", func() bool { return stored(t, back, "k").Found })
+	waitFor("the log to finish t1", func() bool { return m.txns.stat().Unfinished == 0 })
+	restored.file.Close()
+	if reopened, err := openTxnLog(path); err != nil || len(reopened.unfinished()) != 0 {
+		t.Errorf("Expected nothing left in the log, got %v (%v)", reopened.unfinished(), err)
+	}
+}
+
+func TestRedeliverDecision_StopsWhenFenced(t *testing.T) {
+	addr, w := startWorker(t)
+	m := newTestMaster([]string{addr}, 1)
+	w.Fence(&common.FenceArgs{Epoch: 5}, &common.FenceReply{}) // A newer master has taken over
+
+	done := make(chan struct{})
+	go func() {
+		m.redeliverDecision("t1", true, map[string][]common.TxnWrite{addr: {{Key: "k", Value: "v", Version: 7}}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a fenced master to stop redelivering")
+	}
+	if got := (&common.GetReply{}); w.Get(&common.GetArgs{Key: "k", Epoch: 5}, got) != nil || got.Found {
+		t.Errorf("Expected the fenced master's decision refused, got %+v", got)
+	}
+}
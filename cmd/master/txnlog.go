@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"customise-db/common"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// States a transaction passes through in the coordinator log. A transaction
+// is logged as preparing, with its participants and their writes, before any
+// participant is asked to prepare; the decision, with a commit's version, is
+// logged (and synced) before any participant hears it; done is logged once
+// every participant has.
+const (
+	txnPreparing = "preparing"
+	txnCommitted = "committed"
+	txnAborted   = "aborted"
+	txnDone      = "done"
+)
+
+// txnRecord is one line of the coordinator log.
+type txnRecord struct {
+	TxnID   string                       `json:"txn"`
+	State   string                       `json:"state"`
+	Writes  map[string][]common.TxnWrite `json:"writes,omitempty"`  // Participant -> its writes (preparing only)
+	Version int64                        `json:"version,omitempty"` // Commit version (committed only)
+}
+
+// loggedTxn is a transaction the log has not yet seen done.
+type loggedTxn struct {
+	id     string
+	state  string // txnPreparing, txnCommitted or txnAborted
+	writes map[string][]common.TxnWrite
+}
+
+// txnLog is the coordinator's durable log. After a crash it names every
+// transaction that may still hold locks on a worker, and what was decided.
+type txnLog struct {
+	mu       sync.Mutex
+	file     *os.File // Nil when the log is kept in memory only
+	open     map[string]*loggedTxn
+	finished int // Transactions done since the file was last compacted
+}
+
+// openTxnLog replays the log at path ("" = memory only) and reopens it for appending.
+func openTxnLog(path string) (*txnLog, error) {
+	l := &txnLog{open: make(map[string]*loggedTxn)}
+	if path == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec txnRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue // A torn final line from a crash
+		}
+		l.applyLocked(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.file = f
+	return l, l.compactLocked()
+}
+
+// applyLocked folds rec into the open transactions. Caller holds l.mu.
+func (l *txnLog) applyLocked(rec txnRecord) {
+	switch rec.State {
+	case txnPreparing:
+		l.open[rec.TxnID] = &loggedTxn{id: rec.TxnID, state: txnPreparing, writes: rec.Writes}
+	case txnCommitted, txnAborted:
+		if t, ok := l.open[rec.TxnID]; ok {
+			t.state = rec.State
+			if rec.Version != 0 {
+				stampWrites(t.writes, rec.Version)
+			}
+		}
+	case txnDone:
+		delete(l.open, rec.TxnID)
+		l.finished++
+	}
+}
+
+// record logs rec. Everything but done is synced before it returns, since a
+// participant may act on it as soon as it does.
+func (l *txnLog) record(rec txnRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.appendLocked(rec, rec.State != txnDone); err != nil {
+		return err
+	}
+	l.applyLocked(rec)
+	if l.finished >= compactAfter {
+		return l.compactLocked()
+	}
+	return nil
+}
+
+// appendLocked writes rec to the log file. Caller holds l.mu.
+func (l *txnLog) appendLocked(rec txnRecord, sync bool) error {
+	if l.file == nil {
+		return nil
+	}
+	if err := writeLine(l.file, rec); err != nil {
+		return err
+	}
+	if sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// compactLocked rewrites the log to hold just the open transactions, through
+// replaceFile, so a crash part way keeps every logged decision.
+// Caller holds l.mu.
+func (l *txnLog) compactLocked() error {
+	l.finished = 0
+	if l.file == nil {
+		return nil
+	}
+	f, err := replaceFile(l.file, func(f *os.File) error {
+		for _, t := range l.unfinishedLocked() {
+			if err := writeLine(f, txnRecord{TxnID: t.id, State: txnPreparing, Writes: t.writes}); err != nil {
+				return err
+			}
+			if t.state != txnPreparing {
+				if err := writeLine(f, txnRecord{TxnID: t.id, State: t.state}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if f != nil {
+		l.file = f
+	}
+	return err
+}
+
+// unfinished returns the transactions not yet done, oldest ID first.
+func (l *txnLog) unfinished() []loggedTxn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.unfinishedLocked()
+}
+
+func (l *txnLog) unfinishedLocked() []loggedTxn {
+	out := make([]loggedTxn, 0, len(l.open))
+	for _, t := range l.open {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WeightedPartitioner is a Partitioner whose nodes can take unequal shares of
+// the keys.
+type WeightedPartitioner interface {
+	Partitioner
+	// SetWeight gives node a share of the keys proportional to weight (1 = the default).
+	SetWeight(node string, weight float64)
+	Weight(node string) float64
+}
+
+// weightLocked returns node's weight. Caller holds c.mu.
+func (c *ConsistentHash) weightLocked(node string) float64 {
+	if w, ok := c.weights[node]; ok {
+		return w
+	}
+	return 1
+}
+
+// vnodeCountLocked is how many virtual nodes node gets at its weight: at
+// least one, so a node never drops off the ring. Caller holds c.mu.
+func (c *ConsistentHash) vnodeCountLocked(node string) int {
+	n := int(math.Round(float64(c.replicas) * c.weightLocked(node)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (c *ConsistentHash) Weight(node string) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.weightLocked(node)
+}
+
+// SetWeight scales node's virtual nodes to weight. Virtual node i of a node
+// always hashes to the same point, so growing a node only adds points and
+// shrinking it only removes some: keys move only to or from node.
+func (c *ConsistentHash) SetWeight(node string, weight float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.weights[node] = weight
+	old, onRing := c.vnodes[node]
+	if !onRing {
+		return // Applies when the node is added
+	}
+	count := c.vnodeCountLocked(node)
+	for i := old; i < count; i++ {
+		hash := int(c.hash([]byte(strconv.Itoa(i) + node)))
+		c.keys = append(c.keys, hash)
+		c.hashMap[hash] = node
+	}
+	if count < old {
+		for i := count; i < old; i++ {
+			hash := int(c.hash([]byte(strconv.Itoa(i) + node)))
+			if c.hashMap[hash] == node {
+				delete(c.hashMap, hash)
+			}
+		}
+		c.keys = c.keys[:0]
+		for hash := range c.hashMap {
+			c.keys = append(c.keys, hash)
+		}
+	}
+	sort.Ints(c.keys)
+	c.vnodes[node] = count
+}
+
+// nodeWeights remembers which weights were set by hand, so deriving weights
+// from advertised capacity leaves those alone.
+type nodeWeights struct {
+	mu           sync.Mutex
+	fromCapacity bool            // Derive weights from each worker's MaxKeys/MaxLoad
+	explicit     map[string]bool // Workers whose weight was set through /weights
+}
+
+func newNodeWeights(fromCapacity bool) *nodeWeights {
+	return &nodeWeights{fromCapacity: fromCapacity, explicit: make(map[string]bool)}
+}
+
+// capacityWeights turns the capacities workers advertise into weights
+// relative to the average. MaxKeys is used if any worker limits it, else
+// MaxLoad; a worker without the limit counts as the largest that has one.
+// Workers are left out if none advertises either.
+func capacityWeights(stats map[string]*common.StatsReply) map[string]float64 {
+	for _, limit := range []func(*common.StatsReply) int{
+		func(s *common.StatsReply) int { return s.MaxKeys },
+		func(s *common.StatsReply) int { return s.MaxLoad },
+	} {
+		largest := 0
+		for _, s := range stats {
+			if l := limit(s); l > largest {
+				largest = l
+			}
+		}
+		if largest == 0 {
+			continue
+		}
+		capacity := make(map[string]float64, len(stats))
+		sum := 0.0
+		for addr, s := range stats {
+			l := limit(s)
+			if l == 0 {
+				l = largest
+			}
+			capacity[addr] = float64(l)
+			sum += float64(l)
+		}
+		avg := sum / float64(len(capacity))
+		for addr := range capacity {
+			// Two decimals: enough to place vnodes, and stable across polls.
+			capacity[addr] = math.Round(capacity[addr]/avg*100) / 100
+		}
+		return capacity
+	}
+	return nil
+}
+
+// setWeights applies weights to the ring and, if any changed, starts a
+// rebalance that moves just the keys whose replicas changed.
+func (m *Master) setWeights(weights map[string]float64, reason string) error {
+	wp, ok := m.ring.(WeightedPartitioner)
+	if !ok {
+		return fmt.Errorf("the %s partitioner does not support weights", m.partitioner)
+	}
+	var changed []string
+	for addr, w := range weights {
+		if wp.Weight(addr) == w {
+			continue
+		}
+		wp.SetWeight(addr, w)
+		changed = append(changed, fmt.Sprintf("%s=%g", addr, w))
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	log.Printf("[Weights] Reweighted %s (%s)", strings.Join(changed, ", "), reason)
+	m.triggerRebalance("reweighted: " + reason)
+	return nil
+}
+
+// reweightFromCapacity derives weights from what the workers advertise,
+// skipping workers whose weight was set by hand.
+func (m *Master) reweightFromCapacity(reason string) error {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+
+	stats := make(map[string]*common.StatsReply)
+	for _, w := range workers {
+		s := &common.StatsReply{}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, s)
+		cancel()
+		if err != nil {
+			// Without every worker's capacity the relative weights would be off.
+			return fmt.Errorf("reading capacity of %s: %v", w, err)
+		}
+		stats[w] = s
+	}
+	weights := capacityWeights(stats)
+	m.weights.mu.Lock()
+	for addr := range m.weights.explicit {
+		delete(weights, addr)
+	}
+	m.weights.mu.Unlock()
+	return m.setWeights(weights, reason)
+}
+
+// WeightsRequest sets weights through /weights.
+type WeightsRequest struct {
+	Weights      map[string]float64 `json:"weights"`       // Worker -> weight
+	FromCapacity bool               `json:"from_capacity"` // Re-derive the rest from advertised capacity
+}
+
+// NodeWeight is the JSON view of one worker's weight.
+type NodeWeight struct {
+	Address  string  `json:"address"`
+	Weight   float64 `json:"weight"`
+	Explicit bool    `json:"explicit"` // Set by hand rather than derived
+}
+
+// handleWeights is the admin API: GET lists each worker's weight, POST sets
+// weights by hand and/or re-derives the others from capacity.
+func (m *Master) handleWeights(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	switch r.Method {
+	case "OPTIONS":
+		return
+	case "GET":
+		wp, ok := m.ring.(WeightedPartitioner)
+		m.mu.RLock()
+		workers := make([]string, len(m.workers))
+		copy(workers, m.workers)
+		m.mu.RUnlock()
+		m.weights.mu.Lock()
+		out := make([]NodeWeight, 0, len(workers))
+		for _, addr := range workers {
+			nw := NodeWeight{Address: addr, Weight: 1, Explicit: m.weights.explicit[addr]}
+			if ok {
+				nw.Weight = wp.Weight(addr)
+			}
+			out = append(out, nw)
+		}
+		m.weights.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case "POST":
+		var req WeightsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m.mu.RLock()
+		workers := m.workers
+		for addr, weight := range req.Weights {
+			if !contains(workers, addr) || weight <= 0 {
+				m.mu.RUnlock()
+				http.Error(w, fmt.Sprintf("invalid weight %g for %q: want a positive weight for a known worker", weight, addr), 400)
+				return
+			}
+		}
+		m.mu.RUnlock()
+		if err := m.setWeights(req.Weights, "set through /weights"); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m.weights.mu.Lock()
+		for addr := range req.Weights {
+			m.weights.explicit[addr] = true
+		}
+		m.weights.mu.Unlock()
+		if req.FromCapacity {
+			if err := m.reweightFromCapacity("derived from capacity"); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"customise-db/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConsistentHash_SetWeight(t *testing.T) {
+	ring := NewConsistentHash(20)
+	ring.Add("A", "B", "C")
+	owner := func() map[string]string {
+		out := make(map[string]string)
+		for i := 0; i < 3000; i++ {
+			key := "key" + strconv.Itoa(i)
+			out[key] = ring.GetN(key, 1)[0]
+		}
+		return out
+	}
+	before := owner()
+
+	ring.SetWeight("A", 2)
+	if ring.vnodes["A"] != 40 || len(ring.keys) != 80 {
+		t.Fatalf("Expected A to have 40 of 80 vnodes, got %d of %d", ring.vnodes["A"], len(ring.keys))
+	}
+	grown := owner()
+	share := 0
+	for key, was := range before {
+		if now := grown[key]; now != was && now != "A" {
+			t.Errorf("Growing A moved %s from %s to %s", key, was, now)
+		}
+		if grown[key] == "A" {
+			share++
+		}
+	}
+	if share < 1000 || share > 2000 {
+		t.Errorf("Expected A to own about half the keys at weight 2, got %d/3000", share)
+	}
+
+	ring.SetWeight("A", 0.5)
+	if ring.vnodes["A"] != 10 || len(ring.keys) != 50 {
+		t.Fatalf("Expected A to have 10 of 50 vnodes, got %d of %d", ring.vnodes["A"], len(ring.keys))
+	}
+	for key, now := range owner() {
+		if was := grown[key]; now != was && was != "A" {
+			t.Errorf("Shrinking A moved %s from %s to %s", key, was, now)
+		}
+	}
+}
+
+func TestCapacityWeights(t *testing.T) {
+	weights := capacityWeights(map[string]*common.StatsReply{
+		"a": {MaxKeys: 1000},
+		"b": {MaxKeys: 2000},
+		"c": {}, // Unlimited: counts as the largest
+	})
+	if weights["a"] != 0.6 || weights["b"] != 1.2 || weights["c"] != 1.2 {
+		t.Errorf("Expected weights from MaxKeys of 0.6/1.2/1.2, got %v", weights)
+	}
+	weights = capacityWeights(map[string]*common.StatsReply{
+		"a": {MaxLoad: 100},
+		"b": {MaxLoad: 300},
+	})
+	if weights["a"] != 0.5 || weights["b"] != 1.5 {
+		t.Errorf("Expected weights from MaxLoad of 0.5/1.5, got %v", weights)
+	}
+	if weights = capacityWeights(map[string]*common.StatsReply{"a": {}, "b": {}}); weights != nil {
+		t.Errorf("Expected no weights when no worker advertises capacity, got %v", weights)
+	}
+}
+
+func TestSetWeights_Rebalances(t *testing.T) {
+	var addrs []string
+	fakes := make(map[string]*fakeWorker)
+	for i := 0; i < 3; i++ {
+		addr, f := startFakeWorker(t)
+		addrs = append(addrs, addr)
+		fakes[addr] = f
+	}
+	m := newTestMaster(addrs, 1)
+	for i := 0; i < 50; i++ {
+		if err := m.Put(&common.PutArgs{Key: "key" + strconv.Itoa(i), Value: "v"}, &common.PutReply{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	if err := m.setWeights(map[string]float64{addrs[0]: 3}, "test"); err != nil {
+		t.Fatalf("setWeights: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stat := m.rebalance.status(); stat.Running || stat.Reason != "reweighted: test"; stat = m.rebalance.status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Rebalance did not finish: %+v", stat)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 50; i++ {
+		key := "key" + strconv.Itoa(i)
+		want := m.getReplicas(key)[0]
+		for addr, f := range fakes {
+			if f.has(key) != (addr == want) {
+				t.Errorf("%s: expected only %s to hold it, %s has it: %v", key, want, addr, f.has(key))
+			}
+		}
+	}
+}
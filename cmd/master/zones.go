@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"customise-db/common"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Zone-aware placement: workers are started with a zone (or rack) label, and
+// the ring spreads a key's replicas over as many distinct zones as it can, so
+// losing a whole zone leaves a copy elsewhere. A worker without a label counts
+// as a zone of its own.
+
+// ZonedPartitioner is a Partitioner that spreads replicas across zones.
+type ZonedPartitioner interface {
+	Partitioner
+	// SetZone records node's zone ("" = none).
+	SetZone(node, zone string)
+}
+
+// zoneLocked returns the zone node counts as. Caller holds c.mu.
+func (c *ConsistentHash) zoneLocked(node string) string {
+	if z := c.zones[node]; z != "" {
+		return z
+	}
+	return "node:" + node
+}
+
+func (c *ConsistentHash) SetZone(node, zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if zone == "" {
+		delete(c.zones, node)
+		return
+	}
+	c.zones[node] = zone
+}
+
+// walkLocked walks the ring clockwise from vnode idx and returns up to n
+// distinct nodes, preferred first. Nodes in a zone not yet used come first;
+// once every zone is used, the rest are filled in ring order. Without zone
+// labels this is the plain ring walk. Caller holds c.mu.
+func (c *ConsistentHash) walkLocked(idx, n int) []string {
+	var nodes []string
+	used := make(map[string]bool)
+	for pass := 0; pass < 2 && len(nodes) < n && len(nodes) < len(c.vnodes); pass++ {
+		for i := 0; i < len(c.keys) && len(nodes) < n && len(nodes) < len(c.vnodes); i++ {
+			node := c.hashMap[c.keys[(idx+i)%len(c.keys)]]
+			zone := c.zoneLocked(node)
+			if contains(nodes, node) || (pass == 0 && used[zone]) {
+				continue
+			}
+			nodes = append(nodes, node)
+			used[zone] = true
+		}
+	}
+	return nodes
+}
+
+// workerZones is the master's record of each worker's zone, as reported in
+// its stats.
+type workerZones struct {
+	mu     sync.Mutex
+	byNode map[string]string
+}
+
+func newWorkerZones() *workerZones {
+	return &workerZones{byNode: make(map[string]string)}
+}
+
+func (z *workerZones) snapshot() map[string]string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	out := make(map[string]string, len(z.byNode))
+	for node, zone := range z.byNode {
+		out[node] = zone
+	}
+	return out
+}
+
+// observeZone records the zone a worker reported. A worker whose zone is new
+// or changed moves keys, so that starts a rebalance (unless quiet, as when
+// the master is starting and the keys are assumed to be where they belong).
+func (m *Master) observeZone(addr, zone string, quiet bool) {
+	m.zones.mu.Lock()
+	old, known := m.zones.byNode[addr]
+	m.zones.byNode[addr] = zone
+	m.zones.mu.Unlock()
+	if known && old == zone {
+		return
+	}
+	zp, ok := m.ring.(ZonedPartitioner)
+	if !ok {
+		return
+	}
+	zp.SetZone(addr, zone)
+	if quiet || (!known && zone == "") {
+		return // A worker without a zone places keys as before
+	}
+	log.Printf("[Zones] Worker %s is in zone %q (was %q)", addr, zone, old)
+	m.triggerRebalance("zone of " + addr + " changed")
+}
+
+// learnZones asks every worker for its zone.
+func (m *Master) learnZones(quiet bool) {
+	m.mu.RLock()
+	workers := make([]string, len(m.workers))
+	copy(workers, m.workers)
+	m.mu.RUnlock()
+	for _, w := range workers {
+		var s common.StatsReply
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeouts.Stats)
+		err := m.callIdempotent(ctx, w, "KV.GetStats", &common.StatsArgs{}, &s)
+		cancel()
+		if err != nil {
+			log.Printf("[Zones] Could not read the zone of %s: %v", w, err)
+			continue
+		}
+		m.observeZone(w, s.Zone, quiet)
+	}
+}
+
+// maxZoneViolations caps how many offending keys /status lists.
+const maxZoneViolations = 20
+
+// ZoneViolation is a key whose copies share zones although enough other
+// zones are available.
+type ZoneViolation struct {
+	Key     string   `json:"key"`
+	Holders []string `json:"holders"` // Workers holding the key
+	Zones   int      `json:"zones"`   // Distinct zones among them
+	Want    int      `json:"want"`    // Distinct zones it could span
+}
+
+// ZoneStat reports zone-aware placement on /status.
+type ZoneStat struct {
+	Zones      map[string][]string `json:"zones"`      // Zone -> its workers
+	Checked    int                 `json:"checked"`    // Keys checked
+	Violations int                 `json:"violations"` // Keys whose copies span too few zones
+	Examples   []ZoneViolation     `json:"examples"`   // Up to maxZoneViolations of them, by key
+}
+
+// zoneReport checks where keys actually are: a key held by copies workers
+// (capped at the replication factor) should span that many zones, or every
+// zone if there are fewer. Only workers that reported stats are counted.
+func zoneReport(zones map[string]string, stats []WorkerStat, rf int) ZoneStat {
+	st := ZoneStat{Zones: make(map[string][]string), Examples: []ZoneViolation{}}
+	zoneOf := func(node string) string {
+		if z := zones[node]; z != "" {
+			return z
+		}
+		return "node:" + node
+	}
+	holders := make(map[string][]string)
+	for _, s := range stats {
+		if z := zones[s.Address]; z != "" {
+			st.Zones[z] = append(st.Zones[z], s.Address)
+		}
+		for _, k := range s.Keys {
+			holders[k] = append(holders[k], s.Address)
+		}
+	}
+	distinct := make(map[string]bool)
+	for _, s := range stats {
+		distinct[zoneOf(s.Address)] = true
+	}
+	for _, nodes := range st.Zones {
+		sort.Strings(nodes)
+	}
+
+	for key, nodes := range holders {
+		st.Checked++
+		want := len(nodes)
+		if want > rf {
+			want = rf
+		}
+		if want > len(distinct) {
+			want = len(distinct)
+		}
+		spanned := make(map[string]bool)
+		for _, n := range nodes {
+			spanned[zoneOf(n)] = true
+		}
+		if len(spanned) >= want {
+			continue
+		}
+		st.Violations++
+		sort.Strings(nodes)
+		st.Examples = append(st.Examples, ZoneViolation{Key: key, Holders: nodes, Zones: len(spanned), Want: want})
+	}
+	sort.Slice(st.Examples, func(i, j int) bool { return st.Examples[i].Key < st.Examples[j].Key })
+	if len(st.Examples) > maxZoneViolations {
+		st.Examples = st.Examples[:maxZoneViolations]
+	}
+	return st
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestConsistentHash_SpreadsAcrossZones(t *testing.T) {
+	for _, balance := range []float64{0, 0.25} {
+		ring := NewConsistentHash(20)
+		ring.SetBalance(balance)
+		ring.Add("a1", "a2", "a3", "b1", "b2", "c1")
+		zones := map[string]string{"a1": "a", "a2": "a", "a3": "a", "b1": "b", "b2": "b", "c1": "c"}
+		for node, zone := range zones {
+			ring.SetZone(node, zone)
+		}
+		for i := 0; i < 2000; i++ {
+			key := "key" + strconv.Itoa(i)
+			nodes := ring.GetN(key, 3)
+			seen := make(map[string]bool)
+			for _, n := range nodes {
+				seen[zones[n]] = true
+			}
+			if len(nodes) != 3 || len(seen) != 3 {
+				t.Fatalf("balance %g: expected %s on 3 zones, got %v", balance, key, nodes)
+			}
+			// With more replicas than zones, the rest still go to distinct nodes.
+			if all := ring.GetN(key, 6); len(all) != 6 || all[0] != nodes[0] {
+				t.Fatalf("balance %g: expected all 6 nodes led by %s, got %v", balance, nodes[0], all)
+			}
+		}
+	}
+}
+
+func TestConsistentHash_NoZonesKeepsRingOrder(t *testing.T) {
+	plain := NewConsistentHash(20)
+	plain.Add("A", "B", "C", "D")
+	zoned := NewConsistentHash(20)
+	zoned.Add("A", "B", "C", "D")
+	zoned.SetZone("A", "z1")
+	zoned.SetZone("A", "") // Cleared again
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		p, z := plain.GetN(key, 3), zoned.GetN(key, 3)
+		if len(p) != 3 || p[0] != z[0] || p[1] != z[1] || p[2] != z[2] {
+			t.Fatalf("Expected %s on %v without zones, got %v", key, p, z)
+		}
+	}
+}
+
+func TestZoneReport(t *testing.T) {
+	zones := map[string]string{"w1": "a", "w2": "a", "w3": "b"}
+	stats := []WorkerStat{
+		{Address: "w1", Keys: []string{"ok", "bad", "single"}},
+		{Address: "w2", Keys: []string{"bad"}},
+		{Address: "w3", Keys: []string{"ok"}},
+	}
+	st := zoneReport(zones, stats, 2)
+	if st.Checked != 3 || st.Violations != 1 || len(st.Examples) != 1 {
+		t.Fatalf("Expected 1 violation among 3 keys, got %+v", st)
+	}
+	if v := st.Examples[0]; v.Key != "bad" || v.Zones != 1 || v.Want != 2 {
+		t.Errorf("Expected bad to span 1 of 2 zones, got %+v", v)
+	}
+	if len(st.Zones["a"]) != 2 || len(st.Zones["b"]) != 1 {
+		t.Errorf("Expected zones a=[w1 w2] b=[w3], got %v", st.Zones)
+	}
+}
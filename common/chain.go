@@ -0,0 +1,49 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var chainLinkDown = regexp.MustCompile(`chain link down \[([^\]]+)\]`)
+
+// ErrNoLease is returned by a worker asked to act as primary without a valid lease.
+var ErrNoLease = errors.New("no valid primary lease")
+
+// ChainLinkError reports that a replica could not reach addr, the next one it
+// replicates to: a chain node's successor or one of a primary's backups. A
+// chain node also names itself or its predecessor when their stream has lost
+// writes between them. It travels back as an rpc.ServerError string, so the
+// master recovers the failed address with FailedChainLink.
+func ChainLinkError(addr string, err error) error {
+	return fmt.Errorf("chain link down [%s]: %v", addr, err)
+}
+
+// FailedChainLink returns the address named by a ChainLinkError anywhere in err.
+func FailedChainLink(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	m := chainLinkDown.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// ChainID names one generation of a replication chain, head first. Writes are
+// sequenced per ID, so a chain whose membership changes starts a fresh stream.
+func ChainID(generation int64, members []string) string {
+	return fmt.Sprintf("%d@%s", generation, strings.Join(members, ","))
+}
+
+// ChainMembers returns the workers of the chain named by id, head first.
+func ChainMembers(id string) []string {
+	_, members, ok := strings.Cut(id, "@")
+	if !ok || members == "" {
+		return nil
+	}
+	return strings.Split(members, ",")
+}
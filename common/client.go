@@ -0,0 +1,51 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// Call dials addr and invokes the given RPC method, giving up as soon as ctx is
+// done. A cancelled call closes the connection so no goroutine is left waiting
+// for a reply that nobody will read.
+func Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close()
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return fmt.Errorf("%s to %s: %w", method, addr, ctx.Err())
+	}
+}
+
+// WithDeadline derives a context that expires at deadline, or after timeout if
+// that comes first. A zero deadline or timeout is ignored.
+func WithDeadline(parent context.Context, deadline time.Time, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		if byTimeout := time.Now().Add(timeout); deadline.IsZero() || byTimeout.Before(deadline) {
+			deadline = byTimeout
+		}
+	}
+	if deadline.IsZero() {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, deadline)
+}
+
+// DeadlineOf returns the deadline carried by ctx, or the zero time if it has none.
+// It is used to stamp outgoing args so the next hop inherits the same budget.
+func DeadlineOf(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithDeadline_PicksEarlier(t *testing.T) {
+	far := time.Now().Add(time.Hour)
+	ctx, cancel := WithDeadline(context.Background(), far, time.Second)
+	defer cancel()
+
+	d, ok := ctx.Deadline()
+	if !ok {
+		t.Fatalf("Expected a deadline")
+	}
+	if d.After(time.Now().Add(2 * time.Second)) {
+		t.Errorf("Expected timeout to win over a far deadline, got %v", d)
+	}
+
+	ctx2, cancel2 := WithDeadline(context.Background(), time.Time{}, 0)
+	defer cancel2()
+	if _, ok := ctx2.Deadline(); ok {
+		t.Errorf("Expected no deadline when neither deadline nor timeout is set")
+	}
+}
+
+func TestCall_HungServer(t *testing.T) {
+	// A server that accepts connections but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = Call(ctx, l.Addr().String(), "KV.Get", &GetArgs{Key: "k"}, &GetReply{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Call took %v, expected it to give up at the deadline", elapsed)
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import "time"
+
+// Counters: an increment is sent down the ordinary write path as a PutArgs
+// with Incr set. The first worker to apply it (the chain head, the key's
+// primary, or else its first replica) adds By to the integer it holds and
+// turns the write into an ordinary one of the result, at a version after the
+// value it added to. The other replicas get that absolute write, so they
+// converge however the copies are ordered or retried. The resolved write keeps
+// By, and every worker answers it with the result, so a retry deduplicated by
+// a replica that only ever saw the copy still learns the counter's value.
+
+// IncrArgs holds arguments for the master's Incr and Decr RPCs.
+type IncrArgs struct {
+	Key         string
+	By          int64         // Amount to add (Decr subtracts it)
+	Namespace   string        // Namespace (bucket) the key lives in ("" = default)
+	Consistency string        // Requested consistency level ("" = cluster default)
+	TTL         time.Duration // Time to live (0 = namespace default, or no expiry)
+
+	ClientID  string // As for PutArgs: a retry with the same IDs is applied once
+	RequestID string
+}
+
+// IncrReply holds the reply for the Incr and Decr RPCs.
+type IncrReply struct {
+	Value       int64 // The counter after the increment
+	Version     int64 // Version the result was stored at
+	Consistency string
+}
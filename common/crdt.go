@@ -0,0 +1,35 @@
+package common
+
+import (
+	"customise-db/crdt"
+	"time"
+)
+
+// CRDTs: an operation goes down the write path as a PutArgs with CRDT and Op
+// set. The worker it reaches first applies the operation to its own state and
+// turns the write into a merge of the resulting state, which the other
+// replicas fold into theirs. Replicas merge whatever states reach them, in
+// any order, so updates made at different replicas are never lost.
+
+// CRDTArgs holds arguments for the master's CRDTUpdate RPC.
+type CRDTArgs struct {
+	Key         string
+	Type        string  // One of the crdt package's Type constants
+	Op          crdt.Op // Operation to apply
+	Namespace   string
+	Consistency string        // Requested consistency level ("" = cluster default)
+	TTL         time.Duration // Time to live (0 = namespace default, or no expiry)
+
+	ClientID  string // As for PutArgs: a retry with the same IDs is applied once
+	RequestID string
+}
+
+// CRDTReply holds the reply for the CRDTUpdate and CRDTGet RPCs.
+type CRDTReply struct {
+	Type    string
+	State   string // Encoded state; crdt.Decode(Type, State) recovers it
+	Found   bool
+	Version int64
+
+	Consistency string
+}
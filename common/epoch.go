@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var staleEpoch = regexp.MustCompile(`older than the cluster's (\d+)`)
+
+// ErrStaleRouting is returned for a request routed with an older cluster
+// epoch than the worker has already seen: the sender's view of the ring and
+// its members is out of date, and it must refresh before trying again.
+var ErrStaleRouting = errors.New("stale routing, refresh")
+
+// StaleRoutingError rejects a request routed at epoch while the cluster is at
+// current. Like ChainLinkError it travels as an rpc.ServerError string, so
+// the master recovers current with StaleEpoch.
+func StaleRoutingError(epoch, current uint64) error {
+	return fmt.Errorf("%w: epoch %d is older than the cluster's %d", ErrStaleRouting, epoch, current)
+}
+
+// IsStaleRouting reports whether err is, or wraps, a stale-routing rejection.
+func IsStaleRouting(err error) bool {
+	return err != nil && (errors.Is(err, ErrStaleRouting) || strings.Contains(err.Error(), ErrStaleRouting.Error()))
+}
+
+// StaleEpoch returns the cluster epoch named by a StaleRoutingError anywhere in err.
+func StaleEpoch(err error) (uint64, bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := staleEpoch.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	epoch, err := strconv.ParseUint(m[1], 10, 64)
+	return epoch, err == nil
+}
+
+// FenceArgs tells a worker the cluster epoch, so requests routed at older
+// epochs are refused from then on.
+type FenceArgs struct {
+	Epoch uint64
+}
+
+// FenceReply reports the epoch the worker is at.
+type FenceReply struct {
+	Epoch uint64
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrSnapshotTooOld is returned for a snapshot read older than versions the
+// worker has already garbage collected.
+var ErrSnapshotTooOld = errors.New("snapshot too old")
+
+// IsSnapshotTooOld reports whether err is, or wraps, a snapshot-too-old refusal.
+func IsSnapshotTooOld(err error) bool {
+	return err != nil && (errors.Is(err, ErrSnapshotTooOld) || strings.Contains(err.Error(), ErrSnapshotTooOld.Error()))
+}
+
+// CollectArgs tells a worker it may drop superseded versions no snapshot at
+// or after Before can read.
+type CollectArgs struct {
+	Before int64
+	Epoch  uint64 // Cluster epoch of the master sending it
+}
+
+// CollectReply reports what a collection dropped.
+type CollectReply struct {
+	Dropped int
+}
@@ -1,30 +1,147 @@
 package common
 
+import (
+	"customise-db/crdt"
+	"time"
+)
+
+// Consistency levels a Put or Get may request, overriding the cluster's mode.
+const (
+	ConsistencyOne      = "one"       // One replica acknowledges; the rest catch up in the background
+	ConsistencyQuorum   = "quorum"    // W (or R) replicas, a majority by default
+	ConsistencyAll      = "all"       // Every replica
+	ConsistencyTailRead = "tail-read" // Chain replication: write through the chain, read the committed tail
+	ConsistencyAny      = "any"       // Whichever replica is reachable first
+	ConsistencyPrimary  = "primary"   // The key's leased primary orders writes and serves reads
+)
+
+// ValidConsistency reports whether level is a known consistency level ("" means the cluster default).
+func ValidConsistency(level string) bool {
+	switch level {
+	case "", ConsistencyOne, ConsistencyQuorum, ConsistencyAll, ConsistencyTailRead, ConsistencyAny, ConsistencyPrimary:
+		return true
+	}
+	return false
+}
+
 // PutArgs holds arguments for the Put RPC.
 type PutArgs struct {
 	Key       string
 	Value     string
-	ForwardTo string // Address of the next worker to replicate to (for Chain Replication)
+	ForwardTo string    // Address of the next worker to replicate to (for Chain Replication)
+	Deadline  time.Time // Absolute deadline for the whole write, propagated down the chain (zero = none)
+	Version   int64     // Write timestamp assigned by the master; older versions never overwrite newer ones
+	W         int       // Write quorum override for quorum mode (0 = cluster default)
+
+	Consistency string        // Requested consistency level ("" = cluster default)
+	Namespace   string        // Namespace (bucket) the key lives in ("" = default)
+	TTL         time.Duration // Time to live (0 = namespace default, or no expiry)
+
+	ChainID string // Chain the write streams through (pipelined chain replication)
+	Seq     uint64 // Position in the chain's stream, assigned by the head (0 = not yet sequenced)
+
+	Primary bool     // Primary-backup mode: the receiver must hold a primary lease and orders the write
+	Backups []string // Primary-backup mode: replicas the primary copies the write to before acknowledging
+
+	Epoch uint64 // Cluster epoch the master routed the write by (0 = unfenced); see epoch.go
+
+	ClientID  string // Client the write came from, for deduplicating its retries
+	RequestID string // Unique per client; a repeat is answered from the worker's dedup table ("" = not deduplicated)
+	Restamp   bool   // The master restamped this write: it supersedes an attempt of the same request at an older version
+
+	Incr bool  // Add By to the stored integer instead of writing Value; see counter.go
+	By   int64 // Amount an Incr adds (negative to decrement); kept on the write it resolves into
+
+	CRDT string   // CRDT type (see the crdt package): Value is a state the worker merges into its own
+	Op   *crdt.Op // Applied to the stored CRDT by the first worker, which turns the write into a merge of the result; see crdt.go
 }
 
 // PutReply holds the reply for the Put RPC.
 type PutReply struct {
-	// No return value needed for Put, but we could add an error code here if we wanted.
+	Consistency string // Consistency level the write was actually performed at
+	Committed   bool   // Set by the last node to apply the write (the chain tail) and passed back up
+	Version     int64  // Version the write was stored at (a primary may order it after the master's)
+	Value       string // Incr: the counter's value after the increment; Op: the CRDT's state after it
 }
 
 // GetArgs holds arguments for the Get RPC.
 type GetArgs struct {
-	Key string
+	Key      string
+	Deadline time.Time // Absolute deadline for the read (zero = none)
+	R        int       // Read quorum override for quorum mode (0 = cluster default)
+
+	Consistency string // Requested consistency level ("" = cluster default)
+	Namespace   string // Namespace (bucket) the key lives in ("" = default)
+	Tail        string // Chain tail to consult if the key is dirty here (CRAQ reads)
+	Primary     bool   // Primary-backup mode: serve only while holding a primary lease
+	Epoch       uint64 // Cluster epoch the master routed the read by (0 = unfenced)
+	AsOf        int64  // Snapshot read: the newest version at or below this timestamp (0 = latest)
 }
 
 // GetReply holds the reply for the Get RPC.
 type GetReply struct {
-	Value string
-	Found bool
+	Value   string
+	Found   bool
+	Version int64         // Version of the value returned (0 if unversioned)
+	TTL     time.Duration // Remaining time to live (0 = no expiry)
+	CRDT    string        // CRDT type, if the value is a CRDT state
+
+	Consistency string // Consistency level the read was actually performed at
+}
+
+// VersionArgs asks a chain tail for the committed version of a key (CRAQ).
+type VersionArgs struct {
+	Key      string
+	Deadline time.Time
+	Epoch    uint64 // Cluster epoch of the read that asks
+}
+
+// VersionReply holds the committed version of a key.
+type VersionReply struct {
+	Version int64
+	Found   bool
+}
+
+// ChainAckArgs flows from the tail back up a chain. It is cumulative: every
+// write up to and including Seq has reached the tail, except those in Failed,
+// which failed on the way and must not be committed.
+type ChainAckArgs struct {
+	ChainID string
+	Seq     uint64
+	Failed  map[uint64]string // Sequence number -> error
+	Epoch   uint64            // Highest cluster epoch the acking worker has seen
+}
+
+// ChainAckReply is empty; acks are fire-and-forget.
+type ChainAckReply struct{}
+
+// SpliceArgs tells a worker that the chain manager has spliced Failed out of
+// every chain, so writes it forwarded to Failed will never be acknowledged.
+type SpliceArgs struct {
+	Failed string
+	Epoch  uint64 // Cluster epoch the master spliced the chains at
+}
+
+// SpliceReply reports how the worker settled its writes stuck behind Failed.
+type SpliceReply struct {
+	Committed int       // Dirty writes committed because this worker is now their tail
+	Pending   []PutArgs // Dirty writes that must be re-driven through the repaired chain
 }
 
-// StatsArgs represents a request for worker statistics.
-type StatsArgs struct{}
+// LeaseArgs grants the receiving worker a primary lease for Duration from now.
+type LeaseArgs struct {
+	Duration time.Duration
+	Epoch    uint64 // Cluster epoch of the master granting it
+}
+
+// LeaseReply is empty; a successful call means the lease was taken.
+type LeaseReply struct{}
+
+// StatsArgs represents a request for worker statistics. Stats are never
+// refused, but a newer Epoch is recorded like any other.
+type StatsArgs struct {
+	Epoch uint64
+}
 
 // StatsReply holds worker metrics for auto-scaling decisions.
 type StatsReply struct {
@@ -33,4 +150,27 @@ type StatsReply struct {
 	MaxKeys     int      // Key limit
 	MaxLoad     int      // Load limit
 	Keys        []string // List of all keys stored
+	CleanReads  int      // Reads served from a clean (committed) version
+	DirtyReads  int      // Reads of dirty keys resolved by asking the tail
+	DirtyKeys   int      // Keys with uncommitted chain writes
+	Zone        string   // Zone/rack label the worker was started with ("" = none)
+	Epoch       uint64   // Highest cluster epoch the worker has seen
+	Duplicates  int      // Retried writes answered from the dedup table instead of applied again
+	Prepared    int      // Transactions prepared here and awaiting the coordinator's decision
+	OldVersions int      // Superseded versions kept for snapshot reads
+	CRDTKeys    []string // Keys holding CRDTs, for anti-entropy
+	MaxVersion  int64    // Highest version stored; a starting master's clock begins above it
+}
+
+// DeleteArgs holds arguments for the Delete RPC.
+type DeleteArgs struct {
+	Key      string
+	Deadline time.Time // Absolute deadline for the delete (zero = none)
+	Epoch    uint64    // Cluster epoch the master routed the delete by (0 = unfenced)
+	Version  int64     // Refuse the delete if the worker stores a newer version than this (0 = unconditional)
+}
+
+// DeleteReply holds the reply for the Delete RPC.
+type DeleteReply struct {
+	Found bool // Whether the key existed before the delete
 }
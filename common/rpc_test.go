@@ -1,6 +1,9 @@
 package common
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestPutArgs(t *testing.T) {
 	args := PutArgs{
@@ -43,3 +46,40 @@ func TestGetReply(t *testing.T) {
 		t.Errorf("Expected Found true, got false")
 	}
 }
+
+func TestFailedChainLink(t *testing.T) {
+	err := ChainLinkError("localhost:8002", errors.New("connection refused"))
+	addr, ok := FailedChainLink(errors.New("rpc: " + err.Error()))
+	if !ok || addr != "localhost:8002" {
+		t.Errorf("Expected failed link localhost:8002, got %q (%v)", addr, ok)
+	}
+	if _, ok := FailedChainLink(errors.New("node full: max keys 10 reached")); ok {
+		t.Errorf("Expected an unrelated error not to name a link")
+	}
+}
+
+func TestChainMembers(t *testing.T) {
+	id := ChainID(7, []string{"localhost:8001", "localhost:8002"})
+	members := ChainMembers(id)
+	if len(members) != 2 || members[0] != "localhost:8001" || members[1] != "localhost:8002" {
+		t.Errorf("Expected both members back from %q, got %v", id, members)
+	}
+	if ChainMembers("not-a-chain") != nil {
+		t.Errorf("Expected no members from a malformed ID")
+	}
+}
+
+func TestStaleEpoch(t *testing.T) {
+	err := StaleRoutingError(3, 5)
+	if !IsStaleRouting(err) {
+		t.Errorf("Expected %v to be a stale-routing error", err)
+	}
+	// As it arrives from a worker: flattened into an rpc.ServerError string.
+	remote := errors.New("put k: " + err.Error())
+	if epoch, ok := StaleEpoch(remote); !ok || epoch != 5 || !IsStaleRouting(remote) {
+		t.Errorf("Expected epoch 5 from %q, got %d (ok=%v)", remote, epoch, ok)
+	}
+	if _, ok := StaleEpoch(errors.New("connection refused")); ok {
+		t.Errorf("Expected no epoch from an unrelated error")
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrTxnConflict is returned when a transaction cannot prepare because another
+// transaction holds one of its keys; the caller may retry it from the start.
+var ErrTxnConflict = errors.New("transaction conflict")
+
+// IsTxnConflict reports whether err is, or wraps, a transaction conflict.
+// Like IsStaleRouting it also matches the rpc.ServerError string a worker sends.
+func IsTxnConflict(err error) bool {
+	return err != nil && (errors.Is(err, ErrTxnConflict) || strings.Contains(err.Error(), ErrTxnConflict.Error()))
+}
+
+// TxnWrite is one write of a transaction, as staged on a participant worker.
+type TxnWrite struct {
+	Key     string // Storage key
+	Value   string
+	Version int64         // Commit version; every write of a transaction shares it
+	TTL     time.Duration // Time to live (0 = no expiry)
+}
+
+// PrepareArgs asks a participant to lock and stage its share of a
+// transaction's writes (phase one of two-phase commit).
+type PrepareArgs struct {
+	TxnID    string
+	Writes   []TxnWrite
+	Reads    []string  // Watched keys to lock and report the version of, without writing
+	Deadline time.Time // Absolute deadline for the prepare (zero = none)
+	Epoch    uint64    // Cluster epoch the master routed the transaction by
+}
+
+// PrepareReply is a participant's yes vote.
+type PrepareReply struct {
+	Versions map[string]int64 // Key -> version stored before the transaction (0 = absent), written and watched keys
+}
+
+// DecideArgs delivers the coordinator's decision (phase two). A commit carries
+// the writes again, so a participant that lost its staged copy in a crash can
+// still apply them.
+type DecideArgs struct {
+	TxnID  string
+	Commit bool
+	Writes []TxnWrite
+	Epoch  uint64 // Cluster epoch of the master delivering the decision
+}
+
+// DecideReply acknowledges a decision.
+type DecideReply struct{}
+
+// TxnArgs names a transaction on the master.
+type TxnArgs struct {
+	TxnID    string
+	ReadOnly bool // Begin: a snapshot transaction, reading every key as of one timestamp
+}
+
+// TxnReply is the master's answer to Begin, Commit and Abort.
+type TxnReply struct {
+	TxnID    string
+	Version  int64 // Commit: version the writes were stored at
+	Snapshot int64 // Begin of a read-only transaction: the timestamp it reads at
+}
+
+// TxnPutArgs buffers a write in a transaction until it commits.
+type TxnPutArgs struct {
+	TxnID     string
+	Key       string
+	Value     string
+	Namespace string        // Namespace (bucket) the key lives in ("" = default)
+	TTL       time.Duration // Time to live (0 = namespace default, or no expiry)
+}
+
+// TxnGetArgs reads a key inside a transaction: its own buffered write if it
+// has one, else the committed value.
+type TxnGetArgs struct {
+	TxnID     string
+	Key       string
+	Namespace string
+}
+
+// ExecArgs is an optimistic transaction in one call, like Redis's
+// WATCH/MULTI/EXEC: the writes are applied atomically if every watched key is
+// still at the version the client read, and not at all otherwise.
+type ExecArgs struct {
+	Namespace string           `json:"ns"`
+	Watch     map[string]int64 `json:"watch"` // Key -> version the client read (0 = absent)
+	Writes    []ExecWrite      `json:"writes"`
+}
+
+// ExecWrite is one write of an ExecArgs batch.
+type ExecWrite struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
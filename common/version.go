@@ -0,0 +1,40 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var staleVersion = regexp.MustCompile(`is older than the stored v(\d+)`)
+
+// ErrStaleVersion is returned for a write older than the version a worker
+// already stores: it was not applied. The master restamps it and retries.
+var ErrStaleVersion = errors.New("stale version")
+
+// StaleVersionError rejects a write of key at version while the worker stores
+// current. Like StaleRoutingError it travels as an rpc.ServerError string, so
+// the master recovers current with StaleVersion.
+func StaleVersionError(key string, version, current int64) error {
+	return fmt.Errorf("put %s: %w: v%d is older than the stored v%d", key, ErrStaleVersion, version, current)
+}
+
+// IsStaleVersion reports whether err is, or wraps, a stale-version rejection.
+func IsStaleVersion(err error) bool {
+	return err != nil && (errors.Is(err, ErrStaleVersion) || strings.Contains(err.Error(), ErrStaleVersion.Error()))
+}
+
+// StaleVersion returns the stored version named by a StaleVersionError anywhere in err.
+func StaleVersion(err error) (int64, bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := staleVersion.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	v, convErr := strconv.ParseInt(m[1], 10, 64)
+	return v, convErr == nil
+}
@@ -0,0 +1,114 @@
+package crdt
+
+import (
+	"fmt"
+	"math"
+)
+
+// GCounter is a grow-only counter: each replica counts its own increments,
+// and a merge keeps the larger count for every replica.
+type GCounter struct {
+	Counts map[string]uint64 `json:"counts,omitempty"`
+}
+
+func (g *GCounter) Apply(op Op, node string, now int64) error {
+	if op.Kind != OpIncr {
+		return unsupported(TypeGCounter, op)
+	}
+	if op.By < 0 {
+		return fmt.Errorf("%s cannot be decremented", TypeGCounter)
+	}
+	g.add(node, uint64(op.By))
+	return nil
+}
+
+func (g *GCounter) add(node string, n uint64) {
+	if g.Counts == nil {
+		g.Counts = make(map[string]uint64)
+	}
+	if g.Counts[node] > math.MaxUint64-n {
+		g.Counts[node] = math.MaxUint64 // Saturate rather than wrap
+		return
+	}
+	g.Counts[node] += n
+}
+
+func (g *GCounter) Merge(other CRDT) error {
+	o, ok := other.(*GCounter)
+	if !ok {
+		return mismatch(TypeGCounter, other)
+	}
+	g.merge(o)
+	return nil
+}
+
+func (g *GCounter) merge(o *GCounter) {
+	for node, n := range o.Counts {
+		if n > g.Counts[node] {
+			if g.Counts == nil {
+				g.Counts = make(map[string]uint64)
+			}
+			g.Counts[node] = n
+		}
+	}
+}
+
+// total sums the counts, saturating rather than wrapping.
+func (g *GCounter) total() uint64 {
+	var sum uint64
+	for _, n := range g.Counts {
+		if sum > math.MaxUint64-n {
+			return math.MaxUint64
+		}
+		sum += n
+	}
+	return sum
+}
+
+func (g *GCounter) Value() interface{} {
+	return g.total()
+}
+
+// PNCounter is a pair of grow-only counters, one for increments and one for
+// decrements.
+type PNCounter struct {
+	P GCounter `json:"p"`
+	N GCounter `json:"n"`
+}
+
+func (c *PNCounter) Apply(op Op, node string, now int64) error {
+	if op.Kind != OpIncr {
+		return unsupported(TypePNCounter, op)
+	}
+	if op.By >= 0 {
+		c.P.add(node, uint64(op.By))
+	} else {
+		c.N.add(node, uint64(-(op.By+1))+1) // -MinInt64 does not fit in an int64
+	}
+	return nil
+}
+
+func (c *PNCounter) Merge(other CRDT) error {
+	o, ok := other.(*PNCounter)
+	if !ok {
+		return mismatch(TypePNCounter, other)
+	}
+	c.P.merge(&o.P)
+	c.N.merge(&o.N)
+	return nil
+}
+
+// Value is increments minus decrements, clamped to the int64 range.
+func (c *PNCounter) Value() interface{} {
+	p, n := c.P.total(), c.N.total()
+	if p >= n {
+		if p-n > math.MaxInt64 {
+			return int64(math.MaxInt64)
+		}
+		return int64(p - n)
+	}
+	if n-p > math.MaxInt64 {
+		return int64(math.MinInt64)
+	}
+	return -int64(n - p)
+}
@@ -0,0 +1,116 @@
+// Package crdt implements the conflict-free replicated data types workers can
+// store: replicas update their own copies independently and merge each
+// other's states, and every replica that has seen the same updates holds the
+// same state whatever order they arrived in.
+//
+// States travel and are stored as JSON. Encoding is canonical, so two
+// replicas hold equal states exactly when their encodings are equal.
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Type names, as given on the wire and stored alongside a key.
+const (
+	TypeGCounter    = "g-counter"    // Grow-only counter
+	TypePNCounter   = "pn-counter"   // Counter that can also be decremented
+	TypeORSet       = "or-set"       // Observed-remove set: an add concurrent with a remove wins
+	TypeLWWRegister = "lww-register" // Last-writer-wins register
+	TypeMVRegister  = "mv-register"  // Multi-value register: concurrent sets are all kept
+)
+
+// Operation kinds.
+const (
+	OpIncr   = "incr"   // Counters: add By (negative to decrement a pn-counter)
+	OpAdd    = "add"    // or-set: add Value
+	OpRemove = "remove" // or-set: remove Value, as far as this replica has seen it
+	OpSet    = "set"    // Registers: set Value
+)
+
+// Op is an update applied at one replica.
+type Op struct {
+	Kind  string
+	Value string
+	By    int64
+}
+
+// CRDT is the state of one replicated data type.
+type CRDT interface {
+	// Apply performs op as replica node. now is the replica's clock, in
+	// nanoseconds, for types that order updates by time.
+	Apply(op Op, node string, now int64) error
+	// Merge folds in other, which must be of the same type.
+	Merge(other CRDT) error
+	// Value is the state as clients see it, ready for JSON.
+	Value() interface{}
+}
+
+// Valid reports whether typ names a supported type.
+func Valid(typ string) bool {
+	_, err := New(typ)
+	return err == nil
+}
+
+// New returns the empty state of type typ.
+func New(typ string) (CRDT, error) {
+	switch typ {
+	case TypeGCounter:
+		return &GCounter{}, nil
+	case TypePNCounter:
+		return &PNCounter{}, nil
+	case TypeORSet:
+		return &ORSet{}, nil
+	case TypeLWWRegister:
+		return &LWWRegister{}, nil
+	case TypeMVRegister:
+		return &MVRegister{}, nil
+	}
+	return nil, fmt.Errorf("unknown CRDT type %q", typ)
+}
+
+// Decode parses a state of type typ. The empty string is the empty state.
+func Decode(typ, state string) (CRDT, error) {
+	c, err := New(typ)
+	if err != nil || state == "" {
+		return c, err
+	}
+	if err := json.Unmarshal([]byte(state), c); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", typ, err)
+	}
+	return c, nil
+}
+
+// Encode returns the canonical encoding of c.
+func Encode(c CRDT) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Merge merges two encoded states of type typ.
+func Merge(typ, a, b string) (string, error) {
+	x, err := Decode(typ, a)
+	if err != nil {
+		return "", err
+	}
+	y, err := Decode(typ, b)
+	if err != nil {
+		return "", err
+	}
+	if err := x.Merge(y); err != nil {
+		return "", err
+	}
+	return Encode(x)
+}
+
+func mismatch(want string, got CRDT) error {
+	return fmt.Errorf("cannot merge %T into a %s", got, want)
+}
+
+func unsupported(typ string, op Op) error {
+	return fmt.Errorf("%s does not support %q", typ, op.Kind)
+}
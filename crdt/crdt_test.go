@@ -0,0 +1,95 @@
+package crdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+// converge applies each replica's ops to its own copy of an empty state, then
+// merges the copies in both orders and checks they agree.
+func converge(t *testing.T, typ string, a, b []Op) interface{} {
+	t.Helper()
+	x, _ := New(typ)
+	y, _ := New(typ)
+	for i, op := range a {
+		if err := x.Apply(op, "a", int64(i+1)); err != nil {
+			t.Fatalf("Apply %+v: %v", op, err)
+		}
+	}
+	for i, op := range b {
+		if err := y.Apply(op, "b", int64(i+1)); err != nil {
+			t.Fatalf("Apply %+v: %v", op, err)
+		}
+	}
+	xs, _ := Encode(x)
+	ys, _ := Encode(y)
+	ab, err := Merge(typ, xs, ys)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	ba, _ := Merge(typ, ys, xs)
+	if ab != ba {
+		t.Fatalf("Merges disagree:\n%s\n%s", ab, ba)
+	}
+	again, _ := Merge(typ, ab, xs)
+	if again != ab {
+		t.Errorf("Expected merging a state already included to change nothing, got %s", again)
+	}
+	merged, _ := Decode(typ, ab)
+	return merged.Value()
+}
+
+func TestCounters_KeepEveryReplicasUpdates(t *testing.T) {
+	incr := func(by int64) Op { return Op{Kind: OpIncr, By: by} }
+	if got := converge(t, TypeGCounter, []Op{incr(2), incr(3)}, []Op{incr(4)}); got != uint64(9) {
+		t.Errorf("Expected g-counter 9, got %v", got)
+	}
+	if got := converge(t, TypePNCounter, []Op{incr(5), incr(-7)}, []Op{incr(-1)}); got != int64(-3) {
+		t.Errorf("Expected pn-counter -3, got %v", got)
+	}
+	if err := (&GCounter{}).Apply(incr(-1), "a", 1); err == nil {
+		t.Errorf("Expected a g-counter to refuse a decrement")
+	}
+}
+
+func TestORSet_ConcurrentAddSurvivesRemove(t *testing.T) {
+	add := func(v string) Op { return Op{Kind: OpAdd, Value: v} }
+	remove := func(v string) Op { return Op{Kind: OpRemove, Value: v} }
+	// a adds and removes x; b adds x concurrently, and y, which it then removes.
+	got := converge(t, TypeORSet, []Op{add("x"), remove("x"), add("z")}, []Op{add("x"), add("y"), remove("y")})
+	if want := []string{"x", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestRegisters_ResolveConcurrentSets(t *testing.T) {
+	set := func(v string) Op { return Op{Kind: OpSet, Value: v} }
+	// b's set is at a later stamp (2) than a's (1).
+	if got := converge(t, TypeLWWRegister, []Op{set("a1")}, []Op{set("b1"), set("b2")}); got != "b2" {
+		t.Errorf("Expected the latest set to win, got %v", got)
+	}
+	if got := converge(t, TypeMVRegister, []Op{set("a1"), set("a2")}, []Op{set("b1")}); !reflect.DeepEqual(got, []string{"a2", "b1"}) {
+		t.Errorf("Expected both concurrent values kept, got %v", got)
+	}
+
+	// A set made after seeing both values supersedes them.
+	x, _ := New(TypeMVRegister)
+	x.Apply(set("a"), "a", 1)
+	y, _ := New(TypeMVRegister)
+	y.Apply(set("b"), "b", 1)
+	x.Merge(y)
+	x.Apply(set("resolved"), "a", 2)
+	y.Merge(x)
+	if got := y.Value(); !reflect.DeepEqual(got, []string{"resolved"}) {
+		t.Errorf("Expected the resolving set to replace both, got %v", got)
+	}
+}
+
+func TestMerge_RefusesOtherTypes(t *testing.T) {
+	if err := (&GCounter{}).Merge(&PNCounter{}); err == nil {
+		t.Errorf("Expected merging different types to fail")
+	}
+	if _, err := New("bloom-filter"); err == nil {
+		t.Errorf("Expected an unknown type to be refused")
+	}
+}
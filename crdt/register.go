@@ -0,0 +1,134 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LWWRegister holds the value of the latest set, by the setting replica's
+// clock; the replica name breaks ties.
+type LWWRegister struct {
+	Val   string `json:"value"`
+	Stamp int64  `json:"stamp"`
+	Node  string `json:"node,omitempty"`
+}
+
+func (r *LWWRegister) Apply(op Op, node string, now int64) error {
+	if op.Kind != OpSet {
+		return unsupported(TypeLWWRegister, op)
+	}
+	if now <= r.Stamp {
+		now = r.Stamp + 1 // A set always supersedes what its replica has seen
+	}
+	r.Val, r.Stamp, r.Node = op.Value, now, node
+	return nil
+}
+
+func (r *LWWRegister) Merge(other CRDT) error {
+	o, ok := other.(*LWWRegister)
+	if !ok {
+		return mismatch(TypeLWWRegister, other)
+	}
+	if o.Stamp > r.Stamp || (o.Stamp == r.Stamp && o.Node > r.Node) {
+		*r = *o
+	}
+	return nil
+}
+
+func (r *LWWRegister) Value() interface{} {
+	return r.Val
+}
+
+// MVRegister keeps every value set concurrently. Each value carries a version
+// vector; a set supersedes every value its replica has seen, and a merge drops
+// the values another one supersedes.
+type MVRegister struct {
+	Entries []MVEntry `json:"entries,omitempty"`
+}
+
+// MVEntry is one of a multi-value register's concurrent values.
+type MVEntry struct {
+	Val   string            `json:"value"`
+	Clock map[string]uint64 `json:"clock"`
+}
+
+// dominates reports whether a has seen everything b has.
+func dominates(a, b map[string]uint64) bool {
+	for node, n := range b {
+		if a[node] < n {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MVRegister) Apply(op Op, node string, now int64) error {
+	if op.Kind != OpSet {
+		return unsupported(TypeMVRegister, op)
+	}
+	clock := make(map[string]uint64)
+	for _, e := range r.Entries {
+		for n, c := range e.Clock {
+			if c > clock[n] {
+				clock[n] = c
+			}
+		}
+	}
+	clock[node]++
+	r.Entries = []MVEntry{{Val: op.Value, Clock: clock}}
+	return nil
+}
+
+func (r *MVRegister) Merge(other CRDT) error {
+	o, ok := other.(*MVRegister)
+	if !ok {
+		return mismatch(TypeMVRegister, other)
+	}
+	all := append(append([]MVEntry(nil), r.Entries...), o.Entries...)
+	var kept []MVEntry
+	for i, e := range all {
+		superseded := false
+		for j, f := range all {
+			if i == j || !dominates(f.Clock, e.Clock) {
+				continue
+			}
+			// Equal clocks are the same set seen twice: keep the first copy.
+			if !dominates(e.Clock, f.Clock) || j < i {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			kept = append(kept, e)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return clockKey(kept[i]) < clockKey(kept[j]) })
+	r.Entries = kept
+	return nil
+}
+
+// clockKey orders entries canonically.
+func clockKey(e MVEntry) string {
+	nodes := make([]string, 0, len(e.Clock))
+	for n := range e.Clock {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	var b strings.Builder
+	b.WriteString(e.Val)
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "\x00%s=%d", n, e.Clock[n])
+	}
+	return b.String()
+}
+
+// Value is the sorted list of concurrent values.
+func (r *MVRegister) Value() interface{} {
+	values := make([]string, 0, len(r.Entries))
+	for _, e := range r.Entries {
+		values = append(values, e.Val)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,98 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ORSet is an observed-remove set. Every add tags the element with a tag
+// unique to that add; a remove tombstones only the tags its replica has seen,
+// so an add made concurrently elsewhere survives it. Tombstones are kept for
+// good, since a replica may yet send the tags they cancel.
+type ORSet struct {
+	Elements map[string]map[string]bool `json:"elements,omitempty"` // Element -> live tags
+	Removed  map[string]bool            `json:"removed,omitempty"`  // Tombstoned tags
+	Adds     map[string]uint64          `json:"adds,omitempty"`     // Adds made by each replica, numbering its tags
+}
+
+func (s *ORSet) Apply(op Op, node string, now int64) error {
+	switch op.Kind {
+	case OpAdd:
+		if s.Adds == nil {
+			s.Adds = make(map[string]uint64)
+		}
+		s.Adds[node]++
+		s.tag(op.Value, fmt.Sprintf("%s:%d", node, s.Adds[node]))
+	case OpRemove:
+		for tag := range s.Elements[op.Value] {
+			if s.Removed == nil {
+				s.Removed = make(map[string]bool)
+			}
+			s.Removed[tag] = true
+		}
+		delete(s.Elements, op.Value)
+	default:
+		return unsupported(TypeORSet, op)
+	}
+	return nil
+}
+
+func (s *ORSet) tag(element, tag string) {
+	if s.Removed[tag] {
+		return
+	}
+	if s.Elements == nil {
+		s.Elements = make(map[string]map[string]bool)
+	}
+	if s.Elements[element] == nil {
+		s.Elements[element] = make(map[string]bool)
+	}
+	s.Elements[element][tag] = true
+}
+
+func (s *ORSet) Merge(other CRDT) error {
+	o, ok := other.(*ORSet)
+	if !ok {
+		return mismatch(TypeORSet, other)
+	}
+	for tag := range o.Removed {
+		if s.Removed == nil {
+			s.Removed = make(map[string]bool)
+		}
+		s.Removed[tag] = true
+	}
+	for element, tags := range o.Elements {
+		for tag := range tags {
+			s.tag(element, tag)
+		}
+	}
+	for element, tags := range s.Elements {
+		for tag := range tags {
+			if s.Removed[tag] {
+				delete(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			delete(s.Elements, element)
+		}
+	}
+	for node, n := range o.Adds {
+		if n > s.Adds[node] {
+			if s.Adds == nil {
+				s.Adds = make(map[string]uint64)
+			}
+			s.Adds[node] = n
+		}
+	}
+	return nil
+}
+
+// Value is the sorted list of elements.
+func (s *ORSet) Value() interface{} {
+	elements := make([]string, 0, len(s.Elements))
+	for element := range s.Elements {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	return elements
+}
@@ -7,7 +7,7 @@ MODE=${1:-chain}
 
 cleanup() {
     echo "Cleaning up..."
-    pkill -f "bin/worker"; pkill -f "bin/master"; rm -rf logs/*.log
+    pkill -f "bin/worker"; pkill -f "bin/master"; rm -rf logs/*.log data/replication data/ranges.json data/txn.log
     sleep 2
 }
 trap cleanup EXIT
@@ -18,11 +18,11 @@ go build -o bin/worker ./cmd/worker
 
 echo "Starting 5 Workers..."
 mkdir -p logs
-./bin/worker 8001 > logs/worker1.log 2>&1 &
-./bin/worker 8002 > logs/worker2.log 2>&1 &
-./bin/worker 8003 > logs/worker3.log 2>&1 &
-./bin/worker 8004 > logs/worker4.log 2>&1 &
-./bin/worker 8005 > logs/worker5.log 2>&1 &
+./bin/worker -zone=zone-a 8001 > logs/worker1.log 2>&1 &
+./bin/worker -zone=zone-a 8002 > logs/worker2.log 2>&1 &
+./bin/worker -zone=zone-b 8003 > logs/worker3.log 2>&1 &
+./bin/worker -zone=zone-b 8004 > logs/worker4.log 2>&1 &
+./bin/worker -zone=zone-c 8005 > logs/worker5.log 2>&1 &
 
 sleep 1
 
@@ -39,7 +39,7 @@ echo -e "\n2. Reading 'user:100'..."
 curl "http://localhost:8080/get?key=user:100"
 
 echo -e "\n3. Killing Worker 8001 (A potential replica)..."
-pkill -f "bin/worker 8001"
+pkill -f "bin/worker -zone=zone-a 8001"
 
 echo "4. Reading 'user:100' again (Should still work via failover/replication!)"
 curl "http://localhost:8080/get?key=user:100"
@@ -13,6 +13,7 @@ test_mode() {
     # Cleanup previous runs
     pkill -f bin/worker 2>/dev/null
     pkill -f bin/master 2>/dev/null
+    rm -rf data/replication data/ranges.json data/txn.log
     sleep 1
 
     # Start Workers
@@ -61,5 +62,6 @@ test_mode "sync"
 test_mode "async"
 test_mode "chain"
 test_mode "quorum"
+test_mode "primary"
 
 echo "ALL TESTS PASSED"
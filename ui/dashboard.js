@@ -6,6 +6,9 @@ let state = {
   mode: 'sync',
   stats: {}, // Map address -> stat object
   config: { replicas: 20 },
+  breakers: {}, // Map address -> circuit breaker stat
+  replication: {}, // Map address -> async replication queue stat
+  load: {}, // Map address -> read latency / in-flight stat
   selectedNode: null // Address of selected node
 };
 
@@ -14,6 +17,7 @@ const COLOR_PRIMARY = '#00f0ff';
 const COLOR_NODE_DEFAULT = '#4c5c75';
 const COLOR_NODE_ACTIVE = '#00ff9d';
 const COLOR_NODE_SELECTED = '#ff0055'; // Highlight color
+const COLOR_BREAKER = { open: '#ff6a00', 'half-open': '#ffbf00' }; // Nodes being shed
 const R_RING = 200;
 const R_NODE = 25;
 
@@ -57,7 +61,8 @@ function drawViz() {
       id: addr,
       x: Math.cos(angle) * R_RING,
       y: Math.sin(angle) * R_RING,
-      stat: state.stats[addr] || { key_count: 0, request_rate: 0, keys: [] }
+      stat: state.stats[addr] || { key_count: 0, request_rate: 0, keys: [] },
+      breaker: breakerState(addr)
     };
   });
 
@@ -133,7 +138,8 @@ function drawViz() {
     .attr('transform', d => `translate(${d.x},${d.y})`);
 
   nodesUpdate.select('circle.inner')
-    .attr('stroke', d => state.selectedNode === d.id ? COLOR_NODE_SELECTED : COLOR_PRIMARY)
+    .attr('stroke', d => state.selectedNode === d.id ? COLOR_NODE_SELECTED : (COLOR_BREAKER[d.breaker] || COLOR_PRIMARY))
+    .attr('stroke-dasharray', d => d.breaker === 'closed' ? null : '4 3')
     .attr('fill', d => state.selectedNode === d.id ? 'rgba(255, 0, 85, 0.2)' : '#1a1a2e');
 
   nodesUpdate.select('circle.outer')
@@ -214,17 +220,39 @@ async function fetchStatus() {
     const statsMap = {};
     data.stats.forEach(s => statsMap[s.address] = s);
 
+    const breakersMap = {};
+    (data.breakers || []).forEach(b => breakersMap[b.address] = b);
+
     // Persist selected node if it still exists
     let newSelected = state.selectedNode;
     if (newSelected && !data.nodes.includes(newSelected)) {
       newSelected = null;
     }
 
+    if (state.epoch && data.epoch && data.epoch.epoch !== state.epoch.epoch) {
+      log(`Epoch ${data.epoch.epoch}: ${data.epoch.reason}`);
+    }
+    const txns = data.transactions;
+    if (txns && state.txns && txns.unfinished !== state.txns.unfinished) {
+      log(`Transactions awaiting delivery of their decision: ${txns.unfinished}`);
+    }
+
     state = {
       nodes: data.nodes,
       mode: data.mode,
       stats: statsMap,
       config: data.config,
+      breakers: breakersMap,
+      quorum: data.quorum,
+      chain: data.chain || { failed: [], joining: [] },
+      leases: data.leases,
+      hedging: data.hedging,
+      placement: data.placement,
+      zones: data.zones,
+      epoch: data.epoch,
+      txns: data.transactions,
+      load: Object.fromEntries(((data.reads && data.reads.workers) || []).map(l => [l.address, l])),
+      replication: Object.fromEntries((data.replication || []).map(r => [r.address, r])),
       selectedNode: newSelected
     };
 
@@ -238,7 +266,8 @@ async function fetchStatus() {
 
 function updateHUD() {
   // Mode
-  document.getElementById('mode-display').innerText = state.mode.toUpperCase();
+  const e = state.epoch;
+  document.getElementById('mode-display').innerText = state.mode.toUpperCase() + (e ? ` // EPOCH ${e.epoch}${e.fenced ? ' (STALE)' : ''}` : '');
 
   // Toggles
   document.querySelectorAll('#mode-toggles button').forEach(btn => {
@@ -249,10 +278,24 @@ function updateHUD() {
   // CAP
   const capInfo = document.getElementById('cap-info');
   const capLed = document.getElementById('cap-led');
+  const q = state.quorum;
   if (state.mode === 'async') {
     capInfo.innerHTML = `<strong>AP MODE</strong>: High Availability. Consistency eventual.`;
     capLed.style.background = 'var(--warning)';
     capLed.style.boxShadow = '0 0 8px var(--warning)';
+  } else if (state.mode === 'quorum' && q && !q.strong) {
+    capInfo.innerHTML = `<strong>AP MODE</strong>: R=${q.r} W=${q.w} N=${q.n}. R+W&le;N, reads may miss the latest write.`;
+    capLed.style.background = 'var(--warning)';
+    capLed.style.boxShadow = '0 0 8px var(--warning)';
+  } else if (state.mode === 'quorum' && q) {
+    capInfo.innerHTML = `<strong>CP MODE</strong>: R=${q.r} W=${q.w} N=${q.n}. R+W&gt;N, every read sees the latest write.`;
+    capLed.style.background = 'var(--success)';
+    capLed.style.boxShadow = '0 0 8px var(--success)';
+  } else if (state.mode === 'primary' && state.leases) {
+    const deposed = state.leases.workers.filter(l => l.state !== 'active').length;
+    capInfo.innerHTML = `<strong>CP MODE</strong>: Leased primaries (${state.leases.duration}) order writes and serve reads. ${deposed} deposed; their keys wait out the lease before failing over.`;
+    capLed.style.background = 'var(--success)';
+    capLed.style.boxShadow = '0 0 8px var(--success)';
   } else {
     capInfo.innerHTML = `<strong>CP MODE</strong>: Strict Consistency. Writes may fail if partitions occur.`;
     capLed.style.background = 'var(--success)';
@@ -263,6 +306,37 @@ function updateHUD() {
   document.getElementById('metric-nodes').innerText = state.nodes.length;
   const totalKeys = Object.values(state.stats).reduce((acc, s) => acc + s.key_count, 0);
   document.getElementById('metric-keys').innerText = totalKeys;
+  const shed = state.nodes.filter(n => breakerState(n) !== 'closed').length;
+  document.getElementById('metric-shed').innerText = shed;
+  const h = state.hedging;
+  document.getElementById('metric-hedged').innerText = h ? `${h.hedged} (${h.wins} won)` : 0;
+}
+
+// Whether the chain manager has spliced a worker out of the replication chains.
+function chainMembership(addr) {
+  const chain = state.chain || { failed: [], joining: [] };
+  if (chain.failed.includes(addr)) return 'spliced out';
+  if (chain.joining.includes(addr)) return 'rejoining';
+  return 'in chain';
+}
+
+// The worker's share of the hash ring, its weight and, under bounded loads, its placed keys against the cap.
+function placementLine(addr) {
+  const p = state.placement;
+  const node = p && p.nodes.find(n => n.address === addr);
+  if (!node) return 'RING -';
+  const share = `RING ${(node.share * 100).toFixed(1)}%`;
+  const weighted = node.weight !== 1 ? ` ×${node.weight}` : '';
+  const z = state.zones;
+  const violations = z && z.violations > 0 ? ` (${z.violations} keys on too few zones)` : '';
+  const zone = node.zone ? ` // ZONE ${node.zone}${violations}` : '';
+  return p.balance > 0 ? `${share}${weighted}${zone} // ${node.placed}/${node.capacity} keys placed (ε=${p.balance})` : `${share}${weighted}${zone}`;
+}
+
+// Circuit breaker state of a worker as seen by the master ('closed' if unknown).
+function breakerState(addr) {
+  const b = state.breakers[addr];
+  return b ? b.state : 'closed';
 }
 
 function selectNode(addr) {
@@ -279,8 +353,31 @@ function updateInspector() {
   }
 
   const s = state.stats[state.selectedNode];
-  if (!s) return;
+  const breaker = breakerState(state.selectedNode);
+  const breakerLine = breaker === 'closed' ? '' : `
+        <div class="breaker-line" style="color:${COLOR_BREAKER[breaker]}; font-size:0.7rem; padding:5px 0;">
+            BREAKER ${breaker.toUpperCase()} // ${state.breakers[state.selectedNode].shed} requests shed
+        </div>`;
+  const chainState = chainMembership(state.selectedNode);
+  const chainLine = chainState === 'in chain' ? '' : `
+        <div style="color:${COLOR_BREAKER.open}; font-size:0.7rem; padding:5px 0;">
+            CHAIN ${chainState.toUpperCase()}
+        </div>`;
+  const repl = state.replication[state.selectedNode];
+  const replLine = !repl || repl.pending === 0 ? '' : `
+        <div style="color:var(--warning); font-size:0.7rem; padding:5px 0;">
+            REPLICATION LAG // ${repl.pending} writes, ${repl.lag_seconds.toFixed(1)}s behind
+        </div>`;
+  if (!s) {
+    container.innerHTML = `
+        <div class="node-detail-header">
+            <span class="node-detail-title">Worker ${state.selectedNode.split(':')[1]}</span>
+            <span style="color:var(--danger); font-size:0.7rem;">UNREACHABLE</span>
+        </div>${breakerLine}${chainLine}${replLine}`;
+    return;
+  }
 
+  const load = state.load[state.selectedNode];
   const keyBadges = (s.keys && s.keys.length > 0)
     ? s.keys.map(k => `<span class="key-badge">${k}</span>`).join('')
     : '<span class="text-dim" style="font-size:0.7rem; padding:5px;">No keys stored</span>';
@@ -289,6 +386,15 @@ function updateInspector() {
         <div class="node-detail-header">
             <span class="node-detail-title">Worker ${state.selectedNode.split(':')[1]}</span>
             <span style="color:var(--text-dim); font-size:0.7rem;">${s.request_rate} req/s</span>
+        </div>${breakerLine}${chainLine}${replLine}
+        <div style="color:var(--text-dim); font-size:0.7rem; padding:5px 0;">
+            LOAD ${load ? load.latency_ms.toFixed(1) : '-'}ms avg read // ${load ? load.in_flight : 0} in flight
+        </div>
+        <div style="color:var(--text-dim); font-size:0.7rem; padding:5px 0;">
+            ${placementLine(state.selectedNode)}
+        </div>
+        <div style="color:var(--text-dim); font-size:0.7rem; padding:5px 0;">
+            READS ${s.clean_reads} clean / ${s.dirty_reads} via tail // ${s.dirty_keys} dirty keys // ${s.duplicates} retried writes deduplicated // ${s.old_versions} old versions kept
         </div>
         <div class="key-list">
             ${keyBadges}
@@ -314,7 +420,8 @@ function showTooltip(e, d) {
   tt.innerHTML = `
         <strong>${d.id}</strong><br>
         Keys: ${d.stat.key_count}<br>
-        Load: ${d.stat.request_rate}/s
+        Load: ${d.stat.request_rate}/s<br>
+        Breaker: ${d.breaker}
     `;
 }
 
@@ -343,8 +450,8 @@ document.getElementById('btn-put').addEventListener('click', async () => {
   if (!key || !val) return;
 
   try {
-    await fetch(`${API_URL}/put?key=${key}&value=${val}`);
-    log(`PUT ${key} = ${val}`, 'success');
+    const res = await fetch(`${API_URL}/put?key=${key}&value=${val}`);
+    log(`PUT ${key} = ${val} [${res.headers.get('X-Consistency-Level') || state.mode}]`, 'success');
     keyInput.value = '';
     valInput.value = '';
     spawnRequest('???'); // We don't know the exact target node here easily without shared hash logic
@@ -364,7 +471,7 @@ document.getElementById('btn-get').addEventListener('click', async () => {
     const res = await fetch(`${API_URL}/get?key=${key}`);
     if (res.ok) {
       const val = await res.text();
-      log(`GET ${key} -> ${val}`, 'success');
+      log(`GET ${key} -> ${val} [${res.headers.get('X-Consistency-Level') || state.mode}]`, 'success');
     } else {
       log(`GET ${key} -> NOT FOUND`, 'warning');
     }
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"customise-db/common"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// resolveIncrLocked turns an increment into an ordinary write of the result,
+// versioned after the value it adds to, so replicas downstream of us store it
+// like any other write (see common/counter.go). A missing or expired key
+// counts as 0. Caller holds w.mu.
+func (w *KVWorker) resolveIncrLocked(args *common.PutArgs) error {
+	km := w.meta[args.Key]
+	var current int64
+	if value, ok := w.data[args.Key]; ok && !km.expired(time.Now()) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("incr %s: value %q is not a 64-bit integer", args.Key, value)
+		}
+		current = n
+	}
+	if (args.By > 0 && current > math.MaxInt64-args.By) || (args.By < 0 && current < math.MinInt64-args.By) {
+		return fmt.Errorf("incr %s: %d%+d overflows 64 bits", args.Key, current, args.By)
+	}
+	args.Value = strconv.FormatInt(current+args.By, 10)
+	args.Incr = false
+	if args.Version <= km.Version {
+		args.Version = km.Version + 1
+	}
+	return nil
+}
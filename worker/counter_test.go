@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"customise-db/common"
+	"math"
+	"testing"
+)
+
+func TestPut_IncrResolvesIntoAnOrdinaryWrite(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	reply := &common.PutReply{}
+	if err := w.Put(&common.PutArgs{Key: "c", Incr: true, By: 3}, reply); err != nil || reply.Value != "3" || reply.Version != 1 {
+		t.Fatalf("Expected a missing counter to start at 0, got %+v (%v)", reply, err)
+	}
+	// A master version at or below the stored one is moved past it.
+	reply = &common.PutReply{}
+	if err := w.Put(&common.PutArgs{Key: "c", Incr: true, By: -5, Version: 1}, reply); err != nil || reply.Value != "-2" || reply.Version != 2 {
+		t.Errorf("Expected -2 at v2, got %+v (%v)", reply, err)
+	}
+	if w.data["c"] != "-2" {
+		t.Errorf("Expected the result stored as the value, got %q", w.data["c"])
+	}
+
+	w.Put(&common.PutArgs{Key: "name", Value: "ada", Version: 1}, &common.PutReply{})
+	if err := w.Put(&common.PutArgs{Key: "name", Incr: true, By: 1}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected incrementing a non-integer to fail")
+	}
+	w.Put(&common.PutArgs{Key: "big", Value: "9223372036854775807", Version: 1}, &common.PutReply{})
+	if err := w.Put(&common.PutArgs{Key: "big", Incr: true, By: 1}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected an overflow to fail")
+	}
+	w.Put(&common.PutArgs{Key: "small", Value: "-1", Version: 1}, &common.PutReply{})
+	if err := w.Put(&common.PutArgs{Key: "small", Incr: true, By: math.MinInt64}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected an underflow to fail")
+	}
+}
@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// objectVersion is one version of a key kept while a chain write is in flight.
+type objectVersion struct {
+	Version int64
+	Value   string
+	Found   bool // False if the key did not exist at this version
+
+	ForwardTo string // Rest of the chain a dirty version was forwarded to
+}
+
+// CRAQ (Chain Replication with Apportioned Queries) bookkeeping.
+//
+// A chain write is dirty on every node until the tail has applied it. While a
+// key is dirty, w.versions[key] holds its last clean version followed by the
+// dirty ones in ascending order; once everything is committed the entry is
+// dropped and w.data alone is the clean value. The tail commits on apply, so
+// it never has dirty keys.
+
+// markDirty records a chain write that has been applied locally but not yet
+// committed by the tail. prev is the state of the key before the write.
+// Caller holds w.mu.
+func (w *KVWorker) markDirty(key string, prev objectVersion, next objectVersion) {
+	if _, dirty := w.versions[key]; !dirty {
+		w.versions[key] = []objectVersion{prev}
+	}
+	w.versions[key] = append(w.versions[key], next)
+}
+
+// markClean forgets any dirty versions of key; the stored value is committed.
+// Caller holds w.mu.
+func (w *KVWorker) markClean(key string) {
+	delete(w.versions, key)
+}
+
+// commit marks every version of key up to and including version as committed.
+func (w *KVWorker) commit(key string, version int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	vs, dirty := w.versions[key]
+	if !dirty {
+		return
+	}
+	// Keep the newest committed version as the clean base, plus anything newer.
+	base := 0
+	for i := 1; i < len(vs); i++ {
+		if vs[i].Version <= version {
+			base = i
+		}
+	}
+	vs = vs[base:]
+	if len(vs) == 1 {
+		delete(w.versions, key)
+		return
+	}
+	w.versions[key] = vs
+}
+
+// committedVersion returns the version of key this worker considers committed.
+// Caller holds w.mu (read).
+func (w *KVWorker) committedVersion(key string) objectVersion {
+	if vs, dirty := w.versions[key]; dirty {
+		return vs[0]
+	}
+	val, ok := w.data[key]
+	return objectVersion{Version: w.meta[key].Version, Value: val, Found: ok}
+}
+
+// CommittedVersion RPC handler: the tail reports the committed version of a key
+// so upstream nodes can answer reads of keys that are dirty for them. The read
+// carries the epoch the master routed it at, and is fenced like one.
+func (w *KVWorker) CommittedVersion(args *common.VersionArgs, reply *common.VersionReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("committed version of %s: %w", args.Key, err)
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	v := w.committedVersion(args.Key)
+	reply.Version = v.Version
+	reply.Found = v.Found
+	return nil
+}
+
+// readDirty answers a read of a key that has uncommitted versions here by asking
+// the tail which version is committed and returning that one.
+func (w *KVWorker) readDirty(args *common.GetArgs, reply *common.GetReply) error {
+	ctx, cancel := common.WithDeadline(context.Background(), args.Deadline, forwardTimeout)
+	defer cancel()
+
+	var committed common.VersionReply
+	if err := common.Call(ctx, args.Tail, "KV.CommittedVersion", &common.VersionArgs{Key: args.Key, Deadline: common.DeadlineOf(ctx), Epoch: args.Epoch}, &committed); err != nil {
+		if common.IsStaleRouting(err) {
+			return fmt.Errorf("dirty read of %s: %w", args.Key, err)
+		}
+		return fmt.Errorf("dirty read of %s: tail %s unreachable: %v", args.Key, args.Tail, err)
+	}
+
+	w.mu.RLock()
+	vs := w.versions[args.Key]
+	if len(vs) == 0 {
+		// Committed while we were asking; the local value is clean now.
+		v := w.committedVersion(args.Key)
+		vs = []objectVersion{v}
+	}
+	w.mu.RUnlock()
+
+	for _, v := range vs {
+		if v.Version == committed.Version {
+			reply.Value, reply.Found, reply.Version = v.Value, v.Found, v.Version
+			log.Printf("[Worker-%s] Get(%s) dirty -> v%d from tail %s", w.port, args.Key, v.Version, args.Tail)
+			return nil
+		}
+	}
+	if !committed.Found {
+		reply.Found = false
+		return nil
+	}
+
+	// The tail committed a version we never saw (e.g. we joined the chain late):
+	// let the tail answer the read itself.
+	r := &common.GetReply{}
+	tailArgs := *args
+	tailArgs.Tail = ""
+	tailArgs.Deadline = common.DeadlineOf(ctx)
+	if err := common.Call(ctx, args.Tail, "KV.Get", &tailArgs, r); err != nil {
+		return fmt.Errorf("dirty read of %s: tail %s failed: %v", args.Key, args.Tail, err)
+	}
+	*reply = *r
+	return nil
+}
+
+// SuccessorFailed RPC handler: the chain manager has spliced failed out of every
+// chain. Dirty writes this worker forwarded straight to failed as the tail are
+// committed, since this worker is their tail now; those stuck behind failed in
+// the middle of a chain are returned so the master can re-drive them through
+// the repaired chain. Streams through failed are dropped.
+func (w *KVWorker) SuccessorFailed(args *common.SpliceArgs, reply *common.SpliceReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("splice of %s: %w", args.Failed, err)
+	}
+	w.dropStreams(args.Failed, time.Now())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for key, vs := range w.versions {
+		newest := vs[len(vs)-1]
+		switch {
+		case w.meta[key].expired(now):
+			continue
+		case newest.ForwardTo == args.Failed:
+			reply.Committed++
+			delete(w.versions, key)
+		case strings.HasPrefix(newest.ForwardTo, args.Failed+","):
+			reply.Pending = append(reply.Pending, common.PutArgs{
+				Key:     key,
+				Value:   newest.Value,
+				Version: newest.Version,
+				TTL:     w.meta[key].ttl(now),
+			})
+		}
+	}
+	log.Printf("[Worker-%s] Successor %s spliced out: %d committed, %d to re-drive",
+		w.port, args.Failed, reply.Committed, len(reply.Pending))
+	return nil
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"customise-db/common"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+// serveWorker serves w on a random local port until the test ends.
+func serveWorker(t *testing.T, w *KVWorker) string {
+	t.Helper()
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("KV", w); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeConn(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestCRAQ_DirtyReadUsesTailVersion(t *testing.T) {
+	tail := newKVWorker("9002", 0, 0)
+	tailAddr := serveWorker(t, tail)
+	head := newKVWorker("9001", 0, 0)
+
+	// v1 is committed on both nodes.
+	head.writeLocal(&common.PutArgs{Key: "k", Value: "v1", Version: 1})
+	tail.writeLocal(&common.PutArgs{Key: "k", Value: "v1", Version: 1})
+
+	// v2 reached the head but not yet the tail.
+	head.writeLocal(&common.PutArgs{Key: "k", Value: "v2", Version: 2, ForwardTo: tailAddr})
+
+	reply := &common.GetReply{}
+	if err := head.Get(&common.GetArgs{Key: "k", Tail: tailAddr}, reply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if reply.Value != "v1" || reply.Version != 1 {
+		t.Errorf("Expected dirty read to return committed v1, got %q (v%d)", reply.Value, reply.Version)
+	}
+	if head.dirtyReads != 1 {
+		t.Errorf("Expected 1 dirty read, got %d", head.dirtyReads)
+	}
+
+	// Once the tail has it and the ack came back, the head serves v2 by itself.
+	tail.writeLocal(&common.PutArgs{Key: "k", Value: "v2", Version: 2})
+	head.commit("k", 2)
+
+	reply = &common.GetReply{}
+	if err := head.Get(&common.GetArgs{Key: "k", Tail: tailAddr}, reply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if reply.Value != "v2" {
+		t.Errorf("Expected clean read of v2, got %q", reply.Value)
+	}
+	if head.cleanReads != 1 {
+		t.Errorf("Expected 1 clean read, got %d", head.cleanReads)
+	}
+}
+
+func TestCRAQ_ChainPutCommits(t *testing.T) {
+	tail := newKVWorker("9002", 0, 0)
+	tailAddr := serveWorker(t, tail)
+	head := newKVWorker("9001", 0, 0)
+	headAddr := serveWorker(t, head)
+
+	chainID := common.ChainID(1, []string{headAddr, tailAddr})
+	reply := &common.PutReply{}
+	if err := head.Put(&common.PutArgs{Key: "k", Value: "v", Version: 1, ForwardTo: tailAddr, ChainID: chainID}, reply); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !reply.Committed {
+		t.Errorf("Expected the tail's acknowledgement to reach the head")
+	}
+	if len(head.versions) != 0 {
+		t.Errorf("Expected the head to be clean after the tail applied the write, got %v", head.versions)
+	}
+	if tail.data["k"] != "v" {
+		t.Errorf("Expected the tail to hold the write, got %q", tail.data["k"])
+	}
+}
+
+func TestSuccessorFailed_CommitsOrRedrives(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	// "a" was forwarded straight to the tail w3; "b" went on through w3 to w4.
+	w.writeLocal(&common.PutArgs{Key: "a", Value: "1", Version: 1, ForwardTo: "w3"})
+	w.writeLocal(&common.PutArgs{Key: "b", Value: "2", Version: 2, ForwardTo: "w3,w4"})
+
+	reply := &common.SpliceReply{}
+	if err := w.SuccessorFailed(&common.SpliceArgs{Failed: "w3"}, reply); err != nil {
+		t.Fatalf("SuccessorFailed failed: %v", err)
+	}
+	if reply.Committed != 1 {
+		t.Errorf("Expected 1 write committed, got %d", reply.Committed)
+	}
+	if _, dirty := w.versions["a"]; dirty {
+		t.Errorf("Expected a to be committed now that this worker is its tail")
+	}
+	if len(reply.Pending) != 1 || reply.Pending[0].Key != "b" || reply.Pending[0].Version != 2 {
+		t.Errorf("Expected b v2 to be re-driven, got %+v", reply.Pending)
+	}
+}
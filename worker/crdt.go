@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"customise-db/common"
+	"customise-db/crdt"
+	"fmt"
+	"time"
+)
+
+// resolveCRDTLocked turns a CRDT write into the merge of its state (or the
+// result of its operation) with ours, versioned after ours if that changes
+// anything (see common/crdt.go). A plain write to a key holding a CRDT is
+// refused: it would throw away updates the other replicas have yet to send.
+// Caller holds w.mu.
+func (w *KVWorker) resolveCRDTLocked(args *common.PutArgs) error {
+	km := w.meta[args.Key]
+	current, ok := w.data[args.Key]
+	if ok && km.expired(time.Now()) {
+		current, ok, km = "", false, keyMeta{}
+	}
+	if args.CRDT == "" {
+		if ok && km.CRDT != "" {
+			return fmt.Errorf("put %s: holds a %s; update it with a CRDT operation", args.Key, km.CRDT)
+		}
+		return nil
+	}
+	if ok && km.CRDT != args.CRDT {
+		held := km.CRDT
+		if held == "" {
+			held = "plain value"
+		}
+		return fmt.Errorf("put %s: holds a %s, not a %s", args.Key, held, args.CRDT)
+	}
+
+	state, err := crdt.Decode(args.CRDT, current)
+	if err != nil {
+		return fmt.Errorf("put %s: %v", args.Key, err)
+	}
+	if args.Op != nil {
+		err = state.Apply(*args.Op, w.node, time.Now().UnixNano())
+	} else {
+		var incoming crdt.CRDT
+		if incoming, err = crdt.Decode(args.CRDT, args.Value); err == nil {
+			err = state.Merge(incoming)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("put %s: %v", args.Key, err)
+	}
+	merged, err := crdt.Encode(state)
+	if err != nil {
+		return fmt.Errorf("put %s: %v", args.Key, err)
+	}
+	args.Value, args.Op = merged, nil
+	switch {
+	case ok && merged == current:
+		args.Version = km.Version // Nothing new
+	case args.Version <= km.Version:
+		args.Version = km.Version + 1
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"customise-db/common"
+	"customise-db/crdt"
+	"testing"
+)
+
+func TestPut_MergesCRDTStates(t *testing.T) {
+	a, b := newKVWorker("9001", 0, 0), newKVWorker("9002", 0, 0)
+	add := func(w *KVWorker, v string) string {
+		t.Helper()
+		reply := &common.PutReply{}
+		op := crdt.Op{Kind: crdt.OpAdd, Value: v}
+		if err := w.Put(&common.PutArgs{Key: "s", CRDT: crdt.TypeORSet, Op: &op}, reply); err != nil || reply.Value == "" {
+			t.Fatalf("Op failed: %+v (%v)", reply, err)
+		}
+		return reply.Value
+	}
+	fromA, fromB := add(a, "x"), add(b, "y")
+
+	// Each replica merges the other's state, whatever order the copies arrive in.
+	a.Put(&common.PutArgs{Key: "s", CRDT: crdt.TypeORSet, Value: fromB}, &common.PutReply{})
+	b.Put(&common.PutArgs{Key: "s", CRDT: crdt.TypeORSet, Value: fromA}, &common.PutReply{})
+	b.Put(&common.PutArgs{Key: "s", CRDT: crdt.TypeORSet, Value: fromA}, &common.PutReply{})
+	if a.data["s"] != b.data["s"] {
+		t.Fatalf("Expected the replicas to converge, got\n%s\n%s", a.data["s"], b.data["s"])
+	}
+	got := &common.GetReply{}
+	a.Get(&common.GetArgs{Key: "s"}, got)
+	if state, _ := crdt.Decode(got.CRDT, got.Value); len(state.Value().([]string)) != 2 {
+		t.Errorf("Expected both adds kept, got %v", state.Value())
+	}
+
+	if err := a.Put(&common.PutArgs{Key: "s", Value: "plain", Version: got.Version + 1}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected a plain write over a CRDT to be refused")
+	}
+	op := crdt.Op{Kind: crdt.OpIncr, By: 1}
+	if err := a.Put(&common.PutArgs{Key: "s", CRDT: crdt.TypeGCounter, Op: &op}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected an update of another type to be refused")
+	}
+	var stats common.StatsReply
+	a.GetStats(&common.StatsArgs{}, &stats)
+	if len(stats.CRDTKeys) != 1 || stats.CRDTKeys[0] != "s" {
+		t.Errorf("Expected s reported as a CRDT key, got %v", stats.CRDTKeys)
+	}
+}
@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"customise-db/common"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Idempotent writes: a write that carries a request ID is applied at most
+// once per worker. The first attempt is remembered with its outcome; a repeat
+// (a master retrying after a lost reply, or a client retrying a /put) waits
+// for it and gets the same reply instead of applying the write again. An
+// attempt that failed is forgotten, so a retry of it runs for real. A repeat
+// the master restamped at a newer version is applied again at that version,
+// unless it resolves against the stored value (an increment or a CRDT
+// operation), which would count it twice.
+
+// Bounds on the dedup table: request IDs remembered per client, oldest
+// forgotten first, and clients remembered, least recently active forgotten
+// first.
+const (
+	dedupPerClient = 1000
+	dedupClients   = 1000
+)
+
+// dedupEntry is one write's first attempt.
+type dedupEntry struct {
+	key     string
+	done    chan struct{} // Closed once the attempt finished
+	version int64         // Version the attempt stored
+	reply   common.PutReply
+	err     error
+}
+
+// clientRequests are the writes remembered for one client.
+type clientRequests struct {
+	entries map[string]*dedupEntry
+	order   []string // Request IDs, oldest first
+	used    uint64   // When the client last wrote, in dedupTable.clock ticks
+}
+
+type dedupTable struct {
+	mu         sync.Mutex
+	clients    map[string]*clientRequests
+	clock      uint64
+	duplicates int
+}
+
+func newDedupTable() *dedupTable {
+	return &dedupTable{clients: make(map[string]*clientRequests)}
+}
+
+// begin looks up a write. If it is new it is recorded as in progress and
+// first is true: the caller applies it and then calls finish.
+func (d *dedupTable) begin(client, id, key string) (e *dedupEntry, first bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock++
+	c, ok := d.clients[client]
+	if !ok {
+		if len(d.clients) >= dedupClients {
+			d.evictClientLocked()
+		}
+		c = &clientRequests{entries: make(map[string]*dedupEntry)}
+		d.clients[client] = c
+	}
+	c.used = d.clock
+	if e, ok := c.entries[id]; ok {
+		if e.key != key {
+			return nil, false, fmt.Errorf("put %s: request ID %q of client %q was already used for %s", key, id, client, e.key)
+		}
+		return e, false, nil
+	}
+	e = &dedupEntry{key: key, done: make(chan struct{})}
+	c.entries[id] = e
+	c.order = append(c.order, id)
+	if len(c.order) > dedupPerClient {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return e, true, nil
+}
+
+// replace swaps a finished entry for a new attempt in progress, if no one
+// else has replaced it already.
+func (d *dedupTable) replace(client, id string, old *dedupEntry) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clients[client]
+	if !ok || c.entries[id] != old {
+		return nil, false
+	}
+	e := &dedupEntry{key: old.key, done: make(chan struct{})}
+	c.entries[id] = e
+	return e, true
+}
+
+// evictClientLocked forgets the least recently active client. Caller holds d.mu.
+func (d *dedupTable) evictClientLocked() {
+	oldest := ""
+	for name, c := range d.clients {
+		if oldest == "" || c.used < d.clients[oldest].used {
+			oldest = name
+		}
+	}
+	delete(d.clients, oldest)
+}
+
+// finish records how a write's first attempt ended and the version it
+// stored, and releases any repeats waiting on it. A failed attempt is
+// forgotten.
+func (d *dedupTable) finish(client, id string, e *dedupEntry, version int64, reply *common.PutReply, err error) {
+	d.mu.Lock()
+	e.version, e.reply, e.err = version, *reply, err
+	if c, ok := d.clients[client]; err != nil && ok && c.entries[id] == e {
+		delete(c.entries, id)
+		for i, other := range c.order {
+			if other == id {
+				c.order = append(c.order[:i], c.order[i+1:]...)
+				break
+			}
+		}
+	}
+	d.mu.Unlock()
+	close(e.done)
+}
+
+// answered counts a repeat answered from the table.
+func (d *dedupTable) answered() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.duplicates++
+}
+
+func (d *dedupTable) stat() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.duplicates
+}
+
+// putOnce applies a write with a request ID at most once.
+func (w *KVWorker) putOnce(args *common.PutArgs, reply *common.PutReply) error {
+	for {
+		e, first, err := w.dedup.begin(args.ClientID, args.RequestID, args.Key)
+		if err != nil {
+			return err
+		}
+		if first {
+			return w.putAttempt(e, args, reply)
+		}
+		<-e.done
+		if e.err == nil && restamped(e, args) {
+			if next, ok := w.dedup.replace(args.ClientID, args.RequestID, e); ok {
+				log.Printf("[Worker-%s] Put(%s) request %s/%s restamped v%d -> v%d", w.port, args.Key, args.ClientID, args.RequestID, e.version, args.Version)
+				return w.putAttempt(next, args, reply)
+			}
+			continue // Another repeat is restamping it: wait for that one
+		}
+		if e.err == nil {
+			w.dedup.answered()
+			*reply = e.reply
+			log.Printf("[Worker-%s] Put(%s) request %s/%s already applied", w.port, args.Key, args.ClientID, args.RequestID)
+			return nil
+		}
+		// The first attempt failed and was forgotten: this one runs it again.
+	}
+}
+
+// putAttempt applies a write for dedup entry e and records the outcome.
+func (w *KVWorker) putAttempt(e *dedupEntry, args *common.PutArgs, reply *common.PutReply) error {
+	err := w.put(args, reply)
+	version := args.Version
+	if reply.Version != 0 {
+		version = reply.Version // The primary or an increment chose it
+	}
+	w.dedup.finish(args.ClientID, args.RequestID, e, version, reply, err)
+	return err
+}
+
+// restamped reports whether args is the master's restamp of the write e
+// stored, which should be stored again at its newer version.
+func restamped(e *dedupEntry, args *common.PutArgs) bool {
+	return args.Restamp && args.Version > e.version && !args.Incr && args.Op == nil
+}
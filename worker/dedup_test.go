@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"customise-db/common"
+	"fmt"
+	"testing"
+)
+
+func TestPutOnce_AppliesRequestOnce(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	first := &common.PutReply{}
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v1", Version: 1, ClientID: "c", RequestID: "1"}, first); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// A retry of the same request, even carrying a newer version, is not applied again.
+	again := &common.PutReply{}
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v2", Version: 2, ClientID: "c", RequestID: "1"}, again); err != nil {
+		t.Fatalf("Retried put failed: %v", err)
+	}
+	if w.data["k"] != "v1" || *again != *first {
+		t.Errorf("Expected the retry answered from the table, got value %q and reply %+v", w.data["k"], again)
+	}
+	// The same ID from another client is a different request.
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v3", Version: 3, ClientID: "other", RequestID: "1"}, &common.PutReply{}); err != nil || w.data["k"] != "v3" {
+		t.Errorf("Expected another client's request to apply, got %q (%v)", w.data["k"], err)
+	}
+	if err := w.Put(&common.PutArgs{Key: "x", Value: "v", ClientID: "c", RequestID: "1"}, &common.PutReply{}); err == nil {
+		t.Errorf("Expected a request ID reused for another key to be refused")
+	}
+	var stats common.StatsReply
+	w.GetStats(&common.StatsArgs{}, &stats)
+	if stats.Duplicates != 1 {
+		t.Errorf("Expected 1 duplicate, got %d", stats.Duplicates)
+	}
+}
+
+func TestPutOnce_ForgetsFailedAttempts(t *testing.T) {
+	w := newKVWorker("9001", 1, 0)
+	w.Put(&common.PutArgs{Key: "a", Value: "v"}, &common.PutReply{})
+	args := &common.PutArgs{Key: "b", Value: "v", ClientID: "c", RequestID: "7"}
+	if err := w.Put(args, &common.PutReply{}); err == nil {
+		t.Fatalf("Expected a write to a full worker to fail")
+	}
+	w.Delete(&common.DeleteArgs{Key: "a"}, &common.DeleteReply{})
+	if err := w.Put(args, &common.PutReply{}); err != nil || w.data["b"] != "v" {
+		t.Errorf("Expected the retry of a failed request to apply, got %q (%v)", w.data["b"], err)
+	}
+}
+
+func TestPutOnce_RestampedRepeatStoresTheNewerVersion(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	w.Put(&common.PutArgs{Key: "k", Value: "v", Version: 1, ClientID: "c", RequestID: "1"}, &common.PutReply{})
+	restamp := &common.PutArgs{Key: "k", Value: "v", Version: 5, ClientID: "c", RequestID: "1", Restamp: true}
+	if err := w.Put(restamp, &common.PutReply{}); err != nil || w.meta["k"].Version != 5 {
+		t.Errorf("Expected the restamped write stored at v5, got v%d (%v)", w.meta["k"].Version, err)
+	}
+	// A later repeat is answered from the table again.
+	w.Put(&common.PutArgs{Key: "k", Value: "v", Version: 9, ClientID: "c", RequestID: "1"}, &common.PutReply{})
+	if w.meta["k"].Version != 5 {
+		t.Errorf("Expected a plain repeat answered from the table, got v%d", w.meta["k"].Version)
+	}
+
+	// An increment is not applied twice, restamped or not.
+	w.Put(&common.PutArgs{Key: "n", Incr: true, By: 1, Version: 1, ClientID: "c", RequestID: "2"}, &common.PutReply{})
+	w.Put(&common.PutArgs{Key: "n", Incr: true, By: 1, Version: 5, ClientID: "c", RequestID: "2", Restamp: true}, &common.PutReply{})
+	if w.data["n"] != "1" {
+		t.Errorf("Expected the restamped increment answered from the table, got %q", w.data["n"])
+	}
+}
+
+func TestDedupTable_Bounded(t *testing.T) {
+	d := newDedupTable()
+	for i := 0; i <= dedupPerClient; i++ {
+		e, _, _ := d.begin("c", fmt.Sprint(i), "k")
+		d.finish("c", fmt.Sprint(i), e, 0, &common.PutReply{}, nil)
+	}
+	if _, first, _ := d.begin("c", "0", "k"); !first {
+		t.Errorf("Expected the oldest request ID to be forgotten")
+	}
+	if _, first, _ := d.begin("c", fmt.Sprint(dedupPerClient), "k"); first {
+		t.Errorf("Expected the newest request ID to be remembered")
+	}
+	for i := 0; i < dedupClients; i++ {
+		d.begin(fmt.Sprint("client", i), "1", "k")
+	}
+	if len(d.clients) != dedupClients {
+		t.Errorf("Expected at most %d clients, got %d", dedupClients, len(d.clients))
+	}
+	if _, ok := d.clients["c"]; ok {
+		t.Errorf("Expected the least recently active client to be forgotten")
+	}
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"customise-db/common"
+	"log"
+)
+
+// Epoch fencing: the master numbers every version of the ring and its
+// members with a cluster epoch and stamps it on what it sends. A worker
+// remembers the highest epoch it has seen and refuses requests routed at an
+// older one, so a master with an out-of-date ring (or a second master that
+// has since been superseded) cannot place writes by it. Epoch 0 is what an
+// unfenced sender uses; it is refused only once a higher epoch has been seen.
+
+// fence admits a request routed at epoch, moving this worker up to it if it
+// is newer.
+func (w *KVWorker) fence(epoch uint64) error {
+	w.epochMu.Lock()
+	defer w.epochMu.Unlock()
+	if epoch < w.epoch {
+		return common.StaleRoutingError(epoch, w.epoch)
+	}
+	if epoch > w.epoch {
+		log.Printf("[Worker-%s] Cluster epoch %d -> %d", w.port, w.epoch, epoch)
+		w.epoch = epoch
+	}
+	return nil
+}
+
+func (w *KVWorker) currentEpoch() uint64 {
+	w.epochMu.Lock()
+	defer w.epochMu.Unlock()
+	return w.epoch
+}
+
+// Fence RPC handler: the master announces a new epoch.
+func (w *KVWorker) Fence(args *common.FenceArgs, reply *common.FenceReply) error {
+	err := w.fence(args.Epoch)
+	reply.Epoch = w.currentEpoch()
+	return err
+}
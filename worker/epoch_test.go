@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"customise-db/common"
+	"testing"
+)
+
+func TestFence_RefusesOlderEpochs(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v1"}, &common.PutReply{}); err != nil {
+		t.Fatalf("Expected an unfenced write to a fresh worker to succeed, got %v", err)
+	}
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v2", Version: 2, Epoch: 2}, &common.PutReply{}); err != nil {
+		t.Fatalf("Expected a write at a newer epoch to succeed, got %v", err)
+	}
+
+	for _, epoch := range []uint64{0, 1} {
+		err := w.Put(&common.PutArgs{Key: "k", Value: "stale", Version: 3, Epoch: epoch}, &common.PutReply{})
+		if !common.IsStaleRouting(err) {
+			t.Errorf("Expected a write at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.Get(&common.GetArgs{Key: "k", Epoch: epoch}, &common.GetReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a read at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.Delete(&common.DeleteArgs{Key: "k", Epoch: epoch}, &common.DeleteReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a delete at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.Decide(&common.DecideArgs{TxnID: "t1", Commit: true, Epoch: epoch}, &common.DecideReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a decision at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.Collect(&common.CollectArgs{Before: 1, Epoch: epoch}, &common.CollectReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a collection at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.SuccessorFailed(&common.SpliceArgs{Failed: "x", Epoch: epoch}, &common.SpliceReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a splice at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.CommittedVersion(&common.VersionArgs{Key: "k", Epoch: epoch}, &common.VersionReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a committed-version read at epoch %d to be refused, got %v", epoch, err)
+		}
+		if err := w.ChainAck(&common.ChainAckArgs{ChainID: "c", Seq: 1, Epoch: epoch}, &common.ChainAckReply{}); !common.IsStaleRouting(err) {
+			t.Errorf("Expected a chain ack at epoch %d to be refused, got %v", epoch, err)
+		}
+	}
+
+	var stats common.StatsReply
+	if err := w.GetStats(&common.StatsArgs{Epoch: 1}, &stats); err != nil || stats.Epoch != 2 {
+		t.Errorf("Expected stats at an old epoch to report epoch 2, got %d (%v)", stats.Epoch, err)
+	}
+	var reply common.GetReply
+	if err := w.Get(&common.GetArgs{Key: "k", Epoch: 2}, &reply); err != nil || reply.Value != "v2" {
+		t.Errorf("Expected v2 at the current epoch, got %q (%v)", reply.Value, err)
+	}
+
+	var fence common.FenceReply
+	if err := w.Fence(&common.FenceArgs{Epoch: 4}, &fence); err != nil || fence.Epoch != 4 {
+		t.Errorf("Expected a fence to move the worker to epoch 4, got %d (%v)", fence.Epoch, err)
+	}
+	if err := w.Put(&common.PutArgs{Key: "k", Value: "v3", Version: 4, Epoch: 3}, &common.PutReply{}); !common.IsStaleRouting(err) {
+		t.Errorf("Expected a write at epoch 3 to be refused after the fence, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"customise-db/common"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MVCC: besides the current value of each key, a worker keeps the versions
+// it superseded, so a snapshot read can be answered as of any timestamp the
+// master still has a snapshot open at. The master periodically sends the
+// oldest such timestamp, and the worker drops every version no snapshot at
+// or after it can read. A key's history goes with it when it is deleted or
+// expires.
+
+// keepVersionLocked records key's current value as superseded, before a
+// newer version replaces it. Caller holds w.mu.
+func (w *KVWorker) keepVersionLocked(key string, next int64) {
+	value, ok := w.data[key]
+	if !ok || next <= w.meta[key].Version {
+		return
+	}
+	w.history[key] = append(w.history[key], objectVersion{Version: w.meta[key].Version, Value: value, Found: true})
+}
+
+// getAsOf answers a snapshot read: the newest version of the key at or
+// below args.AsOf.
+func (w *KVWorker) getAsOf(args *common.GetArgs, reply *common.GetReply) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reqCounter++
+	w.cleanReads++
+	if args.AsOf < w.collected {
+		return fmt.Errorf("get %s: %w: versions before %d are collected, asked for %d", args.Key, common.ErrSnapshotTooOld, w.collected, args.AsOf)
+	}
+
+	now := time.Now()
+	km := w.meta[args.Key]
+	if value, ok := w.data[args.Key]; ok && !km.expired(now) && km.Version <= args.AsOf {
+		reply.Value, reply.Found, reply.Version, reply.TTL = value, true, km.Version, km.ttl(now)
+		return nil
+	}
+	history := w.history[args.Key]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Version <= args.AsOf {
+			reply.Value, reply.Found, reply.Version = history[i].Value, true, history[i].Version
+			return nil
+		}
+	}
+	return nil // The key did not exist yet at that timestamp
+}
+
+// Collect RPC handler: drops the superseded versions that no snapshot at or
+// after args.Before can read. Each key keeps its newest version at or below
+// Before (the one such a snapshot sees) and everything newer.
+func (w *KVWorker) Collect(args *common.CollectArgs, reply *common.CollectReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("collect: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if args.Before > w.collected {
+		w.collected = args.Before
+	}
+	for key, history := range w.history {
+		keep := len(history) // The current version is visible to every snapshot
+		if w.meta[key].Version > w.collected {
+			keep = 0
+			for i := len(history) - 1; i >= 0; i-- {
+				if history[i].Version <= w.collected {
+					keep = i
+					break
+				}
+			}
+		}
+		if keep == 0 {
+			continue
+		}
+		reply.Dropped += keep
+		if keep == len(history) {
+			delete(w.history, key)
+		} else {
+			w.history[key] = append([]objectVersion(nil), history[keep:]...)
+		}
+	}
+	if reply.Dropped > 0 {
+		log.Printf("[Worker-%s] Collected %d old versions before %d", w.port, reply.Dropped, w.collected)
+	}
+	return nil
+}
+
+// oldVersionsLocked counts the superseded versions kept. Caller holds w.mu.
+func (w *KVWorker) oldVersionsLocked() int {
+	n := 0
+	for _, history := range w.history {
+		n += len(history)
+	}
+	return n
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"customise-db/common"
+	"testing"
+)
+
+func TestGetAsOf_ReadsSnapshotsUntilCollected(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	for v := int64(10); v <= 30; v += 10 {
+		w.Put(&common.PutArgs{Key: "k", Value: string(rune('a' + v/10 - 1)), Version: v}, &common.PutReply{})
+	}
+	for _, tc := range []struct {
+		asOf  int64
+		value string
+		found bool
+	}{{5, "", false}, {10, "a", true}, {25, "b", true}, {30, "c", true}, {99, "c", true}} {
+		reply := &common.GetReply{}
+		if err := w.Get(&common.GetArgs{Key: "k", AsOf: tc.asOf}, reply); err != nil || reply.Found != tc.found || reply.Value != tc.value {
+			t.Errorf("As of %d: expected %q (found %v), got %+v (%v)", tc.asOf, tc.value, tc.found, reply, err)
+		}
+	}
+
+	// Snapshots at 25 or later still need b; a is gone.
+	collected := &common.CollectReply{}
+	w.Collect(&common.CollectArgs{Before: 25}, collected)
+	if collected.Dropped != 1 {
+		t.Errorf("Expected 1 version dropped, got %d", collected.Dropped)
+	}
+	if reply := (&common.GetReply{}); w.Get(&common.GetArgs{Key: "k", AsOf: 25}, reply) != nil || reply.Value != "b" {
+		t.Errorf("Expected b still readable as of 25, got %+v", reply)
+	}
+	if err := w.Get(&common.GetArgs{Key: "k", AsOf: 10}, &common.GetReply{}); !common.IsSnapshotTooOld(err) {
+		t.Errorf("Expected a read before the collection to be refused, got %v", err)
+	}
+
+	w.Collect(&common.CollectArgs{Before: 40}, collected)
+	var stats common.StatsReply
+	w.GetStats(&common.StatsArgs{}, &stats)
+	if stats.OldVersions != 0 {
+		t.Errorf("Expected only the current version left, got %d old ones", stats.OldVersions)
+	}
+}
@@ -0,0 +1,506 @@
+package worker
+
+import (
+	"context"
+	"customise-db/common"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pipelined chain replication.
+//
+// The master names the chain a write goes through (PutArgs.ChainID) and sends
+// it to the head, which stamps it with the chain's next sequence number. Every
+// node applies a chain's writes strictly in sequence order, buffering any that
+// arrive early, hands them to an ordered sender that streams them to its
+// successor without waiting for the rest of the chain, and returns. The tail
+// acknowledges cumulatively back up the chain; each node commits what an ack
+// covers, and the head answers the master once its write is acknowledged.
+//
+// A stream whose link to its successor breaks is never repaired: every later
+// write on it fails with a ChainLinkError, the master splices the link out, and
+// the repaired chain gets a new ID and so a fresh stream. A stream that has
+// lost writes is broken the same way. That happens when a node restarts, or
+// drops its end while its neighbour keeps theirs. A successor waiting too long
+// for a missing write names itself. One that sees numbering start over names
+// its predecessor, unless everything before was settled: then the
+// predecessor just dropped an idle stream, and the successor starts afresh too.
+
+// streamIdleTimeout is how long the head keeps an unused stream before it is
+// dropped. Each later node keeps it that much longer again, so a chain's
+// streams are dropped head first.
+const streamIdleTimeout = time.Minute
+
+// streamGapTimeout is how long a write may wait for a missing one before the
+// stream is broken. It is shorter than forwardTimeout, so the head's waiting
+// Puts hear why.
+const streamGapTimeout = 2 * time.Second
+
+// streamQueue bounds the writes queued for a successor before the chain pushes back.
+const streamQueue = 1024
+
+// keyVersion identifies an applied write waiting for the tail's acknowledgement.
+type keyVersion struct {
+	Key     string
+	Version int64
+}
+
+// chainStream is this worker's end of one chain's write stream.
+type chainStream struct {
+	id      string
+	members []string
+	pos     int // This worker's index in members
+
+	mu       sync.Mutex
+	applied  uint64                     // Highest sequence number applied in order (assigned, at the head)
+	acked    uint64                     // Highest sequence number acknowledged by the tail
+	early    map[uint64]*common.PutArgs // Arrived ahead of applied+1
+	unacked  map[uint64]keyVersion      // Applied, waiting for the tail's ack
+	failed   map[uint64]bool            // Failed here or downstream: never commit these
+	report   map[uint64]string          // Failures not yet reported upstream
+	waiters  map[uint64]chan error      // Head only: Puts waiting for their ack
+	lastUsed time.Time
+	gapSince time.Time // When the oldest early write started waiting
+	broken   error     // Set once the stream has lost writes; every later write fails with it
+
+	out      chan *common.PutArgs // Ordered writes for the successor
+	acks     chan struct{}        // Wakes the ack loop when there is news for upstream
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (s *chainStream) isTail() bool { return s.pos == len(s.members)-1 }
+
+// stream returns the stream for a chain, creating it on first use. Where this
+// worker sits in the chain follows from how much of it is left to forward to.
+func (w *KVWorker) stream(id, forwardTo string) (*chainStream, error) {
+	w.streamsMu.Lock()
+	defer w.streamsMu.Unlock()
+	if s, ok := w.streams[id]; ok {
+		return s, nil
+	}
+	members := common.ChainMembers(id)
+	rest := 0
+	if forwardTo != "" {
+		rest = len(strings.Split(forwardTo, ","))
+	}
+	pos := len(members) - 1 - rest
+	if pos < 0 {
+		return nil, fmt.Errorf("malformed chain %q (forwarding to %q)", id, forwardTo)
+	}
+	s := &chainStream{
+		id:       id,
+		members:  members,
+		pos:      pos,
+		early:    make(map[uint64]*common.PutArgs),
+		unacked:  make(map[uint64]keyVersion),
+		failed:   make(map[uint64]bool),
+		report:   make(map[uint64]string),
+		waiters:  make(map[uint64]chan error),
+		lastUsed: time.Now(),
+		out:      make(chan *common.PutArgs, streamQueue),
+		acks:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	if !s.isTail() {
+		go w.sendLoop(s)
+	}
+	if s.pos > 0 {
+		go w.ackLoop(s)
+	}
+	w.streams[id] = s
+	return s, nil
+}
+
+// putPipelined handles a Put that belongs to a chain stream.
+func (w *KVWorker) putPipelined(args *common.PutArgs, reply *common.PutReply) error {
+	s, err := w.stream(args.ChainID, args.ForwardTo)
+	if err != nil {
+		return err
+	}
+	if args.Seq > 0 {
+		return w.receive(s, args) // Acknowledged asynchronously, through ChainAck
+	}
+	if s.pos != 0 {
+		return fmt.Errorf("put %s: unsequenced write sent to chain node %d of %s", args.Key, s.pos, s.id)
+	}
+
+	// We are the head: order the write, then wait for the tail to acknowledge it.
+	ctx, cancel := common.WithDeadline(context.Background(), args.Deadline, forwardTimeout)
+	defer cancel()
+	seq, done, err := w.sequence(s, args)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			return err // Stays dirty here; reads will consult the tail
+		}
+		reply.Committed = true
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.waiters, seq)
+		s.mu.Unlock()
+		return fmt.Errorf("put %s: waiting for the chain tail: %v", args.Key, ctx.Err())
+	}
+}
+
+// sequence applies a new write at the head and assigns it the next sequence number.
+func (w *KVWorker) sequence(s *chainStream, args *common.PutArgs) (uint64, chan error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := w.writeLocal(args); err != nil {
+		return 0, nil, err
+	}
+	seq := s.applied + 1
+	s.applied = seq
+	s.lastUsed = time.Now()
+	done := make(chan error, 1)
+	s.waiters[seq] = done
+
+	sequenced := *args
+	sequenced.Seq = seq
+	w.afterApplyLocked(s, &sequenced)
+	return seq, done, nil
+}
+
+// receive applies a sequenced write from our predecessor, in order.
+func (w *KVWorker) receive(s *chainStream, args *common.PutArgs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastUsed = now
+	if s.broken != nil {
+		return s.broken
+	}
+	if args.Seq <= s.applied {
+		// Writes are never resent, so our predecessor has started the stream over.
+		if args.Seq != 1 || !s.settledLocked() {
+			prev := s.members[s.pos-1]
+			return w.breakLocked(s, common.ChainLinkError(prev, fmt.Errorf("stream %s restarted at #%d after #%d", s.id, args.Seq, s.applied)))
+		}
+		s.applied, s.acked = 0, 0 // It dropped its idle end: so do we
+	}
+	if args.Seq > s.applied+1 {
+		if len(s.early) == 0 {
+			s.gapSince = now
+		}
+		s.early[args.Seq] = args
+		if now.Sub(s.gapSince) > streamGapTimeout {
+			return w.breakLocked(s, w.gapError(s))
+		}
+		return nil
+	}
+	w.applyLocked(s, args)
+	for {
+		next, ok := s.early[s.applied+1]
+		if !ok {
+			break
+		}
+		delete(s.early, next.Seq)
+		w.applyLocked(s, next)
+	}
+	s.gapSince = now // Any write still early waits from here
+	return nil
+}
+
+// settledLocked reports whether every write this stream applied has been
+// acknowledged and none is waiting. Caller holds s.mu.
+func (s *chainStream) settledLocked() bool {
+	return len(s.early) == 0 && len(s.unacked) == 0 && s.acked == s.applied
+}
+
+// gapError names this node as the broken link of a stream missing writes: its
+// end of the stream was created after they were sent.
+func (w *KVWorker) gapError(s *chainStream) error {
+	missing := uint64(0)
+	for seq := range s.early {
+		if missing == 0 || seq < missing {
+			missing = seq
+		}
+	}
+	return common.ChainLinkError(s.members[s.pos], fmt.Errorf("stream %s lost the writes before #%d", s.id, missing))
+}
+
+// breakLocked gives up on a stream that has lost writes. The writes waiting
+// in it fail upstream with err, a ChainLinkError, so the master splices the
+// link out and re-drives them through the repaired chain; every later write
+// fails with err too. Caller holds s.mu.
+func (w *KVWorker) breakLocked(s *chainStream, err error) error {
+	log.Printf("[Worker-%s] Breaking stream %s: %v", w.port, s.id, err)
+	s.broken = err
+	for seq := range s.early {
+		w.failLocked(s, seq, err)
+	}
+	s.early = make(map[uint64]*common.PutArgs)
+	return err
+}
+
+// breakStalledStreams breaks every stream whose early writes have waited too
+// long for a missing one: it is not coming.
+func (w *KVWorker) breakStalledStreams(now time.Time) {
+	w.streamsMu.Lock()
+	streams := make([]*chainStream, 0, len(w.streams))
+	for _, s := range w.streams {
+		streams = append(streams, s)
+	}
+	w.streamsMu.Unlock()
+	for _, s := range streams {
+		s.mu.Lock()
+		if s.broken == nil && len(s.early) > 0 && now.Sub(s.gapSince) > streamGapTimeout {
+			w.breakLocked(s, w.gapError(s))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// applyLocked applies the write next in sequence. A write that cannot be
+// applied here, including one the head admitted at an epoch this worker has
+// moved past, is still passed on, so the stream keeps flowing; its failure
+// travels back to the head with the acks. Caller holds s.mu.
+func (w *KVWorker) applyLocked(s *chainStream, args *common.PutArgs) {
+	s.applied = args.Seq
+	err := w.fence(args.Epoch)
+	if err == nil {
+		err = w.writeLocal(args)
+	}
+	if err != nil {
+		w.failLocked(s, args.Seq, err)
+		if s.isTail() {
+			w.ackLocked(s, args.Seq)
+		} else {
+			w.sendLocked(s, args)
+		}
+		return
+	}
+	w.afterApplyLocked(s, args)
+}
+
+// afterApplyLocked passes an applied write on: downstream if there is more
+// chain, else it is committed and acknowledged. Caller holds s.mu.
+func (w *KVWorker) afterApplyLocked(s *chainStream, args *common.PutArgs) {
+	s.unacked[args.Seq] = keyVersion{Key: args.Key, Version: args.Version}
+	if s.isTail() {
+		w.ackLocked(s, args.Seq)
+		return
+	}
+	w.sendLocked(s, args)
+}
+
+// sendLocked queues a write for the successor. Caller holds s.mu.
+func (w *KVWorker) sendLocked(s *chainStream, args *common.PutArgs) {
+	select {
+	case s.out <- args:
+	case <-s.stop:
+	}
+}
+
+// ackLocked records that the tail has every write up to seq: they are
+// committed here, the head's waiting Puts are released, and the ack moves on
+// upstream. Caller holds s.mu.
+func (w *KVWorker) ackLocked(s *chainStream, seq uint64) {
+	if seq <= s.acked {
+		return
+	}
+	for q := s.acked + 1; q <= seq; q++ {
+		if kv, ok := s.unacked[q]; ok && !s.failed[q] {
+			w.commit(kv.Key, kv.Version)
+		}
+		if done, ok := s.waiters[q]; ok {
+			done <- nil
+			delete(s.waiters, q)
+		}
+		delete(s.unacked, q)
+		delete(s.failed, q)
+	}
+	s.acked = seq
+	w.notifyUpstreamLocked(s)
+}
+
+// failLocked records that the write at seq failed here or downstream: it will
+// never be committed here, a waiting Put gets the error, and the failure is
+// reported upstream ahead of any ack covering it. Caller holds s.mu.
+func (w *KVWorker) failLocked(s *chainStream, seq uint64, err error) {
+	if seq <= s.acked {
+		return
+	}
+	s.failed[seq] = true
+	if done, ok := s.waiters[seq]; ok {
+		done <- err
+		delete(s.waiters, seq)
+	}
+	if s.pos > 0 {
+		s.report[seq] = err.Error()
+	}
+	w.notifyUpstreamLocked(s)
+}
+
+func (w *KVWorker) notifyUpstreamLocked(s *chainStream) {
+	if s.pos == 0 {
+		return
+	}
+	select {
+	case s.acks <- struct{}{}:
+	default: // The ack loop is already due to send the latest news
+	}
+}
+
+// ChainAck RPC handler: news from our successor. Failures are settled before
+// the cumulative ack so a failed write is never committed. An ack from a
+// successor behind on the cluster epoch is refused; it catches up and resends.
+func (w *KVWorker) ChainAck(args *common.ChainAckArgs, reply *common.ChainAckReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("ack of %s: %w", args.ChainID, err)
+	}
+	w.streamsMu.Lock()
+	s, ok := w.streams[args.ChainID]
+	w.streamsMu.Unlock()
+	if !ok {
+		return nil // A stream we have already dropped
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for seq, msg := range args.Failed {
+		w.failLocked(s, seq, errors.New(msg))
+	}
+	w.ackLocked(s, args.Seq)
+	return nil
+}
+
+// sendLoop streams a chain's writes to our successor over one connection, in
+// order, without waiting for each to be answered.
+func (w *KVWorker) sendLoop(s *chainStream) {
+	next := s.members[s.pos+1]
+	rest := strings.Join(s.members[s.pos+2:], ",")
+	replies := make(chan *rpc.Call, streamQueue)
+	go func() {
+		for {
+			select {
+			case call := <-replies:
+				if call.Error == nil {
+					continue
+				}
+				err := call.Error
+				if _, downstream := err.(rpc.ServerError); !downstream {
+					err = common.ChainLinkError(next, err)
+				}
+				w.streamFailed(s, call.Args.(*common.PutArgs).Seq, err)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+
+	var client *rpc.Client
+	var dialErr error
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	for {
+		select {
+		case args := <-s.out:
+			if client == nil && dialErr == nil {
+				var conn net.Conn
+				if conn, dialErr = net.DialTimeout("tcp", next, forwardTimeout); dialErr == nil {
+					client = rpc.NewClient(conn)
+				}
+			}
+			if dialErr != nil {
+				w.streamFailed(s, args.Seq, common.ChainLinkError(next, dialErr))
+				continue
+			}
+			forwardArgs := *args
+			forwardArgs.ForwardTo = rest
+			client.Go("KV.Put", &forwardArgs, &common.PutReply{}, replies)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// streamFailed reports a write the successor did not take. It runs outside
+// the send loop so the loop never waits on s.mu.
+func (w *KVWorker) streamFailed(s *chainStream, seq uint64, err error) {
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.failLocked(s, seq, err)
+	}()
+}
+
+// ackLoop reports to our predecessor, one message at a time so they cannot be
+// reordered: the latest cumulative ack, plus any failures not yet reported.
+func (w *KVWorker) ackLoop(s *chainStream) {
+	prev := s.members[s.pos-1]
+	for {
+		select {
+		case <-s.acks:
+			s.mu.Lock()
+			ack := &common.ChainAckArgs{ChainID: s.id, Seq: s.acked}
+			if len(s.report) > 0 {
+				ack.Failed = s.report
+				s.report = make(map[uint64]string)
+			}
+			s.mu.Unlock()
+			ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+			ack.Epoch = w.currentEpoch()
+			err := common.Call(ctx, prev, "KV.ChainAck", ack, &common.ChainAckReply{})
+			if seen, stale := common.StaleEpoch(err); stale && w.fence(seen) == nil {
+				ack.Epoch = w.currentEpoch()
+				err = common.Call(ctx, prev, "KV.ChainAck", ack, &common.ChainAckReply{})
+			}
+			if err != nil {
+				log.Printf("[Worker-%s] Ack of %s #%d to %s failed: %v", w.port, s.id, ack.Seq, prev, err)
+			}
+			cancel()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// dropStreams closes every stream through failed (or, with failed empty, every
+// stream idle for streamIdleTimeout), failing the Puts still waiting on them.
+func (w *KVWorker) dropStreams(failed string, now time.Time) {
+	w.streamsMu.Lock()
+	defer w.streamsMu.Unlock()
+	for id, s := range w.streams {
+		if failed != "" {
+			through := false
+			for _, m := range s.members {
+				through = through || m == failed
+			}
+			if !through {
+				continue
+			}
+		} else {
+			if !s.mu.TryLock() {
+				continue // Busy, so not idle
+			}
+			idle := len(s.waiters) == 0 && now.Sub(s.lastUsed) > streamIdleTimeout*time.Duration(s.pos+1)
+			s.mu.Unlock()
+			if !idle {
+				continue
+			}
+		}
+		// Stop first: it unblocks anyone holding s.mu while queueing a write.
+		s.stopOnce.Do(func() { close(s.stop) })
+		delete(w.streams, id)
+		s.mu.Lock()
+		for seq, done := range s.waiters {
+			done <- common.ChainLinkError(failed, errors.New("spliced out of the chain"))
+			delete(s.waiters, seq)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"customise-db/common"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipeline_ConcurrentWritesCommit(t *testing.T) {
+	var workers []*KVWorker
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		w := newKVWorker(fmt.Sprint(9001+i), 0, 0)
+		workers = append(workers, w)
+		addrs = append(addrs, serveWorker(t, w))
+	}
+	chainID := common.ChainID(1, addrs)
+	head := workers[0]
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := &common.PutArgs{
+				Key:       fmt.Sprintf("k%d", i),
+				Value:     "v",
+				Version:   int64(i + 1),
+				ForwardTo: strings.Join(addrs[1:], ","),
+				ChainID:   chainID,
+				Deadline:  time.Now().Add(2 * time.Second),
+			}
+			if err := head.Put(args, &common.PutReply{}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for i, w := range workers {
+		w.mu.RLock()
+		keys, dirty := len(w.data), len(w.versions)
+		w.mu.RUnlock()
+		if keys != 50 {
+			t.Errorf("Worker %d: expected 50 keys, got %d", i, keys)
+		}
+		// The head's acks arrive before its Puts return; the middle's may trail slightly.
+		if i == 0 && dirty != 0 {
+			t.Errorf("Expected the head to have committed everything, got %d dirty keys", dirty)
+		}
+	}
+}
+
+func TestPipeline_AppliesInSequenceOrder(t *testing.T) {
+	tail := newKVWorker("9002", 0, 0)
+	chainID := common.ChainID(1, []string{"127.0.0.1:1", "tail"})
+
+	// Unversioned writes, so only the stream order decides which value wins.
+	second := &common.PutArgs{Key: "k", Value: "second", ChainID: chainID, Seq: 2}
+	first := &common.PutArgs{Key: "k", Value: "first", ChainID: chainID, Seq: 1}
+	if err := tail.Put(second, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := tail.data["k"]; ok {
+		t.Fatalf("Expected seq 2 to wait for seq 1")
+	}
+	if err := tail.Put(first, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got := tail.data["k"]; got != "second" {
+		t.Errorf("Expected seq 2 to be applied last, got %q", got)
+	}
+}
+
+func TestPipeline_DeadSuccessorReportsLink(t *testing.T) {
+	head := newKVWorker("9001", 0, 0)
+	dead := "127.0.0.1:1"
+	chainID := common.ChainID(1, []string{"head", dead})
+
+	err := head.Put(&common.PutArgs{Key: "k", Value: "v", ForwardTo: dead, ChainID: chainID}, &common.PutReply{})
+	if addr, ok := common.FailedChainLink(err); !ok || addr != dead {
+		t.Errorf("Expected a chain link error naming %s, got %v", dead, err)
+	}
+}
+
+func TestPipeline_MissingWriteBreaksTheStream(t *testing.T) {
+	tail := newKVWorker("9002", 0, 0)
+	chainID := common.ChainID(1, []string{"pred", "tail"})
+
+	// Seq 5 arrives at a stream that never saw 1-4: they were sent before
+	// this end of it existed, so they are never coming.
+	if err := tail.Put(&common.PutArgs{Key: "k", Value: "v", ChainID: chainID, Seq: 5}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	tail.breakStalledStreams(time.Now().Add(streamGapTimeout + time.Second))
+
+	s := tail.streams[chainID]
+	s.mu.Lock()
+	_, reported := s.report[5]
+	s.mu.Unlock()
+	if !reported {
+		t.Errorf("Expected seq 5 to be failed upstream")
+	}
+	err := tail.Put(&common.PutArgs{Key: "k", Value: "v", ChainID: chainID, Seq: 6}, &common.PutReply{})
+	if addr, ok := common.FailedChainLink(err); !ok || addr != "tail" {
+		t.Errorf("Expected later writes to fail with a chain link error naming the tail, got %v", err)
+	}
+}
+
+func TestPipeline_RestartedPredecessor(t *testing.T) {
+	tail := newKVWorker("9002", 0, 0)
+	chainID := common.ChainID(1, []string{"pred", "tail"})
+	put := func(value string, seq uint64) error {
+		return tail.Put(&common.PutArgs{Key: "k", Value: value, ChainID: chainID, Seq: seq}, &common.PutReply{})
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := put("old", seq); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	// Everything was acknowledged: the predecessor dropped its idle end and
+	// started a fresh stream, and so does the tail.
+	if err := put("fresh", 1); err != nil {
+		t.Fatalf("Expected a settled stream to start over, got %v", err)
+	}
+	if got := tail.data["k"]; got != "fresh" {
+		t.Errorf("Expected the fresh stream's write to apply, got %q", got)
+	}
+
+	// With a write still waiting, numbering starting over means the
+	// predecessor lost its end of the stream.
+	if err := put("early", 3); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	err := put("again", 1)
+	if addr, ok := common.FailedChainLink(err); !ok || addr != "pred" {
+		t.Errorf("Expected a chain link error naming the predecessor, got %v", err)
+	}
+}
+
+func TestPipeline_SuccessorRefusesAWriteFromAnOlderEpoch(t *testing.T) {
+	head, tail := newKVWorker("9001", 0, 0), newKVWorker("9002", 0, 0)
+	addrs := []string{serveWorker(t, head), serveWorker(t, tail)}
+	tail.fence(3) // The cluster moved on; the head has not heard yet
+
+	args := &common.PutArgs{
+		Key: "k", Value: "v", Version: 1, Epoch: 2,
+		ForwardTo: addrs[1], ChainID: common.ChainID(1, addrs),
+		Deadline: time.Now().Add(2 * time.Second),
+	}
+	if err := head.Put(args, &common.PutReply{}); !common.IsStaleRouting(err) {
+		t.Errorf("Expected the write refused as stale, got %v", err)
+	}
+	tail.mu.RLock()
+	_, stored := tail.data["k"]
+	tail.mu.RUnlock()
+	if stored {
+		t.Errorf("Expected the tail not to apply the stale write")
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Primary-backup replication.
+//
+// The master grants every healthy worker a time-bounded primary lease and
+// sends each key's requests to the first of its replicas that holds one. The
+// primary orders writes to a key, copies each to the backups the master names
+// and only then applies it, so anything it serves from its own store while the
+// lease is valid has reached every backup.
+
+// primaryLockStripes is how many locks the writes a primary orders are spread over.
+const primaryLockStripes = 64
+
+// GrantLease RPC handler: the master makes this worker eligible to act as
+// primary until the lease runs out. The lease is timed from when it arrives,
+// which is never earlier than the master's own reckoning of it.
+func (w *KVWorker) GrantLease(args *common.LeaseArgs, reply *common.LeaseReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("lease: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.leaseExpires = time.Now().Add(args.Duration)
+	return nil
+}
+
+func (w *KVWorker) holdsLease() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return time.Now().Before(w.leaseExpires)
+}
+
+// keyLock serialises the writes a primary orders for key.
+func (w *KVWorker) keyLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &w.primaryLocks[h.Sum32()%primaryLockStripes]
+}
+
+// putPrimary orders and replicates a write as the key's primary.
+func (w *KVWorker) putPrimary(args *common.PutArgs, reply *common.PutReply) error {
+	if !w.holdsLease() {
+		return fmt.Errorf("put %s: %w", args.Key, common.ErrNoLease)
+	}
+	lock := w.keyLock(args.Key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Writes are ordered by arrival here: each gets a version above the last.
+	ordered := *args
+	ordered.Primary, ordered.Backups = false, nil
+	w.mu.RLock()
+	if ordered.Incr {
+		// Backups get the result, so they store the same value we do.
+		if err := w.resolveIncrLocked(&ordered); err != nil {
+			w.mu.RUnlock()
+			return err
+		}
+	}
+	if current := w.meta[args.Key].Version; ordered.Version <= current {
+		ordered.Version = current + 1
+	}
+	w.mu.RUnlock()
+
+	ctx, cancel := common.WithDeadline(context.Background(), args.Deadline, forwardTimeout)
+	defer cancel()
+	ordered.Deadline = common.DeadlineOf(ctx)
+	if err := w.replicateToBackups(ctx, args.Backups, &ordered); err != nil {
+		return err
+	}
+	if !w.holdsLease() {
+		return fmt.Errorf("put %s: lease lapsed while replicating: %w", args.Key, common.ErrNoLease)
+	}
+	if err := w.writeLocal(&ordered); err != nil {
+		return err
+	}
+	reply.Committed = true
+	reply.Version = ordered.Version
+	if args.Incr || args.By != 0 {
+		reply.Value = ordered.Value
+	}
+	return nil
+}
+
+// replicateToBackups copies a write to every backup in parallel. An unreachable
+// backup is reported as a ChainLinkError so the master can drop it and retry.
+func (w *KVWorker) replicateToBackups(ctx context.Context, backups []string, args *common.PutArgs) error {
+	errs := make(chan error, len(backups))
+	for _, addr := range backups {
+		go func(backup string) {
+			err := common.Call(ctx, backup, "KV.Put", args, &common.PutReply{})
+			if _, remote := err.(rpc.ServerError); err != nil && !remote {
+				err = common.ChainLinkError(backup, err)
+			}
+			errs <- err
+		}(addr)
+	}
+	var firstErr error
+	for range backups {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		log.Printf("[Worker-%s] Put(%s) not replicated: %v", w.port, args.Key, firstErr)
+	}
+	return firstErr
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"customise-db/common"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrimary_RequiresLease(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	err := w.Put(&common.PutArgs{Key: "k", Value: "v", Primary: true}, &common.PutReply{})
+	if err == nil || !errors.Is(err, common.ErrNoLease) {
+		t.Errorf("Expected a write without a lease to be refused, got %v", err)
+	}
+	if err := w.Get(&common.GetArgs{Key: "k", Primary: true}, &common.GetReply{}); !errors.Is(err, common.ErrNoLease) {
+		t.Errorf("Expected a read without a lease to be refused, got %v", err)
+	}
+
+	w.GrantLease(&common.LeaseArgs{Duration: time.Minute}, &common.LeaseReply{})
+	if err := w.Get(&common.GetArgs{Key: "k", Primary: true}, &common.GetReply{}); err != nil {
+		t.Errorf("Expected a read under a lease to succeed, got %v", err)
+	}
+}
+
+func TestPrimary_OrdersAndReplicates(t *testing.T) {
+	backup := newKVWorker("9002", 0, 0)
+	backupAddr := serveWorker(t, backup)
+	primary := newKVWorker("9001", 0, 0)
+	primary.GrantLease(&common.LeaseArgs{Duration: time.Minute}, &common.LeaseReply{})
+
+	if err := primary.Put(&common.PutArgs{Key: "k", Value: "v1", Version: 10, Primary: true, Backups: []string{backupAddr}}, &common.PutReply{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// An older master version still lands after v1: the primary orders writes.
+	reply := &common.PutReply{}
+	if err := primary.Put(&common.PutArgs{Key: "k", Value: "v2", Version: 5, Primary: true, Backups: []string{backupAddr}}, reply); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if reply.Version != 11 {
+		t.Errorf("Expected the primary to order the write at version 11, got %d", reply.Version)
+	}
+	if primary.data["k"] != "v2" || backup.data["k"] != "v2" {
+		t.Errorf("Expected v2 on primary and backup, got %q and %q", primary.data["k"], backup.data["k"])
+	}
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Transactions: the worker is a participant in the master's two-phase commit.
+// Prepare locks a transaction's keys and stages its writes; the keys stay
+// locked, refusing ordinary writes and other transactions, until the master
+// decides. A commit carries the writes again and is applied even if the staged
+// copy was lost in a crash, so the master can always finish a committed
+// transaction by redelivering its decision. Decisions are idempotent.
+
+// checkTxnLockLocked refuses an ordinary write or delete of a key a prepared
+// transaction holds. Caller holds w.mu.
+func (w *KVWorker) checkTxnLockLocked(key string) error {
+	if holder, ok := w.txnLocks[key]; ok {
+		return fmt.Errorf("%s: %w: locked by transaction %s", key, common.ErrTxnConflict, holder)
+	}
+	return nil
+}
+
+// Prepare RPC handler: votes yes by locking every key, written or watched,
+// and staging the writes, or no by returning an error, leaving nothing locked.
+// A key with a chain write not yet acknowledged by the tail is a conflict.
+func (w *KVWorker) Prepare(args *common.PrepareArgs, reply *common.PrepareReply) error {
+	if !args.Deadline.IsZero() && time.Now().After(args.Deadline) {
+		return fmt.Errorf("prepare %s: %v", args.TxnID, context.DeadlineExceeded)
+	}
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("prepare %s: %w", args.TxnID, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reqCounter++
+
+	keys := append([]string(nil), args.Reads...)
+	for _, wr := range args.Writes {
+		keys = append(keys, wr.Key)
+	}
+	reply.Versions = make(map[string]int64, len(keys))
+	for _, key := range keys {
+		reply.Versions[key] = w.meta[key].Version
+	}
+	if _, ok := w.prepared[args.TxnID]; ok {
+		return nil // A retried prepare: already voted yes
+	}
+
+	for _, key := range keys {
+		if holder, ok := w.txnLocks[key]; ok && holder != args.TxnID {
+			return fmt.Errorf("prepare %s: %s: %w: locked by transaction %s", args.TxnID, key, common.ErrTxnConflict, holder)
+		}
+		// Successors apply sequenced writes without checking the lock, so
+		// the head must not lock a key while one is on its way down.
+		if _, dirty := w.versions[key]; dirty {
+			return fmt.Errorf("prepare %s: %s: %w: a chain write is still in flight", args.TxnID, key, common.ErrTxnConflict)
+		}
+	}
+	newKeys := 0
+	for _, wr := range args.Writes {
+		if _, exists := w.data[wr.Key]; !exists {
+			newKeys++
+		}
+	}
+	// Reserve room for new keys now, so a commit cannot fail for lack of it.
+	if w.maxKeys > 0 && newKeys > 0 {
+		reserved := 0
+		for key := range w.txnLocks {
+			if _, exists := w.data[key]; !exists {
+				reserved++
+			}
+		}
+		if len(w.data)+reserved+newKeys > w.maxKeys {
+			return fmt.Errorf("prepare %s: node full: max keys %d reached", args.TxnID, w.maxKeys)
+		}
+	}
+
+	for _, key := range keys {
+		w.txnLocks[key] = args.TxnID
+	}
+	w.prepared[args.TxnID] = args.Writes
+	log.Printf("[Worker-%s] Prepared transaction %s (%d writes, %d watched)", w.port, args.TxnID, len(args.Writes), len(args.Reads))
+	return nil
+}
+
+// Decide RPC handler: commits or aborts a transaction and releases its locks.
+// A decision for a transaction this worker never prepared (or already
+// decided) is applied as it stands: a commit's writes are versioned, so
+// applying one twice changes nothing.
+func (w *KVWorker) Decide(args *common.DecideArgs, reply *common.DecideReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("decide %s: %w", args.TxnID, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reqCounter++
+
+	staged := w.prepared[args.TxnID]
+	delete(w.prepared, args.TxnID)
+	for key, holder := range w.txnLocks {
+		if holder == args.TxnID {
+			delete(w.txnLocks, key)
+		}
+	}
+	if !args.Commit {
+		log.Printf("[Worker-%s] Aborted transaction %s", w.port, args.TxnID)
+		return nil
+	}
+
+	writes := args.Writes
+	if len(writes) == 0 {
+		writes = staged
+	}
+	now := time.Now()
+	for _, wr := range writes {
+		if wr.Version < w.meta[wr.Key].Version {
+			continue // A newer write got there first
+		}
+		w.markClean(wr.Key)
+		w.keepVersionLocked(wr.Key, wr.Version)
+		w.data[wr.Key] = wr.Value
+		km := keyMeta{Version: wr.Version}
+		if wr.TTL > 0 {
+			km.Expires = now.Add(wr.TTL)
+		}
+		w.meta[wr.Key] = km
+	}
+	log.Printf("[Worker-%s] Committed transaction %s (%d writes)", w.port, args.TxnID, len(writes))
+	return nil
+}
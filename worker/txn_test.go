@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"customise-db/common"
+	"testing"
+)
+
+func TestPrepare_LocksUntilDecided(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	w.Put(&common.PutArgs{Key: "a", Value: "old", Version: 1}, &common.PutReply{})
+
+	writes := []common.TxnWrite{{Key: "a", Value: "new", Version: 5}, {Key: "b", Value: "new", Version: 5}}
+	reply := &common.PrepareReply{}
+	if err := w.Prepare(&common.PrepareArgs{TxnID: "t1", Writes: writes}, reply); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if reply.Versions["a"] != 1 || reply.Versions["b"] != 0 {
+		t.Errorf("Expected the committed versions {a:1 b:0}, got %v", reply.Versions)
+	}
+	if err := w.Prepare(&common.PrepareArgs{TxnID: "t2", Writes: writes[1:]}, &common.PrepareReply{}); !common.IsTxnConflict(err) {
+		t.Errorf("Expected a second transaction on b to conflict, got %v", err)
+	}
+	if err := w.Put(&common.PutArgs{Key: "a", Value: "plain", Version: 9}, &common.PutReply{}); !common.IsTxnConflict(err) {
+		t.Errorf("Expected a plain write to a locked key to be refused, got %v", err)
+	}
+	if w.data["a"] != "old" {
+		t.Errorf("Expected staged writes invisible before commit, got %q", w.data["a"])
+	}
+
+	if err := w.Decide(&common.DecideArgs{TxnID: "t1", Commit: true, Writes: writes}, &common.DecideReply{}); err != nil {
+		t.Fatalf("Decide failed: %v", err)
+	}
+	if w.data["a"] != "new" || w.data["b"] != "new" || w.meta["a"].Version != 5 {
+		t.Errorf("Expected both writes committed at v5, got %v", w.data)
+	}
+	if err := w.Put(&common.PutArgs{Key: "a", Value: "plain", Version: 9}, &common.PutReply{}); err != nil {
+		t.Errorf("Expected the lock released after commit, got %v", err)
+	}
+}
+
+func TestDecide_AbortReleasesAndCommitSurvivesLostState(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	writes := []common.TxnWrite{{Key: "k", Value: "v", Version: 3}}
+	w.Prepare(&common.PrepareArgs{TxnID: "t1", Writes: writes}, &common.PrepareReply{})
+	w.Decide(&common.DecideArgs{TxnID: "t1"}, &common.DecideReply{})
+	if _, ok := w.data["k"]; ok || len(w.txnLocks) != 0 {
+		t.Errorf("Expected an abort to apply nothing and release k, got %v locks %v", w.data, w.txnLocks)
+	}
+
+	// A restarted worker has no staged copy; the commit carries the writes.
+	restarted := newKVWorker("9001", 0, 0)
+	if err := restarted.Decide(&common.DecideArgs{TxnID: "t2", Commit: true, Writes: writes}, &common.DecideReply{}); err != nil {
+		t.Fatalf("Decide failed: %v", err)
+	}
+	var stats common.StatsReply
+	restarted.GetStats(&common.StatsArgs{}, &stats)
+	if restarted.data["k"] != "v" || stats.Prepared != 0 {
+		t.Errorf("Expected the redelivered commit applied, got %v (%d prepared)", restarted.data, stats.Prepared)
+	}
+}
+
+func TestPrepare_LocksWatchedKeys(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	w.Put(&common.PutArgs{Key: "watched", Value: "v", Version: 4}, &common.PutReply{})
+	reply := &common.PrepareReply{}
+	if err := w.Prepare(&common.PrepareArgs{TxnID: "t1", Reads: []string{"watched"}}, reply); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if reply.Versions["watched"] != 4 {
+		t.Errorf("Expected the watched key's version 4, got %v", reply.Versions)
+	}
+	if err := w.Put(&common.PutArgs{Key: "watched", Value: "w", Version: 5}, &common.PutReply{}); !common.IsTxnConflict(err) {
+		t.Errorf("Expected a write to a watched key refused until the decision, got %v", err)
+	}
+	w.Decide(&common.DecideArgs{TxnID: "t1", Commit: true}, &common.DecideReply{})
+	if len(w.txnLocks) != 0 || w.data["watched"] != "v" {
+		t.Errorf("Expected the watched key released and unchanged, got locks %v value %q", w.txnLocks, w.data["watched"])
+	}
+}
+
+func TestPrepare_RefusesAKeyWithAChainWriteInFlight(t *testing.T) {
+	w := newKVWorker("9001", 0, 0)
+	// The head applied a write and forwarded it; the tail has not acknowledged it.
+	if err := w.writeLocal(&common.PutArgs{Key: "k", Value: "v", Version: 3, ForwardTo: "successor"}); err != nil {
+		t.Fatalf("writeLocal failed: %v", err)
+	}
+	writes := []common.TxnWrite{{Key: "k", Value: "txn"}}
+	if err := w.Prepare(&common.PrepareArgs{TxnID: "t1", Writes: writes}, &common.PrepareReply{}); !common.IsTxnConflict(err) {
+		t.Errorf("Expected a conflict while the chain write is in flight, got %v", err)
+	}
+	if len(w.txnLocks) != 0 {
+		t.Errorf("Expected nothing locked after a no vote, got %v", w.txnLocks)
+	}
+
+	w.commit("k", 3)
+	if err := w.Prepare(&common.PrepareArgs{TxnID: "t1", Writes: writes}, &common.PrepareReply{}); err != nil {
+		t.Errorf("Expected a prepare once the tail acknowledged the write, got %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+// Package worker is the storage node: it keeps a share of the keys in
+// memory and answers the master's RPCs, registered as "KV". cmd/worker runs
+// one as a process; tests can run one in-process with Serve.
+package worker
+
+import (
+	"context"
+	"customise-db/common"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// KVWorker holds the data and a mutex for thread safety.
+type KVWorker struct {
+	mu          sync.RWMutex
+	data        map[string]string
+	meta        map[string]keyMeta         // Per-key metadata, kept alongside data
+	versions    map[string][]objectVersion // Keys with uncommitted chain writes (CRAQ)
+	history     map[string][]objectVersion // Superseded versions, oldest first, for snapshot reads; see mvcc.go
+	collected   int64                      // Versions before this timestamp may have been collected
+	port        string
+	node        string // Names this process's CRDT updates; fresh per process, as a restart loses the counts made under it
+	maxKeys     int
+	maxLoad     int
+	zone        string // Zone/rack label, reported to the master in stats
+	reqCounter  int
+	currentRate int
+	cleanReads  int
+	dirtyReads  int
+
+	streamsMu sync.Mutex
+	streams   map[string]*chainStream // Chain ID -> pipelined write stream
+
+	leaseExpires time.Time                      // Primary lease granted by the master (guarded by mu)
+	primaryLocks [primaryLockStripes]sync.Mutex // Orders the writes this worker handles as primary
+
+	epochMu sync.Mutex
+	epoch   uint64 // Highest cluster epoch seen; see epoch.go
+
+	dedup *dedupTable // Writes with request IDs already applied; see dedup.go
+
+	txnLocks map[string]string            // Key -> prepared transaction holding it (guarded by mu); see txn.go
+	prepared map[string][]common.TxnWrite // Transaction ID -> staged writes (guarded by mu)
+}
+
+// forwardTimeout bounds a chain hop when the caller sent no deadline of its own.
+const forwardTimeout = 5 * time.Second
+
+// keyMeta is the bookkeeping a worker keeps for each stored key.
+type keyMeta struct {
+	Version int64     // Version of the value currently stored
+	Expires time.Time // When the key expires (zero = never)
+	CRDT    string    // CRDT type of the value ("" = a plain value)
+}
+
+// expired reports whether the key has outlived its TTL.
+func (km keyMeta) expired(now time.Time) bool {
+	return !km.Expires.IsZero() && now.After(km.Expires)
+}
+
+// ttl is the remaining time to live (0 = no expiry).
+func (km keyMeta) ttl(now time.Time) time.Duration {
+	if km.Expires.IsZero() {
+		return 0
+	}
+	return km.Expires.Sub(now)
+}
+
+// NewKVWorker creates a worker for port, limited to maxKeys keys and maxLoad
+// requests per second (0 = unlimited), in zone ("" = none).
+func NewKVWorker(port string, maxKeys, maxLoad int, zone string) *KVWorker {
+	w := newKVWorker(port, maxKeys, maxLoad)
+	w.zone = zone
+	return w
+}
+
+func newKVWorker(port string, maxKeys, maxLoad int) *KVWorker {
+	return &KVWorker{
+		data:     make(map[string]string),
+		meta:     make(map[string]keyMeta),
+		versions: make(map[string][]objectVersion),
+		history:  make(map[string][]objectVersion),
+		streams:  make(map[string]*chainStream),
+		dedup:    newDedupTable(),
+		txnLocks: make(map[string]string),
+		prepared: make(map[string][]common.TxnWrite),
+		port:     port,
+		node:     fmt.Sprintf("%s-%d", port, time.Now().UnixNano()),
+		maxKeys:  maxKeys,
+		maxLoad:  maxLoad,
+	}
+}
+
+// Put RPC handler: Coordinates storage and replication.
+func (w *KVWorker) Put(args *common.PutArgs, reply *common.PutReply) error {
+	// The caller has already given up; don't apply a write nobody is waiting for.
+	// Sequenced chain writes are applied regardless: skipping one would stall the stream.
+	if args.Seq == 0 && !args.Deadline.IsZero() && time.Now().After(args.Deadline) {
+		return fmt.Errorf("put %s: %v", args.Key, context.DeadlineExceeded)
+	}
+	// Sequenced writes were admitted (and deduplicated) by the head; refusing
+	// one mid-chain would stall the stream.
+	if args.Seq == 0 {
+		if err := w.fence(args.Epoch); err != nil {
+			return fmt.Errorf("put %s: %w", args.Key, err)
+		}
+		if args.RequestID != "" {
+			return w.putOnce(args, reply)
+		}
+	}
+	return w.put(args, reply)
+}
+
+// put applies a write that has been admitted.
+func (w *KVWorker) put(args *common.PutArgs, reply *common.PutReply) error {
+	// Replication Concern: chain writes stream through the chain (see pipeline.go),
+	// a primary copies its writes to the backups (see primary.go)
+	if args.Primary {
+		return w.putPrimary(args, reply)
+	}
+	resolved := args.Incr || args.By != 0 || args.Op != nil
+	if args.ChainID != "" {
+		if err := w.putPipelined(args, reply); err != nil {
+			return err
+		}
+	} else if args.ForwardTo != "" {
+		return fmt.Errorf("put %s: chain write without a chain ID", args.Key)
+	} else {
+		// Storage Concern: Write to local memory (with limits)
+		if err := w.writeLocal(args); err != nil {
+			return err
+		}
+		reply.Committed = true // We are the only replica written by this call
+	}
+	if resolved {
+		reply.Value, reply.Version = args.Value, args.Version // writeLocal resolved any Incr or Op
+	}
+	return nil
+}
+
+// writeLocal handles the thread-safe writing to the map.
+// A write older than the stored version is not applied, so replicas converge
+// on the latest write whatever order they receive them in. It is refused with
+// a stale-version error, except mid-chain: the head admitted it, and the
+// stream must carry on past it.
+func (w *KVWorker) writeLocal(args *common.PutArgs) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.reqCounter++ // Count as 1 request
+
+	// Sequenced writes were admitted by the chain head, which checked the
+	// lock; and while one is unacknowledged, the head will not prepare its key.
+	if args.Seq == 0 {
+		if err := w.checkTxnLockLocked(args.Key); err != nil {
+			return fmt.Errorf("put %w", err)
+		}
+	}
+	if args.Incr {
+		if err := w.resolveIncrLocked(args); err != nil {
+			return err
+		}
+	}
+	if err := w.resolveCRDTLocked(args); err != nil {
+		return err
+	}
+	key, value, version := args.Key, args.Value, args.Version
+
+	if version != 0 && version < w.meta[key].Version {
+		if args.Seq == 0 {
+			return common.StaleVersionError(key, version, w.meta[key].Version)
+		}
+		log.Printf("[Worker-%s] Put(%s) ignored: version %d older than %d", w.port, key, version, w.meta[key].Version)
+		return nil
+	}
+
+	// Check Limits
+	prevValue, exists := w.data[key]
+	if w.maxKeys > 0 && len(w.data) >= w.maxKeys {
+		// Allow updating existing keys, but reject new ones if full
+		if !exists {
+			return fmt.Errorf("node full: max keys %d reached", w.maxKeys)
+		}
+	}
+
+	// Upstream chain nodes hold the write dirty until the tail has it.
+	if args.ForwardTo != "" {
+		prev := objectVersion{Version: w.meta[key].Version, Value: prevValue, Found: exists}
+		w.markDirty(key, prev, objectVersion{Version: version, Value: value, Found: true, ForwardTo: args.ForwardTo})
+	} else {
+		w.markClean(key)
+	}
+
+	w.keepVersionLocked(key, version)
+	w.data[key] = value
+	km := keyMeta{Version: version, CRDT: args.CRDT}
+	if args.TTL > 0 {
+		km.Expires = time.Now().Add(args.TTL)
+	}
+	w.meta[key] = km
+	log.Printf("[Worker-%s] Put(%s, %s) v%d", w.port, key, value, version)
+	return nil
+}
+
+// Get RPC handler.
+// If the key is dirty here and the master told us the chain's tail, the read is
+// resolved against the tail's committed version (CRAQ); otherwise the latest
+// local value is returned.
+func (w *KVWorker) Get(args *common.GetArgs, reply *common.GetReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("get %s: %w", args.Key, err)
+	}
+	if args.AsOf != 0 {
+		return w.getAsOf(args, reply)
+	}
+	if args.Primary && !w.holdsLease() {
+		return fmt.Errorf("get %s: %w", args.Key, common.ErrNoLease)
+	}
+	w.mu.Lock() // Lock for counter update + read
+	w.reqCounter++
+	if _, dirty := w.versions[args.Key]; dirty && args.Tail != "" {
+		w.dirtyReads++
+		w.mu.Unlock()
+		return w.readDirty(args, reply)
+	}
+	w.cleanReads++
+	val, ok := w.data[args.Key]
+	km := w.meta[args.Key]
+	w.mu.Unlock()
+
+	now := time.Now()
+	if ok && km.expired(now) {
+		val, ok, km = "", false, keyMeta{}
+	}
+	reply.Value = val
+	reply.Found = ok
+	reply.Version = km.Version
+	reply.TTL = km.ttl(now)
+	reply.CRDT = km.CRDT
+	log.Printf("[Worker-%s] Get(%s) -> %s (Found: %v)", w.port, args.Key, val, ok)
+	return nil
+}
+
+// Delete RPC handler: drops a key from local storage. The master uses it to
+// trim replicas that no longer belong on this worker, passing the version it
+// read, so a write that arrived since is not dropped with it.
+func (w *KVWorker) Delete(args *common.DeleteArgs, reply *common.DeleteReply) error {
+	if err := w.fence(args.Epoch); err != nil {
+		return fmt.Errorf("delete %s: %w", args.Key, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reqCounter++
+	if err := w.checkTxnLockLocked(args.Key); err != nil {
+		return fmt.Errorf("delete %w", err)
+	}
+	if stored := w.meta[args.Key].Version; args.Version != 0 && stored > args.Version {
+		return fmt.Errorf("delete %s: %w: v%d is older than the stored v%d", args.Key, common.ErrStaleVersion, args.Version, stored)
+	}
+	_, reply.Found = w.data[args.Key]
+	delete(w.data, args.Key)
+	delete(w.meta, args.Key)
+	delete(w.versions, args.Key)
+	delete(w.history, args.Key)
+	log.Printf("[Worker-%s] Delete(%s) (Found: %v)", w.port, args.Key, reply.Found)
+	return nil
+}
+
+// GetStats returns current metrics to the Master.
+func (w *KVWorker) GetStats(args *common.StatsArgs, reply *common.StatsReply) error {
+	w.fence(args.Epoch) // Never refused: a stale master may still look
+	reply.Epoch = w.currentEpoch()
+	reply.Duplicates = w.dedup.stat()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	reply.KeyCount = len(w.data)
+	reply.RequestRate = w.currentRate
+	reply.MaxKeys = w.maxKeys
+	reply.MaxLoad = w.maxLoad
+	reply.CleanReads = w.cleanReads
+	reply.DirtyReads = w.dirtyReads
+	reply.DirtyKeys = len(w.versions)
+	reply.Zone = w.zone
+	reply.Prepared = len(w.prepared)
+	reply.OldVersions = w.oldVersionsLocked()
+	for _, km := range w.meta {
+		reply.MaxVersion = max(reply.MaxVersion, km.Version)
+	}
+
+	// Copy keys
+	reply.Keys = make([]string, 0, len(w.data))
+	for k := range w.data {
+		reply.Keys = append(reply.Keys, k)
+		if w.meta[k].CRDT != "" {
+			reply.CRDTKeys = append(reply.CRDTKeys, k)
+		}
+	}
+	return nil
+}
+
+// Serve answers the worker's RPCs on l, and keeps up its background work
+// (request rates, expiry, chain streams), until l is closed.
+func (w *KVWorker) Serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("KV", w); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.monitorLoad(stop)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+func (w *KVWorker) monitorLoad(stop <-chan struct{}) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		w.mu.Lock()
+		w.currentRate = w.reqCounter
+		w.reqCounter = 0
+		w.mu.Unlock()
+		w.expireKeys()
+		w.dropStreams("", time.Now())
+		w.breakStalledStreams(time.Now())
+	}
+}
+
+// expireKeys drops every key whose TTL has elapsed.
+func (w *KVWorker) expireKeys() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for key, km := range w.meta {
+		if km.expired(now) {
+			delete(w.data, key)
+			delete(w.meta, key)
+			delete(w.versions, key)
+			delete(w.history, key)
+			log.Printf("[Worker-%s] Expired(%s)", w.port, key)
+		}
+	}
+}
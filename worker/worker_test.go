@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"customise-db/common"
+	"testing"
+	"time"
+)
+
+func TestKVWorker_Put_Get(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	// Test Put
+	putArgs := &common.PutArgs{
+		Key:   "key1",
+		Value: "value1",
+	}
+	putReply := &common.PutReply{}
+
+	if err := worker.Put(putArgs, putReply); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Verify local storage
+	if val, ok := worker.data["key1"]; !ok || val != "value1" {
+		t.Errorf("Put failed to store value. Got %v, %v", val, ok)
+	}
+
+	// Test Get
+	getArgs := &common.GetArgs{
+		Key: "key1",
+	}
+	getReply := &common.GetReply{}
+
+	if err := worker.Get(getArgs, getReply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if !getReply.Found {
+		t.Errorf("Get returned Found=false")
+	}
+	if getReply.Value != "value1" {
+		t.Errorf("Get returned value %s, expected value1", getReply.Value)
+	}
+}
+
+func TestKVWorker_Get_NotFound(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	getArgs := &common.GetArgs{
+		Key: "non-existent",
+	}
+	getReply := &common.GetReply{}
+
+	if err := worker.Get(getArgs, getReply); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if getReply.Found {
+		t.Errorf("Get returned Found=true for non-existent key")
+	}
+}
+
+func TestKVWorker_Put_ExpiredDeadline(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	putArgs := &common.PutArgs{
+		Key:      "key1",
+		Value:    "value1",
+		Deadline: time.Now().Add(-time.Second),
+	}
+	if err := worker.Put(putArgs, &common.PutReply{}); err == nil {
+		t.Fatalf("Expected Put with an expired deadline to fail")
+	}
+	if _, ok := worker.data["key1"]; ok {
+		t.Errorf("Put with an expired deadline should not have been applied")
+	}
+}
+
+func TestKVWorker_Delete(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+	worker.data["key1"] = "value1"
+
+	reply := &common.DeleteReply{}
+	if err := worker.Delete(&common.DeleteArgs{Key: "key1"}, reply); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !reply.Found {
+		t.Errorf("Delete returned Found=false for an existing key")
+	}
+	if _, ok := worker.data["key1"]; ok {
+		t.Errorf("Delete left the key in storage")
+	}
+}
+
+func TestKVWorker_Delete_RefusesNewerVersion(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+	worker.Put(&common.PutArgs{Key: "key1", Value: "v", Version: 20}, &common.PutReply{})
+
+	if err := worker.Delete(&common.DeleteArgs{Key: "key1", Version: 10}, &common.DeleteReply{}); !common.IsStaleVersion(err) {
+		t.Errorf("Expected a delete of an older version refused, got %v", err)
+	}
+	if _, ok := worker.data["key1"]; !ok {
+		t.Fatalf("Expected the newer write kept")
+	}
+	if err := worker.Delete(&common.DeleteArgs{Key: "key1", Version: 20}, &common.DeleteReply{}); err != nil {
+		t.Errorf("Expected a delete of the stored version applied, got %v", err)
+	}
+}
+
+func TestKVWorker_Put_IgnoresOlderVersion(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	worker.Put(&common.PutArgs{Key: "key1", Value: "new", Version: 20}, &common.PutReply{})
+	worker.Put(&common.PutArgs{Key: "key1", Value: "old", Version: 10}, &common.PutReply{})
+
+	reply := &common.GetReply{}
+	worker.Get(&common.GetArgs{Key: "key1"}, reply)
+	if reply.Value != "new" || reply.Version != 20 {
+		t.Errorf("Expected newer write to win, got %q (v%d)", reply.Value, reply.Version)
+	}
+}
+
+func TestKVWorker_Put_RefusesOlderVersion(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	worker.Put(&common.PutArgs{Key: "key1", Value: "new", Version: 20}, &common.PutReply{})
+	err := worker.Put(&common.PutArgs{Key: "key1", Value: "old", Version: 10}, &common.PutReply{})
+	if !common.IsStaleVersion(err) {
+		t.Fatalf("Expected a stale-version error, got %v", err)
+	}
+	if stored, ok := common.StaleVersion(err); !ok || stored != 20 {
+		t.Errorf("Expected the error to name the stored v20, got %d (%v)", stored, ok)
+	}
+
+	stats := &common.StatsReply{}
+	worker.GetStats(&common.StatsArgs{}, stats)
+	if stats.MaxVersion != 20 {
+		t.Errorf("Expected stats to report max version 20, got %d", stats.MaxVersion)
+	}
+}
+
+func TestKVWorker_TTL(t *testing.T) {
+	worker := newKVWorker("8000", 0, 0)
+
+	worker.Put(&common.PutArgs{Key: "key1", Value: "value1", TTL: 20 * time.Millisecond}, &common.PutReply{})
+
+	reply := &common.GetReply{}
+	worker.Get(&common.GetArgs{Key: "key1"}, reply)
+	if !reply.Found || reply.TTL <= 0 {
+		t.Fatalf("Expected live key with remaining TTL, got Found=%v TTL=%v", reply.Found, reply.TTL)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	reply = &common.GetReply{}
+	worker.Get(&common.GetArgs{Key: "key1"}, reply)
+	if reply.Found {
+		t.Errorf("Expected key to be expired")
+	}
+
+	worker.expireKeys()
+	if _, ok := worker.data["key1"]; ok {
+		t.Errorf("Expected expireKeys to drop the expired key")
+	}
+}